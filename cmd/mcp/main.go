@@ -0,0 +1,159 @@
+// Command mcp serves Sai's SGF tools over the Model Context Protocol, so
+// that MCP clients (Claude Desktop, IDEs, ...) can read game records and
+// draw boards.
+//
+// By default it speaks stdio. With -http it serves the streamable HTTP
+// transport on /mcp instead. The transport has no authentication: an
+// address without a host listens on loopback only, and browsers may only
+// connect from loopback origins and those given with -origins.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sweetfish329/sai/internal/mcp"
+	"github.com/sweetfish329/sai/internal/tools"
+)
+
+const gamesURIPrefix = "sgf://games/"
+
+func main() {
+	httpAddr := flag.String("http", "", "serve streamable HTTP on this address instead of stdio")
+	gamesDir := flag.String("games", os.Getenv("SAI_GAMES_DIR"), "directory of SGF files exposed as resources")
+	origins := flag.String("origins", "", "comma-separated browser origins allowed over HTTP besides loopback")
+	flag.Parse()
+
+	// stdout belongs to the protocol.
+	log.SetOutput(os.Stderr)
+
+	server := newServer(*gamesDir)
+	for _, o := range strings.Split(*origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			server.AllowedOrigins = append(server.AllowedOrigins, o)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *httpAddr != "" {
+		addr := *httpAddr
+		// Nothing guards the tools and games but the network, so ":8081"
+		// means loopback; listening elsewhere takes an explicit host.
+		if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+			addr = net.JoinHostPort("localhost", port)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/mcp", server)
+		log.Printf("MCP server listening on %s/mcp", addr)
+		srv := &http.Server{Addr: addr, Handler: mux}
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := server.ServeStdio(ctx, os.Stdin, os.Stdout); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
+
+func newServer(gamesDir string) *mcp.Server {
+	server := mcp.NewServer("sai", "0.1.0")
+
+	addTools(server, tools.NewRegistry(tools.ReadSgfTool, tools.GenerateBoardImageTool, tools.NewVariationTool(nil)))
+
+	if gamesDir != "" {
+		server.Resources = gameDir(gamesDir)
+	}
+	return server
+}
+
+// addTools serves the tools of a registry. Board images are returned as
+// image content, everything else as JSON text.
+func addTools(server *mcp.Server, reg *tools.Registry) {
+	for _, t := range reg.Tools() {
+		t := t
+		server.AddTool(mcp.Tool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.InputSchema,
+			Handler: func(ctx context.Context, args json.RawMessage) (mcp.ToolResult, error) {
+				out, err := t.Call(ctx, args)
+				if err != nil {
+					return mcp.ToolResult{}, err
+				}
+				if img, ok := out.(tools.BoardImage); ok {
+					return mcp.ToolResult{Content: []mcp.Content{imageContent(img.Image)}}, nil
+				}
+				var content []mcp.Content
+				if v, ok := out.(*tools.Variation); ok {
+					// Send the board as an image rather than inside the JSON.
+					content = append(content, imageContent(v.Image))
+					rest := *v
+					rest.Image = ""
+					out = rest
+				}
+				b, err := json.MarshalIndent(out, "", "  ")
+				if err != nil {
+					return mcp.ToolResult{}, err
+				}
+				return mcp.ToolResult{Content: append([]mcp.Content{mcp.TextContent(string(b))}, content...)}, nil
+			},
+		})
+	}
+}
+
+// imageContent turns a PNG data URL, as the tools return it, into MCP image
+// content, which holds the raw base64.
+func imageContent(dataURL string) mcp.Content {
+	return mcp.ImageContent(dataURL[strings.Index(dataURL, ",")+1:], "image/png")
+}
+
+// gameDir exposes the SGF files of a directory as sgf://games/{name}.
+type gameDir string
+
+func (d gameDir) List(ctx context.Context) ([]mcp.Resource, error) {
+	matches, err := filepath.Glob(filepath.Join(string(d), "*.sgf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	var resources []mcp.Resource
+	for _, m := range matches {
+		name := filepath.Base(m)
+		resources = append(resources, mcp.Resource{
+			URI:      gamesURIPrefix + name,
+			Name:     name,
+			MimeType: "application/x-go-sgf",
+		})
+	}
+	return resources, nil
+}
+
+func (d gameDir) Read(ctx context.Context, uri string) (mcp.ResourceContents, error) {
+	name, ok := strings.CutPrefix(uri, gamesURIPrefix)
+	if !ok || name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, ".sgf") {
+		return mcp.ResourceContents{}, fmt.Errorf("unknown resource %q", uri)
+	}
+	b, err := os.ReadFile(filepath.Join(string(d), name))
+	if err != nil {
+		return mcp.ResourceContents{}, err
+	}
+	return mcp.ResourceContents{URI: uri, MimeType: "application/x-go-sgf", Text: string(b)}, nil
+}
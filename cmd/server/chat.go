@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sweetfish329/sai/internal/ai"
+	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/library"
+)
+
+// chatRoutes lets users hold follow-up conversations about their stored
+// games. Every question counts as an analysis against the limits.
+func chatRoutes(e *echo.Echo, lib library.Store, requireUser, limit echo.MiddlewareFunc) {
+	notFound := func(c echo.Context, err error) error {
+		if errors.Is(err, library.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Chat not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	// Questions in one chat are answered one at a time, so that no reply
+	// is lost from the history.
+	var locks chatLocks
+
+	// Starts a chat about a game. ?persona=, ?lang= and ?model= hold for
+	// all its replies.
+	e.POST("/games/:id/chats", func(c echo.Context) error {
+		if errMsg := validateAnalyzeParams(c); errMsg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errMsg})
+		}
+		uid := auth.UserFrom(c.Request().Context()).ID
+		chat := &library.Chat{
+			GameID:   c.Param("id"),
+			Model:    ai.Model(ai.AnalyzeInput{Model: c.QueryParam("model")}),
+			Persona:  c.QueryParam("persona"),
+			Language: outputLanguage(c),
+		}
+		if err := lib.SaveChat(c.Request().Context(), uid, chat); err != nil {
+			return notFound(c, err)
+		}
+		return c.JSON(http.StatusCreated, map[string]interface{}{"chat": chat})
+	}, requireUser)
+
+	e.GET("/games/:id/chats", func(c echo.Context) error {
+		uid := auth.UserFrom(c.Request().Context()).ID
+		chats, err := lib.ListChats(c.Request().Context(), uid, c.Param("id"))
+		if err != nil {
+			return notFound(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"chats": chats})
+	}, requireUser)
+
+	e.GET("/games/:id/chats/:chatId", func(c echo.Context) error {
+		uid := auth.UserFrom(c.Request().Context()).ID
+		chat, err := lib.GetChat(c.Request().Context(), uid, c.Param("id"), c.Param("chatId"))
+		if err != nil {
+			return notFound(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"chat": chat})
+	}, requireUser)
+
+	// Asks a question, {"message": "..."}, and answers with the reply.
+	e.POST("/games/:id/chats/:chatId/messages", func(c echo.Context) error {
+		creds, errMsg := credentials(c)
+		if errMsg != "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": errMsg})
+		}
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Message) == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Empty message"})
+		}
+
+		ctx := c.Request().Context()
+		uid := auth.UserFrom(ctx).ID
+		chat, err := lib.GetChat(ctx, uid, c.Param("id"), c.Param("chatId"))
+		if err != nil {
+			return notFound(c, err)
+		}
+		// The chat is the user's; read it again once earlier questions are
+		// answered.
+		defer locks.lock(chat.ID)()
+		if chat, err = lib.GetChat(ctx, uid, chat.GameID, chat.ID); err != nil {
+			return notFound(c, err)
+		}
+		g, err := lib.GetGame(ctx, uid, chat.GameID)
+		if err != nil {
+			return notFound(c, err)
+		}
+		analyses, err := lib.ListAnalyses(ctx, uid, g.ID)
+		if err != nil {
+			return notFound(c, err)
+		}
+
+		input := ai.ChatInput{
+			AnalyzeInput: ai.AnalyzeInput{
+				SgfContent: g.SGF,
+				Model:      chat.Model,
+				Persona:    chat.Persona,
+				Language:   chat.Language,
+			},
+			Analysis: latestAnalysis(analyses),
+			History:  chat.Messages,
+			Message:  body.Message,
+		}
+		output, err := ai.Chat(withCredentials(ctx, creds), input)
+		if err != nil {
+			e.Logger.Errorf("AI Error: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+
+		chat.Messages = output.History
+		chat.PromptVersion = output.PromptVersion
+		if err := lib.SaveChat(ctx, uid, chat); err != nil {
+			return notFound(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"reply":         output.Reply,
+			"chat":          chat,
+			"promptVersion": output.PromptVersion,
+			"trace":         output.Trace,
+		})
+	}, requireUser, limit)
+}
+
+// chatLocks are the locks of the chats that have questions being answered.
+type chatLocks struct {
+	mu    sync.Mutex
+	locks map[string]*chatLock
+}
+
+type chatLock struct {
+	sync.Mutex
+	// waiting counts the holder and the goroutines waiting for the lock.
+	waiting int
+}
+
+// lock locks chat id and returns the function that unlocks it. Locks are
+// dropped once nobody holds or waits for them.
+func (l *chatLocks) lock(id string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*chatLock{}
+	}
+	cl := l.locks[id]
+	if cl == nil {
+		cl = &chatLock{}
+		l.locks[id] = cl
+	}
+	cl.waiting++
+	l.mu.Unlock()
+
+	cl.Lock()
+	return func() {
+		cl.Unlock()
+		l.mu.Lock()
+		if cl.waiting--; cl.waiting == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// latestAnalysis returns the text of the newest analysis among analyses,
+// or "" when there is none.
+func latestAnalysis(analyses []*library.Analysis) string {
+	for i := len(analyses) - 1; i >= 0; i-- {
+		if analyses[i].Kind != "analyze" {
+			continue
+		}
+		var out ai.AnalyzeOutput
+		if json.Unmarshal(analyses[i].Result, &out) == nil && out.Result != "" {
+			return out.Result
+		}
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sweetfish329/sai/internal/ai"
+	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/library"
+)
+
+// recording collects what an analysis of a stored game produces and saves
+// it to the library when the analysis is done.
+type recording struct {
+	lib    library.Store
+	owner  string
+	gameID string
+	images []*library.Image
+}
+
+// saveGame stores the uploaded game for the signed-in user and starts a
+// recording for it. On failure it also returns the HTTP status to answer
+// with.
+func saveGame(c echo.Context, lib library.Store, sgfContent string) (*recording, int, error) {
+	uid := auth.UserFrom(c.Request().Context()).ID
+	g, err := library.NewGame(uid, sgfContent)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if err := lib.SaveGame(c.Request().Context(), g); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return &recording{lib: lib, owner: uid, gameID: g.ID}, 0, nil
+}
+
+// onEvent keeps the board images of a streamed analysis.
+func (r *recording) onEvent(ev ai.Event) {
+	if ev.Type != ai.EventImage {
+		return
+	}
+	data, ok := strings.CutPrefix(ev.Image, "data:image/png;base64,")
+	if !ok {
+		return
+	}
+	png, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return
+	}
+	img := &library.Image{GameID: r.gameID, MoveNumber: -1, PNG: png}
+	if f, ok := ev.Args["moveNumber"].(float64); ok {
+		img.MoveNumber = int(f)
+	}
+	r.images = append(r.images, img)
+}
+
+// save stores the analysis and its images. Failures are logged: the user
+// still gets the result.
+func (r *recording) save(ctx context.Context, kind string, input ai.AnalyzeInput, promptVersion string, output interface{}) {
+	// The images are saved on their own below; the result keeps only what
+	// each shows, in the same order.
+	if out, ok := output.(ai.AnalyzeOutput); ok {
+		images := make([]ai.BoardImage, len(out.Images))
+		for i, img := range out.Images {
+			img.Image = ""
+			images[i] = img
+		}
+		out.Images = images
+		output = out
+	}
+	result, err := json.Marshal(output)
+	if err != nil {
+		log.Printf("Failed to encode analysis: %v", err)
+		return
+	}
+	a := &library.Analysis{
+		GameID:        r.gameID,
+		Kind:          kind,
+		Model:         ai.Model(input),
+		PromptVersion: promptVersion,
+		Result:        result,
+	}
+	if err := r.lib.SaveAnalysis(ctx, r.owner, a); err != nil {
+		log.Printf("Failed to save analysis: %v", err)
+		return
+	}
+	for _, img := range r.images {
+		img.AnalysisID = a.ID
+		if err := r.lib.SaveImage(ctx, r.owner, img); err != nil {
+			log.Printf("Failed to save image: %v", err)
+		}
+	}
+}
+
+// libraryRoutes lets users list, fetch and delete their stored games.
+func libraryRoutes(e *echo.Echo, lib library.Store, requireUser echo.MiddlewareFunc) {
+	notFound := func(c echo.Context, err error) error {
+		if errors.Is(err, library.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Game not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	e.GET("/games", func(c echo.Context) error {
+		uid := auth.UserFrom(c.Request().Context()).ID
+		games, err := lib.ListGames(c.Request().Context(), uid)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"games": games})
+	}, requireUser)
+
+	e.GET("/games/:id", func(c echo.Context) error {
+		uid := auth.UserFrom(c.Request().Context()).ID
+		ctx := c.Request().Context()
+		g, err := lib.GetGame(ctx, uid, c.Param("id"))
+		if err != nil {
+			return notFound(c, err)
+		}
+		analyses, err := lib.ListAnalyses(ctx, uid, g.ID)
+		if err != nil {
+			return notFound(c, err)
+		}
+		images, err := lib.ListImages(ctx, uid, g.ID)
+		if err != nil {
+			return notFound(c, err)
+		}
+		chats, err := lib.ListChats(ctx, uid, g.ID)
+		if err != nil {
+			return notFound(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"game":     g,
+			"analyses": analyses,
+			"images":   images,
+			"chats":    chats,
+		})
+	}, requireUser)
+
+	e.GET("/games/:id/images/:imageId", func(c echo.Context) error {
+		uid := auth.UserFrom(c.Request().Context()).ID
+		img, err := lib.GetImage(c.Request().Context(), uid, c.Param("id"), c.Param("imageId"))
+		if err != nil {
+			return notFound(c, err)
+		}
+		return c.Blob(http.StatusOK, "image/png", img.PNG)
+	}, requireUser)
+
+	e.DELETE("/games/:id", func(c echo.Context) error {
+		uid := auth.UserFrom(c.Request().Context()).ID
+		if err := lib.DeleteGame(c.Request().Context(), uid, c.Param("id")); err != nil {
+			return notFound(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, requireUser)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/quota"
+	"github.com/sweetfish329/sai/internal/tools"
+	"golang.org/x/time/rate"
+)
+
+// envInt returns the integer in an environment variable, or def when it is
+// unset or not a number.
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
+
+// perMinute converts a count per minute to a rate; 0 means no limit.
+func perMinute(n int) rate.Limit {
+	if n == 0 {
+		return 0
+	}
+	return rate.Every(time.Minute / time.Duration(n))
+}
+
+// limitsFromEnv reads the analysis limits. Rates are per minute, quotas per
+// user and UTC day; 0 turns a limit off.
+func limitsFromEnv() quota.Limits {
+	return quota.Limits{
+		PerUser:       perMinute(envInt("SAI_RATE_USER", 6)),
+		PerUserBurst:  envInt("SAI_RATE_USER_BURST", 3),
+		Global:        perMinute(envInt("SAI_RATE_GLOBAL", 60)),
+		GlobalBurst:   envInt("SAI_RATE_GLOBAL_BURST", 10),
+		DailyAnalyses: envInt("SAI_QUOTA_ANALYSES", 50),
+		DailyImages:   envInt("SAI_QUOTA_IMAGES", 200),
+	}
+}
+
+// limitAnalyses counts an analysis against the signed-in user's rate and
+// quota, answering 429 with Retry-After when it is over, and counts the
+// board images the analysis generates against the image quota. Requests the
+// handler rejects with a 4xx status are given back. It runs after the auth
+// middleware.
+func limitAnalyses(l *quota.Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			uid := auth.UserFrom(ctx).ID
+			r, err := l.ReserveAnalysis(uid)
+			if err != nil {
+				var qe *quota.Error
+				if !errors.As(err, &qe) {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				}
+				return tooManyRequests(c, qe)
+			}
+			ctx = tools.WithImageGuard(ctx, func() error { return l.UseImage(uid) })
+			c.SetRequest(c.Request().WithContext(ctx))
+			err = next(c)
+			status := c.Response().Status
+			var he *echo.HTTPError
+			if !c.Response().Committed && errors.As(err, &he) {
+				status = he.Code
+			}
+			if status >= 400 && status < 500 {
+				r.Refund()
+			}
+			return err
+		}
+	}
+}
+
+// tooManyRequests answers 429 with the reason and Retry-After of a quota
+// error.
+func tooManyRequests(c echo.Context, qe *quota.Error) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(qe.RetryAfter.Seconds()))))
+	return c.JSON(http.StatusTooManyRequests, map[string]string{"error": qe.Reason})
+}
+
+// adminRoutes serves the admin endpoints. Admins are the users whose email
+// is listed in SAI_ADMINS; without sign-in the local user is one.
+func adminRoutes(e *echo.Echo, l *quota.Limiter, requireUser echo.MiddlewareFunc) {
+	admins := map[string]bool{}
+	for _, email := range strings.Split(os.Getenv("SAI_ADMINS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			admins[strings.ToLower(email)] = true
+		}
+	}
+	requireAdmin := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			u := auth.UserFrom(c.Request().Context())
+			if u.ID != auth.LocalUserID && !admins[strings.ToLower(u.Email)] {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Admins only"})
+			}
+			return next(c)
+		}
+	}
+
+	// Today's analyses and images per user, with the limits in force.
+	e.GET("/admin/usage", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"limits": l.Limits(),
+			"users":  l.Usage(),
+		})
+	}, requireUser, requireAdmin)
+}
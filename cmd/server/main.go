@@ -1,17 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/sweetfish329/sai/internal/ai"
 	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/jobs"
+	"github.com/sweetfish329/sai/internal/library"
+	"github.com/sweetfish329/sai/internal/llm"
+	"github.com/sweetfish329/sai/internal/quota"
+	"github.com/sweetfish329/sai/internal/review"
+	"github.com/sweetfish329/sai/internal/sgf"
+	"github.com/sweetfish329/sai/internal/tools"
+	"golang.org/x/oauth2"
 )
 
 type ExchangeRequest struct {
@@ -25,7 +41,130 @@ func main() {
 
 	auth.Init()
 
-	e := EchoServer()
+	// SAI_LLM_PROVIDER picks the language model backend: gemini (default),
+	// openai for any OpenAI-compatible server such as llama.cpp or Ollama,
+	// or fake to replay the replies in SAI_LLM_SCRIPT. SAI_LLM_AUTH picks
+	// whose credentials Gemini is called with: the signed-in user's (user,
+	// the default), SAI_LLM_API_KEY (api-key) or a service account
+	// (service-account, from SAI_LLM_CREDENTIALS or Application Default
+	// Credentials).
+	provider, err := llm.New(llm.Config{
+		Provider:    os.Getenv("SAI_LLM_PROVIDER"),
+		BaseURL:     os.Getenv("SAI_LLM_BASE_URL"),
+		APIKey:      os.Getenv("SAI_LLM_API_KEY"),
+		Auth:        os.Getenv("SAI_LLM_AUTH"),
+		Credentials: os.Getenv("SAI_LLM_CREDENTIALS"),
+		Script:      os.Getenv("SAI_LLM_SCRIPT"),
+	})
+	if err != nil {
+		fmt.Printf("Failed to set up language model: %v\n", err)
+		os.Exit(1)
+	}
+	ai.Provider = provider
+	if ai.RequiresAuthToken() && os.Getenv("GOOGLE_CLIENT_ID") == "" {
+		fmt.Println("Gemini runs on the signed-in user's credentials but GOOGLE_CLIENT_ID is not set; set SAI_LLM_AUTH to api-key or service-account to analyze without sign-in")
+	}
+	// SAI_LLM_MODEL replaces the default Gemini model. OpenAI-compatible
+	// servers have no model in common with Gemini, so they need it.
+	if model := os.Getenv("SAI_LLM_MODEL"); model != "" {
+		ai.DefaultModel = model
+	} else if _, ok := provider.(*llm.OpenAI); ok {
+		fmt.Println("SAI_LLM_MODEL must name the model of the OpenAI-compatible server")
+		os.Exit(1)
+	}
+	// SAI_LLM_MODELS lists the other models requests may choose with
+	// ?model=, separated by commas.
+	for _, model := range strings.Split(os.Getenv("SAI_LLM_MODELS"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			ai.Models = append(ai.Models, model)
+		}
+	}
+
+	// SAI_AGENT_MAX_TURNS bounds the model replies of an analysis,
+	// SAI_TOOL_TIMEOUT a tool call and SAI_ANALYSIS_TIMEOUT a whole
+	// analysis (Go durations such as 90s).
+	ai.MaxTurns = envInt("SAI_AGENT_MAX_TURNS", ai.MaxTurns)
+	if d, err := time.ParseDuration(os.Getenv("SAI_TOOL_TIMEOUT")); err == nil {
+		ai.ToolTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SAI_ANALYSIS_TIMEOUT")); err == nil {
+		ai.AnalysisTimeout = d
+	}
+	// SAI_CHAT_HISTORY_TOKENS is roughly how much of a chat's history is
+	// sent with each question.
+	ai.ChatHistoryTokens = envInt("SAI_CHAT_HISTORY_TOKENS", ai.ChatHistoryTokens)
+	// SAI_MISTAKE_THRESHOLDS are the points lost that make a move an
+	// inaccuracy, a mistake and a blunder, e.g. "1,3,6".
+	if v := os.Getenv("SAI_MISTAKE_THRESHOLDS"); v != "" {
+		t, err := review.ParseThresholds(v)
+		if err != nil {
+			fmt.Printf("Invalid SAI_MISTAKE_THRESHOLDS: %v\n", err)
+			os.Exit(1)
+		}
+		ai.Thresholds = t
+	}
+
+	// SAI_GTP_ENGINE is the command line of a GTP engine, e.g.
+	// "katago gtp -model model.bin.gz -config gtp.cfg".
+	if cmdline := strings.Fields(os.Getenv("SAI_GTP_ENGINE")); len(cmdline) > 0 {
+		eng, err := engine.StartGTP(context.Background(), engine.GTPOptions{Stderr: os.Stderr}, cmdline[0], cmdline[1:]...)
+		if err != nil {
+			fmt.Printf("Failed to start GTP engine: %v\n", err)
+		} else {
+			defer eng.Close()
+			ai.Engine = eng
+		}
+	}
+
+	// SAI_KATAGO_ANALYSIS is the command line of KataGo's JSON analysis
+	// engine, e.g. "katago analysis -model model.bin.gz -config analysis.cfg".
+	if cmdline := strings.Fields(os.Getenv("SAI_KATAGO_ANALYSIS")); len(cmdline) > 0 {
+		kata, err := engine.StartKataGo(engine.KataGoOptions{Stderr: os.Stderr}, cmdline[0], cmdline[1:]...)
+		if err != nil {
+			fmt.Printf("Failed to start KataGo analysis engine: %v\n", err)
+		} else {
+			defer kata.Close()
+			ai.GameEngine = kata
+			if ai.Engine == nil {
+				ai.Engine = kata
+			}
+		}
+	}
+
+	// SAI_WORKERS is the number of analyses run at the same time.
+	workers := 2
+	if n, err := strconv.Atoi(os.Getenv("SAI_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+	queue := jobs.NewQueue(jobs.NewMemoryStore(24*time.Hour), workers, 100)
+	defer queue.Close()
+
+	// SAI_DB is the file of the game library.
+	dbPath := os.Getenv("SAI_DB")
+	if dbPath == "" {
+		dbPath = "sai.db"
+	}
+	lib, err := library.OpenBolt(dbPath)
+	if err != nil {
+		fmt.Printf("Failed to open game library: %v\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	// Without a Google client ID nobody can sign in, so the server runs
+	// for a single local user.
+	var verifier *auth.Verifier
+	var sessions *auth.Sessions
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		verifier = auth.NewGoogleVerifier(clientID)
+		sessions, err = auth.NewSessions(lib, auth.OAuthConfig(), sessionKey())
+		if err != nil {
+			fmt.Printf("Failed to set up sessions: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	e := EchoServer(queue, lib, verifier, sessions, quota.New(limitsFromEnv()))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -35,24 +174,98 @@ func main() {
 	e.Logger.Fatal(e.Start(":" + port))
 }
 
-func EchoServer() *echo.Echo {
+// sessionKey returns the key session tokens are encrypted with, from
+// SAI_SESSION_KEY (32 bytes, base64). Without it a random key is used and
+// sessions end when the server restarts.
+func sessionKey() []byte {
+	if v := os.Getenv("SAI_SESSION_KEY"); v != "" {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			fmt.Printf("SAI_SESSION_KEY is not valid base64: %v\n", err)
+			os.Exit(1)
+		}
+		return key
+	}
+	fmt.Println("SAI_SESSION_KEY is not set; sessions will not survive a restart")
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// withoutSession reports whether a request carries no session cookie.
+func withoutSession(c echo.Context) bool {
+	_, err := c.Cookie(auth.SessionCookie)
+	return err != nil
+}
+
+// issueCSRFCookie sets the _csrf cookie on requests the CSRF middleware
+// skips. The middleware takes an existing cookie's value as the token, so
+// the first cookie-authenticated request after sign-in can pass the check.
+func issueCSRFCookie(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !withoutSession(c) {
+			return next(c)
+		}
+		if _, err := c.Cookie(middleware.DefaultCSRFConfig.CookieName); err == nil {
+			return next(c)
+		}
+		raw := make([]byte, 24)
+		if _, err := rand.Read(raw); err != nil {
+			return err
+		}
+		c.SetCookie(&http.Cookie{
+			Name:     middleware.DefaultCSRFConfig.CookieName,
+			Value:    base64.RawURLEncoding.EncodeToString(raw),
+			Path:     "/",
+			Expires:  time.Now().Add(time.Duration(middleware.DefaultCSRFConfig.CookieMaxAge) * time.Second),
+			SameSite: http.SameSiteStrictMode,
+		})
+		return next(c)
+	}
+}
+
+func EchoServer(queue *jobs.Queue, lib library.Store, verifier *auth.Verifier, sessions *auth.Sessions, limiter *quota.Limiter) *echo.Echo {
 	e := echo.New()
 
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
+	// Cookie-authenticated requests must echo the _csrf cookie in the
+	// X-CSRF-Token header. Requests without a session cookie, such as API
+	// clients with a bearer token or any client in local mode, carry no
+	// ambient credentials and are not exposed to CSRF. They skip the check
+	// but are still handed the cookie, so a browser holds the token the
+	// moment /auth/exchange signs it in.
+	e.Use(issueCSRFCookie)
+	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        withoutSession,
+		TokenLookup:    "header:" + echo.HeaderXCSRFToken,
+		CookiePath:     "/",
+		CookieSameSite: http.SameSiteStrictMode,
+	}))
 
 	// Static Assets
 	e.Static("/assets", "frontend/dist/assets")
 	e.File("/vite.svg", "frontend/dist/vite.svg")
 
+	// requireUser puts the signed-in user into the request context.
+	requireUser := auth.Middleware(verifier, lib, sessions)
+	limit := limitAnalyses(limiter)
+
 	e.GET("/config", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, map[string]string{
+		return c.JSON(http.StatusOK, map[string]interface{}{
 			"googleClientId": os.Getenv("GOOGLE_CLIENT_ID"),
+			// Whether sign-in must also grant access to Gemini.
+			"geminiUserCredentials": ai.RequiresAuthToken(),
 		})
 	})
 
+	// Signs in with an authorization code from the Google popup. The tokens
+	// stay on the server; the browser gets an HttpOnly session cookie.
 	e.POST("/auth/exchange", func(c echo.Context) error {
+		if sessions == nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Sign-in is not configured"})
+		}
 		var req ExchangeRequest
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
@@ -61,34 +274,58 @@ func EchoServer() *echo.Echo {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing code"})
 		}
 
-		token, err := auth.Exchange(c.Request().Context(), req.Code)
+		ctx := c.Request().Context()
+		token, err := sessions.Exchange(ctx, req.Code)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
+		idToken, _ := token.Extra("id_token").(string)
+		claims, err := verifier.Verify(ctx, idToken)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		user, err := auth.RecordUser(ctx, lib, claims)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if err := sessions.Create(c, user.ID, token); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"user": user})
+	})
+
+	// Tells the frontend who is signed in, if anyone.
+	e.GET("/auth/session", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{"user": auth.UserFrom(c.Request().Context())})
+	}, requireUser)
+
+	e.POST("/auth/logout", func(c echo.Context) error {
+		if sessions != nil {
+			if err := sessions.Destroy(c); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			}
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
 
-		// Map oauth2.Token to JSON response matching frontend expectation
-		// Frontend expects { tokens: ... } or just tokens?
-		// Hono code: `return c.json(tokens)`. `client.getToken(code)` returns object with `tokens`.
-		// oauth2.Token is strict struct.
-		// Google library returns raw token?
-		// Note: `token` struct fields are AccessToken, TokenType, RefreshToken, Expiry.
-		// We can return it directly as JSON.
-		return c.JSON(http.StatusOK, token)
+	// The coaching personas a request can pick with ?persona=.
+	e.GET("/personas", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"personas": ai.Personas(),
+			"default":  ai.DefaultPersona,
+		})
 	})
 
+	// Queues the analysis and returns its job ID at once; poll
+	// GET /jobs/:id for the result.
 	e.POST("/analyze", func(c echo.Context) error {
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Missing Authorization header"})
+		creds, errMsg := credentials(c)
+		if errMsg != "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": errMsg})
 		}
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid Authorization header"})
+		if errMsg := validateAnalyzeParams(c); errMsg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errMsg})
 		}
-		token := parts[1]
-
-		// Optional: Validate token here using auth.ValidateToken(ctx, token)
-		// but since we pass it to GenAI which checks it, we might skip.
 
 		bodyBytes, err := io.ReadAll(c.Request().Body) // Body is SGF text according to TS code
 		// TS: `const body = await c.req.text()`
@@ -100,19 +337,254 @@ func EchoServer() *echo.Echo {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Empty body"})
 		}
 
-		// Run Genkit Flow
-		output, err := ai.Analyze(c.Request().Context(), ai.AnalyzeInput{
+		rec, status, err := saveGame(c, lib, sgfContent)
+		if err != nil {
+			return c.JSON(status, map[string]string{"error": err.Error()})
+		}
+
+		input := ai.AnalyzeInput{
 			SgfContent: sgfContent,
-			AuthToken:  token,
+			Model:      c.QueryParam("model"),
+			Persona:    c.QueryParam("persona"),
+			Language:   outputLanguage(c),
+		}
+		// The job outlives the request, so take the image quota along.
+		imageGuard := tools.ImageGuardFrom(c.Request().Context())
+		// Run Genkit Flow in the background
+		job, err := queue.Submit(c.Request().Context(), auth.UserFrom(c.Request().Context()).ID, "analyze", func(ctx context.Context) (interface{}, error) {
+			ctx = tools.WithImageGuard(withCredentials(ctx, creds), imageGuard)
+			output, err := ai.AnalyzeStream(ctx, input, func(ev ai.Event) error {
+				rec.onEvent(ev)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			rec.save(ctx, "analyze", input, output.PromptVersion, output)
+			return output, nil
 		})
+		if errors.Is(err, jobs.ErrQueueFull) {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Too many analyses in progress, try again later"})
+		}
+		if err != nil {
+			e.Logger.Errorf("Job Error: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusAccepted, map[string]string{"jobId": job.ID, "gameId": rec.gameID, "status": string(job.Status)})
+	}, requireUser, limit)
 
+	// userJob returns a job of the signed-in user; other users' jobs are
+	// reported as not found.
+	userJob := func(c echo.Context) (*jobs.Job, error) {
+		ctx := c.Request().Context()
+		job, err := queue.Get(ctx, c.Param("id"))
+		if err != nil {
+			return nil, err
+		}
+		if job.Owner != auth.UserFrom(ctx).ID {
+			return nil, jobs.ErrNotFound
+		}
+		return job, nil
+	}
+
+	e.GET("/jobs/:id", func(c echo.Context) error {
+		job, err := userJob(c)
+		if errors.Is(err, jobs.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Job not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, job)
+	}, requireUser)
+
+	e.DELETE("/jobs/:id", func(c echo.Context) error {
+		job, err := userJob(c)
+		if err == nil {
+			job, err = queue.Cancel(c.Request().Context(), job.ID)
+		}
+		if errors.Is(err, jobs.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Job not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, job)
+	}, requireUser)
+
+	// Same input as /analyze, streamed as Server-Sent Events: toolCall,
+	// toolResult, image and text events while the model works, then done
+	// with the final message, or error.
+	e.POST("/analyze/stream", func(c echo.Context) error {
+		creds, errMsg := credentials(c)
+		if errMsg != "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": errMsg})
+		}
+		if errMsg := validateAnalyzeParams(c); errMsg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errMsg})
+		}
+
+		bodyBytes, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read body"})
+		}
+		sgfContent := string(bodyBytes)
+		if sgfContent == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Empty body"})
+		}
+
+		rec, status, err := saveGame(c, lib, sgfContent)
+		if err != nil {
+			return c.JSON(status, map[string]string{"error": err.Error()})
+		}
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set(echo.HeaderCacheControl, "no-cache")
+		res.Header().Set(echo.HeaderConnection, "keep-alive")
+		// Stop reverse proxies such as nginx from buffering the stream.
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+
+		input := ai.AnalyzeInput{
+			SgfContent: sgfContent,
+			Model:      c.QueryParam("model"),
+			Persona:    c.QueryParam("persona"),
+			Language:   outputLanguage(c),
+		}
+		output, err := ai.AnalyzeStream(withCredentials(c.Request().Context(), creds), input, func(ev ai.Event) error {
+			rec.onEvent(ev)
+			return writeEvent(res, ev.Type, ev)
+		})
+		if err != nil {
+			e.Logger.Errorf("AI Error: %v", err)
+			data := map[string]interface{}{"error": err.Error()}
+			var tl *ai.TurnLimitError
+			if errors.As(err, &tl) {
+				data["trace"] = tl.Trace
+			}
+			return writeEvent(res, "error", data)
+		}
+		rec.save(c.Request().Context(), "analyze", input, output.PromptVersion, output)
+		return writeEvent(res, ai.EventDone, ai.Event{Type: ai.EventDone, Text: output.Result, Analysis: output.Analysis})
+	}, requireUser, limit)
+
+	// Same input as /analyze, but the review is written back into the game
+	// record and returned as an .sgf download.
+	e.POST("/analyze/sgf", func(c echo.Context) error {
+		creds, errMsg := credentials(c)
+		if errMsg != "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": errMsg})
+		}
+		if errMsg := validateAnalyzeParams(c); errMsg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errMsg})
+		}
+
+		bodyBytes, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read body"})
+		}
+		sgfContent := string(bodyBytes)
+		if sgfContent == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Empty body"})
+		}
+
+		roots, err := sgf.Parse(sgfContent)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if len(roots) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "No game found"})
+		}
+
+		rec, status, err := saveGame(c, lib, sgfContent)
+		if err != nil {
+			return c.JSON(status, map[string]string{"error": err.Error()})
+		}
+
+		input := ai.AnalyzeInput{
+			SgfContent: sgfContent,
+			Model:      c.QueryParam("model"),
+			Persona:    c.QueryParam("persona"),
+			Language:   outputLanguage(c),
+		}
+		output, err := ai.Review(withCredentials(c.Request().Context(), creds), input)
 		if err != nil {
 			e.Logger.Errorf("AI Error: %v", err)
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
+		rec.save(c.Request().Context(), "review", input, output.PromptVersion, output)
 
-		return c.JSON(http.StatusOK, map[string]string{"result": output.Result})
-	})
+		annotations := append([]sgf.Annotation{{MoveNumber: 0, Comment: output.Summary}}, output.Annotations...)
+		if err := sgf.Annotate(roots[0], annotations); err != nil {
+			// The model occasionally points at moves or coordinates that do
+			// not exist; keep the rest of the review.
+			e.Logger.Warnf("Skipped annotations: %v", err)
+		}
+
+		data, err := sgf.Marshal(roots)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="review.sgf"`)
+		return c.Blob(http.StatusOK, "application/x-go-sgf", data)
+	}, requireUser, limit)
+
+	// Engine-only review: per-move evaluations and point loss from the
+	// local analysis engine, without the language model.
+	e.POST("/analyze/engine", func(c echo.Context) error {
+		if ai.GameEngine == nil {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "No analysis engine configured"})
+		}
+
+		bodyBytes, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read body"})
+		}
+		if len(bodyBytes) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Empty body"})
+		}
+
+		moves, err := ai.EngineReview(c.Request().Context(), string(bodyBytes))
+		if errors.Is(err, ai.ErrEngine) {
+			e.Logger.Errorf("Engine Error: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			// The record could not be read or set up on the board.
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{"moves": moves})
+	}, requireUser, limit)
+
+	// Plays hypothetical moves from a position of the game; the body is
+	// the input of the agent's exploreVariation tool as JSON.
+	e.POST("/analyze/variation", func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read body"})
+		}
+		out, err := ai.Tools.Call(c.Request().Context(), "exploreVariation", body)
+		if err != nil {
+			var qe *quota.Error
+			switch {
+			case errors.As(err, &qe):
+				return tooManyRequests(c, qe)
+			case errors.Is(err, tools.ErrEvaluation):
+				e.Logger.Errorf("Engine Error: %v", err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, out)
+	}, requireUser, limit)
+
+	libraryRoutes(e, lib, requireUser)
+	chatRoutes(e, lib, requireUser, limit)
+	adminRoutes(e, limiter, requireUser)
 
 	// SPA Fallback
 	e.GET("/*", func(c echo.Context) error {
@@ -121,3 +593,60 @@ func EchoServer() *echo.Echo {
 
 	return e
 }
+
+// writeEvent sends one Server-Sent Event with a JSON payload.
+func writeEvent(res *echo.Response, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
+
+// validateAnalyzeParams checks ?persona=, ?lang= and ?model=, which every
+// request to the coach may give. It returns an error message for the
+// client, or "" when they are valid.
+func validateAnalyzeParams(c echo.Context) string {
+	if !ai.ValidPersona(c.QueryParam("persona")) {
+		return "Unknown persona"
+	}
+	if !ai.ValidLanguage(c.QueryParam("lang")) {
+		return "Unknown language"
+	}
+	if !ai.ValidModel(c.QueryParam("model")) {
+		return "Unknown model"
+	}
+	return ""
+}
+
+// outputLanguage returns the language to answer in: ?lang= when given,
+// otherwise the best match for the browser's Accept-Language.
+func outputLanguage(c echo.Context) string {
+	if lang := c.QueryParam("lang"); lang != "" {
+		return lang
+	}
+	return ai.MatchLanguage(c.Request().Header.Get("Accept-Language"))
+}
+
+// credentials returns the signed-in user's Google credentials, which the
+// language model is called with. The second return value is an error
+// message for the client when they are required but missing.
+func credentials(c echo.Context) (oauth2.TokenSource, string) {
+	ts := auth.TokenSourceFrom(c.Request().Context())
+	if ts == nil && ai.RequiresAuthToken() {
+		return nil, "Sign in with Google to use Gemini"
+	}
+	return ts, ""
+}
+
+// withCredentials hands the user's Google credentials to the language model.
+func withCredentials(ctx context.Context, ts oauth2.TokenSource) context.Context {
+	if ts == nil {
+		return ctx
+	}
+	return llm.WithTokenSource(ctx, ts)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/jobs"
+	"github.com/sweetfish329/sai/internal/library"
+	"github.com/sweetfish329/sai/internal/quota"
+	"golang.org/x/oauth2"
+)
+
+// idToken makes an RS256 Google ID token for client-1 with key ID "k1".
+func idToken(t *testing.T, priv *rsa.PrivateKey) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	now := time.Now()
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(map[string]interface{}{
+		"iss":   "https://accounts.google.com",
+		"aud":   "client-1",
+		"sub":   "1234",
+		"email": "player@example.com",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestSignInThenPost(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stands in for Google's token endpoint.
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "at",
+			"refresh_token": "rt",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"id_token":      idToken(t, priv),
+		})
+	}))
+	defer tokens.Close()
+
+	lib, err := library.OpenBolt(filepath.Join(t.TempDir(), "sai.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	queue := jobs.NewQueue(jobs.NewMemoryStore(time.Hour), 1, 1)
+	defer queue.Close()
+	sessions, err := auth.NewSessions(lib, &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokens.URL}}, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &auth.Verifier{
+		Keys:     auth.StaticKeys{"k1": &priv.PublicKey},
+		Audience: []string{"client-1"},
+		Issuers:  auth.GoogleIssuers,
+	}
+	e := EchoServer(queue, lib, verifier, sessions, quota.New(quota.Limits{}))
+
+	// A fresh browser signs in without ever having fetched a page.
+	req := httptest.NewRequest(http.MethodPost, "/auth/exchange", strings.NewReader(`{"code":"c"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign-in: %d %s", rec.Code, rec.Body)
+	}
+	var session, csrf *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		switch c.Name {
+		case auth.SessionCookie:
+			session = c
+		case "_csrf":
+			csrf = c
+		}
+	}
+	if session == nil || csrf == nil {
+		t.Fatalf("sign-in cookies = %+v", rec.Result().Cookies())
+	}
+
+	post := func(token string) *httptest.ResponseRecorder {
+		// An empty body fails in the handler, after the CSRF check, and
+		// queues no job.
+		req := httptest.NewRequest(http.MethodPost, "/analyze", nil)
+		req.AddCookie(session)
+		req.AddCookie(csrf)
+		if token != "" {
+			req.Header.Set(echo.HeaderXCSRFToken, token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := post(csrf.Value); !strings.Contains(rec.Body.String(), "Empty body") {
+		t.Errorf("POST with token: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(""); strings.Contains(rec.Body.String(), "Empty body") {
+		t.Errorf("POST without token reached the handler: %d %s", rec.Code, rec.Body)
+	}
+}
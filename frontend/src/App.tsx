@@ -8,24 +8,86 @@ import {
 	CircularProgress,
 	Container,
 	CssBaseline,
+	MenuItem,
+	TextField,
 	ThemeProvider,
 	Toolbar,
 	Typography,
 } from "@mui/material";
-import { useState } from "react";
-import { AnalysisResult } from "./components/AnalysisResult";
+import { useEffect, useState } from "react";
+import { AnalysisResult, type AnalyzeOutput } from "./components/AnalysisResult";
 import { Login } from "./components/Login";
 import { SgfUpload } from "./components/SgfUpload";
+import { csrfHeaders, type User } from "./session";
 import { theme } from "./theme";
 
-function App() {
-	const [token, setToken] = useState<string | null>(null);
-	const [analysis, setAnalysis] = useState<string | null>(null);
+type Persona = {
+	name: string;
+	description: string;
+};
+
+type Job = {
+	id: string;
+	status: "queued" | "running" | "done" | "failed" | "cancelled";
+	result?: AnalyzeOutput;
+	error?: string;
+};
+
+// Polls an analysis job until it finishes and returns its result.
+async function waitForJob(jobId: string) {
+	for (;;) {
+		const response = await fetch(`/jobs/${jobId}`);
+		if (!response.ok) {
+			const errorText = await response.text();
+			throw new Error(`Server error: ${response.status} ${errorText}`);
+		}
+		const job: Job = await response.json();
+		if (job.status === "done" && job.result) {
+			return job.result;
+		}
+		if (job.status === "failed" || job.status === "cancelled") {
+			throw new Error(job.error || `Analysis ${job.status}`);
+		}
+		await new Promise((resolve) => setTimeout(resolve, 2000));
+	}
+}
+
+interface AppProps {
+	// Whether users sign in with Google.
+	signIn: boolean;
+	// Whether sign-in must grant access to Gemini, which is then called
+	// with the user's credentials.
+	geminiScope: boolean;
+}
+
+function App({ signIn, geminiScope }: AppProps) {
+	const [user, setUser] = useState<User | null>(null);
+	const [analysis, setAnalysis] = useState<AnalyzeOutput | null>(null);
 	const [loading, setLoading] = useState(false);
 	const [error, setError] = useState<string | null>(null);
-
-	const handleLoginSuccess = (accessToken: string) => {
-		setToken(accessToken);
+	const [personas, setPersonas] = useState<Persona[]>([]);
+	const [persona, setPersona] = useState("");
+
+	useEffect(() => {
+		fetch("/personas")
+			.then((res) => res.json())
+			.then((data) => {
+				setPersonas(data.personas);
+				setPersona(data.default);
+			})
+			.catch((err) => console.error("Failed to load personas:", err));
+	}, []);
+
+	// Picks up an existing session so a reload does not sign the user out.
+	useEffect(() => {
+		fetch("/auth/session")
+			.then((res) => (res.ok ? res.json() : null))
+			.then((data) => data && setUser(data.user))
+			.catch(() => {});
+	}, []);
+
+	const handleLoginSuccess = (user: User) => {
+		setUser(user);
 		setError(null);
 	};
 
@@ -33,14 +95,15 @@ function App() {
 		setError("Login failed. Please try again.");
 	};
 
-	const handleLogout = () => {
-		setToken(null);
+	const handleLogout = async () => {
+		await fetch("/auth/logout", { method: "POST", headers: csrfHeaders() });
+		setUser(null);
 		setAnalysis(null);
 		setError(null);
 	};
 
 	const handleAnalyze = async (content: string) => {
-		if (!content || !token) {
+		if (!content || !user) {
 			setError("Please sign in and upload an SGF file to analyze games.");
 			return;
 		}
@@ -50,11 +113,12 @@ function App() {
 		setAnalysis(null);
 
 		try {
-			const response = await fetch("/analyze", {
+			const query = persona ? `?persona=${encodeURIComponent(persona)}` : "";
+			const response = await fetch(`/analyze${query}`, {
 				method: "POST",
 				headers: {
 					"Content-Type": "text/plain",
-					Authorization: `Bearer ${token}`,
+					...csrfHeaders(),
 				},
 				body: content,
 			});
@@ -64,12 +128,9 @@ function App() {
 				throw new Error(`Server error: ${response.status} ${errorText}`);
 			}
 
-			const data = await response.json();
-			if (data.error) {
-				throw new Error(data.error);
-			}
-
-			setAnalysis(data.result);
+			const { jobId } = await response.json();
+			const data = await waitForJob(jobId);
+			setAnalysis(data);
 		} catch (err: any) {
 			setError(err.message || "An unexpected error occurred");
 		} finally {
@@ -108,7 +169,7 @@ function App() {
 					>
 						Sai - Go AI Coach
 					</Typography>
-					{token && (
+					{user && signIn && (
 						<Button
 							color="inherit"
 							startIcon={<LogoutIcon />}
@@ -121,8 +182,13 @@ function App() {
 			</AppBar>
 
 			<Container maxWidth="md" sx={{ mt: 8, mb: 8 }}>
-				{!token ? (
+				{!user && !signIn ? (
+					<Box display="flex" justifyContent="center" mt={4}>
+						<CircularProgress />
+					</Box>
+				) : !user ? (
 					<Login
+						geminiScope={geminiScope}
 						onLoginSuccess={handleLoginSuccess}
 						onLoginError={handleLoginError}
 					/>
@@ -142,6 +208,24 @@ function App() {
 							</Typography>
 						</Box>
 
+						{personas.length > 0 && (
+							<Box display="flex" justifyContent="center" mb={3}>
+								<TextField
+									select
+									label="Coaching style"
+									value={persona}
+									onChange={(e) => setPersona(e.target.value)}
+									sx={{ minWidth: 320 }}
+								>
+									{personas.map((p) => (
+										<MenuItem key={p.name} value={p.name}>
+											{p.description}
+										</MenuItem>
+									))}
+								</TextField>
+							</Box>
+						)}
+
 						<SgfUpload onUpload={handleUpload} />
 
 						{loading && (
@@ -150,7 +234,7 @@ function App() {
 							</Box>
 						)}
 
-						{analysis && <AnalysisResult result={analysis} />}
+						{analysis && <AnalysisResult output={analysis} />}
 					</>
 				)}
 
@@ -1,44 +1,168 @@
-import { Box, Divider, Paper, Typography } from "@mui/material";
+import {
+	Box,
+	Chip,
+	Divider,
+	List,
+	ListItem,
+	Paper,
+	Stack,
+	Typography,
+} from "@mui/material";
 import type React from "react";
 import ReactMarkdown from "react-markdown";
 import remarkGfm from "remark-gfm";
 
-interface AnalysisResultProps {
+type PhaseAssessment = {
+	assessment: string;
+	advantage: "black" | "white" | "even";
+};
+
+type MoveComment = {
+	moveNumber: number;
+	color: "B" | "W";
+	severity: "good" | "inaccuracy" | "mistake" | "blunder";
+	explanation: string;
+	alternative?: string;
+	image?: string;
+};
+
+export type Analysis = {
+	summary: string;
+	phases: {
+		opening: PhaseAssessment;
+		middleGame: PhaseAssessment;
+		endgame: PhaseAssessment;
+	};
+	moves: MoveComment[];
+	images?: { id: string; caption: string }[];
+};
+
+export type AnalyzeOutput = {
 	result: string;
+	analysis?: Analysis;
+	images?: { id: string; moveNumber?: number; image?: string }[];
+};
+
+interface AnalysisResultProps {
+	output: AnalyzeOutput;
 }
 
-export const AnalysisResult: React.FC<AnalysisResultProps> = ({ result }) => {
+const phases = [
+	["opening", "Opening"],
+	["middleGame", "Middle game"],
+	["endgame", "Endgame"],
+] as const;
+
+const severityColor = {
+	good: "success",
+	inaccuracy: "info",
+	mistake: "warning",
+	blunder: "error",
+} as const;
+
+export const AnalysisResult: React.FC<AnalysisResultProps> = ({ output }) => {
+	const { analysis } = output;
+	const imageOf = (id?: string) =>
+		output.images?.find((img) => img.id === id)?.image;
+
 	return (
 		<Paper elevation={3} sx={{ p: 3, mt: 3 }}>
 			<Typography variant="h5" gutterBottom color="primary">
 				AI Analysis Result
 			</Typography>
 			<Divider sx={{ mb: 2 }} />
-			<Box
-				sx={{
-					"& h1": { fontSize: "1.5rem", fontWeight: 700, mb: 1, mt: 2 },
-					"& h2": { fontSize: "1.25rem", fontWeight: 600, mb: 1, mt: 2 },
-					"& h3": { fontSize: "1.1rem", fontWeight: 600, mb: 1, mt: 2 },
-					"& p": { mb: 1, lineHeight: 1.6 },
-					"& ul, & ol": { pl: 2, mb: 1 },
-					"& li": { mb: 0.5 },
-					"& code": {
-						bgcolor: "action.hover",
-						p: 0.5,
-						borderRadius: 1,
-						fontFamily: "monospace",
-					},
-					"& pre": {
-						bgcolor: "grey.100",
-						p: 2,
-						borderRadius: 2,
-						overflowX: "auto",
-						"& code": { bgcolor: "transparent", p: 0 },
-					},
-				}}
-			>
-				<ReactMarkdown remarkPlugins={[remarkGfm]}>{result}</ReactMarkdown>
-			</Box>
+			{analysis ? (
+				<Box>
+					<Typography sx={{ mb: 2, lineHeight: 1.6 }}>
+						{analysis.summary}
+					</Typography>
+					{phases.map(([key, title]) => (
+						<Box key={key} sx={{ mb: 2 }}>
+							<Stack direction="row" spacing={1} alignItems="center">
+								<Typography variant="h6">{title}</Typography>
+								<Chip size="small" label={analysis.phases[key].advantage} />
+							</Stack>
+							<Typography sx={{ lineHeight: 1.6 }}>
+								{analysis.phases[key].assessment}
+							</Typography>
+						</Box>
+					))}
+					<Typography variant="h6">Key moves</Typography>
+					<List>
+						{analysis.moves.map((m) => (
+							<ListItem
+								key={m.moveNumber}
+								sx={{ display: "block", px: 0 }}
+								divider
+							>
+								<Stack direction="row" spacing={1} alignItems="center">
+									<Typography fontWeight={600}>
+										{m.moveNumber}. {m.color === "B" ? "Black" : "White"}
+									</Typography>
+									<Chip
+										size="small"
+										label={m.severity}
+										color={severityColor[m.severity]}
+									/>
+								</Stack>
+								<Typography sx={{ mt: 1 }}>{m.explanation}</Typography>
+								{m.alternative && (
+									<Typography sx={{ mt: 1 }} color="text.secondary">
+										→ {m.alternative}
+									</Typography>
+								)}
+								{imageOf(m.image) && (
+									<Box
+										component="img"
+										src={imageOf(m.image)}
+										alt={`Move ${m.moveNumber}`}
+										sx={{ mt: 1, maxWidth: 320 }}
+									/>
+								)}
+							</ListItem>
+						))}
+					</List>
+					{analysis.images?.map((ref) => (
+						<Box key={ref.id} component="figure" sx={{ mx: 0 }}>
+							<Box
+								component="img"
+								src={imageOf(ref.id)}
+								alt={ref.caption}
+								sx={{ maxWidth: 320 }}
+							/>
+							<Typography component="figcaption" color="text.secondary">
+								{ref.caption}
+							</Typography>
+						</Box>
+					))}
+				</Box>
+			) : (
+				<Box
+					sx={{
+						"& h1": { fontSize: "1.5rem", fontWeight: 700, mb: 1, mt: 2 },
+						"& h2": { fontSize: "1.25rem", fontWeight: 600, mb: 1, mt: 2 },
+						"& h3": { fontSize: "1.1rem", fontWeight: 600, mb: 1, mt: 2 },
+						"& p": { mb: 1, lineHeight: 1.6 },
+						"& ul, & ol": { pl: 2, mb: 1 },
+						"& li": { mb: 0.5 },
+						"& code": {
+							bgcolor: "action.hover",
+							p: 0.5,
+							borderRadius: 1,
+							fontFamily: "monospace",
+						},
+						"& pre": {
+							bgcolor: "grey.100",
+							p: 2,
+							borderRadius: 2,
+							overflowX: "auto",
+							"& code": { bgcolor: "transparent", p: 0 },
+						},
+					}}
+				>
+					<ReactMarkdown remarkPlugins={[remarkGfm]}>{output.result}</ReactMarkdown>
+				</Box>
+			)}
 		</Paper>
 	);
 };
@@ -1,12 +1,19 @@
 import { Box, Button, Typography } from "@mui/material";
 import { useGoogleLogin } from "@react-oauth/google";
+import { csrfHeaders, type User } from "../session";
 
 interface LoginProps {
-	onLoginSuccess: (token: string) => void;
+	// Whether to ask for access to Gemini on the user's behalf.
+	geminiScope: boolean;
+	onLoginSuccess: (user: User) => void;
 	onLoginError: () => void;
 }
 
-export const Login = ({ onLoginSuccess, onLoginError }: LoginProps) => {
+export const Login = ({
+	geminiScope,
+	onLoginSuccess,
+	onLoginError,
+}: LoginProps) => {
 	const login = useGoogleLogin({
 		onSuccess: async (codeResponse) => {
 			try {
@@ -14,6 +21,7 @@ export const Login = ({ onLoginSuccess, onLoginError }: LoginProps) => {
 					method: "POST",
 					headers: {
 						"Content-Type": "application/json",
+						...csrfHeaders(),
 					},
 					body: JSON.stringify({ code: codeResponse.code }),
 				});
@@ -22,9 +30,9 @@ export const Login = ({ onLoginSuccess, onLoginError }: LoginProps) => {
 					throw new Error("Failed to exchange code");
 				}
 
-				const tokens = await response.json();
-				// Pass the access_token to the parent
-				onLoginSuccess(tokens.access_token);
+				// The tokens stay on the server behind the session cookie.
+				const { user } = await response.json();
+				onLoginSuccess(user);
 			} catch (error) {
 				console.error("Login failed:", error);
 				onLoginError();
@@ -35,7 +43,10 @@ export const Login = ({ onLoginSuccess, onLoginError }: LoginProps) => {
 			onLoginError();
 		},
 		flow: "auth-code",
-		scope: "https://www.googleapis.com/auth/generative-language.retriever", // Add scopes if needed, or default
+		// openid, email and profile make Google return an ID token.
+		scope: geminiScope
+			? "openid email profile https://www.googleapis.com/auth/generative-language.retriever"
+			: "openid email profile",
 	});
 
 	return (
@@ -5,17 +5,22 @@ import { createRoot } from "react-dom/client";
 import "./index.css";
 import App from "./App.tsx";
 
+type Config = {
+	googleClientId: string;
+	geminiUserCredentials: boolean;
+};
+
 const Root = () => {
-	const [clientId, setClientId] = useState<string | null>(null);
+	const [config, setConfig] = useState<Config | null>(null);
 
 	useEffect(() => {
 		fetch("/config")
 			.then((res) => res.json())
-			.then((data) => setClientId(data.googleClientId))
+			.then((data) => setConfig(data))
 			.catch((err) => console.error("Failed to load config:", err));
 	}, []);
 
-	if (!clientId) {
+	if (!config) {
 		return (
 			<Box
 				display="flex"
@@ -28,10 +33,19 @@ const Root = () => {
 		);
 	}
 
+	// Without a client ID the server runs without sign-in.
+	if (!config.googleClientId) {
+		return (
+			<StrictMode>
+				<App signIn={false} geminiScope={false} />
+			</StrictMode>
+		);
+	}
+
 	return (
 		<StrictMode>
-			<GoogleOAuthProvider clientId={clientId}>
-				<App />
+			<GoogleOAuthProvider clientId={config.googleClientId}>
+				<App signIn geminiScope={config.geminiUserCredentials} />
 			</GoogleOAuthProvider>
 		</StrictMode>
 	);
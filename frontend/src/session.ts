@@ -0,0 +1,14 @@
+export interface User {
+	id: string;
+	email: string;
+	name: string;
+	picture?: string;
+}
+
+// The server sets the _csrf cookie on every GET; requests that change
+// state must echo it back so another site cannot forge them with our
+// session cookie.
+export function csrfHeaders(): Record<string, string> {
+	const match = document.cookie.match(/(?:^|;\s*)_csrf=([^;]+)/);
+	return match ? { "X-CSRF-Token": decodeURIComponent(match[1]) } : {};
+}
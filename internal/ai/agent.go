@@ -3,226 +3,451 @@ package ai
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"slices"
 
+	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/genkit"
-	"github.com/google/generative-ai-go/genai"
-	"github.com/sweetfish329/sai/internal/image"
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/game"
+	"github.com/sweetfish329/sai/internal/llm"
+	"github.com/sweetfish329/sai/internal/review"
 	"github.com/sweetfish329/sai/internal/sgf"
-	"golang.org/x/oauth2"
-	"google.golang.org/api/option"
+	"github.com/sweetfish329/sai/internal/tools"
 )
 
 type AnalyzeInput struct {
 	SgfContent string `json:"sgfContent"`
 	AuthToken  string `json:"authToken"`
+	// Model overrides DefaultModel for this request.
+	Model string `json:"model,omitempty"`
+	// Persona is the coaching style; empty means DefaultPersona.
+	Persona string `json:"persona,omitempty"`
+	// Language is the code of the language to answer in; empty means
+	// DefaultLanguage.
+	Language string `json:"language,omitempty"`
 }
 
 type AnalyzeOutput struct {
+	// Result is the analysis as the model wrote it, in Markdown.
 	Result string `json:"result"`
+	// Analysis is the same analysis in a fixed structure; nil when the
+	// model gave no answer.
+	Analysis *Analysis `json:"analysis,omitempty"`
+	// Images are the board images drawn during the analysis, which
+	// Analysis refers to by ID.
+	Images []BoardImage `json:"images,omitempty"`
+	// PromptVersion names the prompt templates the result was made with.
+	PromptVersion string `json:"promptVersion"`
+	// Trace records the tool calls that led to the result.
+	Trace *Trace `json:"trace,omitempty"`
+}
+
+// Event types reported while an analysis runs.
+const (
+	EventToolCall   = "toolCall"
+	EventToolResult = "toolResult"
+	EventImage      = "image"
+	EventText       = "text"
+	EventDone       = "done"
+)
+
+// Event is a progress report of a streamed analysis.
+type Event struct {
+	Type string `json:"type"`
+	Tool string `json:"tool,omitempty"`
+	// Args are the tool arguments without the SGF, which the client sent.
+	Args   map[string]interface{} `json:"args,omitempty"`
+	Result map[string]interface{} `json:"result,omitempty"`
+	// Image is a data URL of a generated board image; Args are those of
+	// the call that drew it.
+	Image string `json:"image,omitempty"`
+	Text  string `json:"text,omitempty"`
+	// Analysis is the structured result, sent with EventDone.
+	Analysis *Analysis `json:"analysis,omitempty"`
 }
 
 // Global flow definition
 var (
 	Kit     *genkit.Genkit
 	Analyze func(context.Context, AnalyzeInput) (AnalyzeOutput, error)
+	// AnalyzeStream is Analyze that reports tool calls, images and text to
+	// onEvent as they happen. An error from onEvent stops the analysis.
+	AnalyzeStream func(ctx context.Context, input AnalyzeInput, onEvent func(Event) error) (AnalyzeOutput, error)
+	Review        func(context.Context, AnalyzeInput) (ReviewOutput, error)
+	// Chat answers a follow-up question about a game with the agent's
+	// tools.
+	Chat func(context.Context, ChatInput) (ChatOutput, error)
 )
 
-func init() {
-	// Initialize Genkit instance.
-	// Common pattern: genkit.Init(ctx, options...)
-	Kit = genkit.Init(context.Background())
+// Provider is the language model backend. The default calls Gemini with
+// the user's access token.
+var Provider llm.Provider = &llm.Gemini{}
 
-	flow := genkit.DefineFlow(Kit, "analyzeFlow", func(ctx context.Context, input AnalyzeInput) (AnalyzeOutput, error) {
-		if input.AuthToken == "" {
-			return AnalyzeOutput{}, fmt.Errorf("missing auth token")
-		}
+// DefaultModel is the model used when a request does not name one. It is a
+// Gemini model; the server replaces it for other providers.
+var DefaultModel = "gemini-2.5-flash"
 
-		client, err := genai.NewClient(ctx, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: input.AuthToken})))
-		if err != nil {
-			return AnalyzeOutput{}, fmt.Errorf("failed to create client: %w", err)
+// Models are the models requests may name besides DefaultModel.
+var Models []string
+
+// ValidModel reports whether a request may run on name: DefaultModel, one
+// of Models, or empty for the default.
+func ValidModel(name string) bool {
+	return name == "" || name == DefaultModel || slices.Contains(Models, name)
+}
+
+// RequiresAuthToken reports whether requests must carry the user's Google
+// access token, i.e. whether Gemini is called on the user's behalf.
+func RequiresAuthToken() bool {
+	g, ok := Provider.(*llm.Gemini)
+	return ok && g.NeedsAccessToken()
+}
+
+// Model returns the model a request runs on.
+func Model(input AnalyzeInput) string {
+	if input.Model != "" {
+		return input.Model
+	}
+	return DefaultModel
+}
+
+// request prepares ctx and the model name for a call on behalf of input.
+func request(ctx context.Context, input AnalyzeInput) (context.Context, string) {
+	if input.AuthToken != "" {
+		ctx = llm.WithAccessToken(ctx, input.AuthToken)
+	}
+	return ctx, Model(input)
+}
+
+// Engine, when set, gives the agent an evaluatePosition tool backed by a
+// local Go engine.
+var Engine engine.Analyzer
+
+// GameEngine, when set, evaluates every move of the main line before the
+// model sees the game.
+var GameEngine engine.GameAnalyzer
+
+// ErrEngine marks failures of the engine, rather than of the game it was
+// given.
+var ErrEngine = errors.New("engine analysis failed")
+
+// EngineReview runs GameEngine over the main line of a game and rates every
+// move by the points it lost.
+func EngineReview(ctx context.Context, sgfContent string) ([]engine.MoveEvaluation, error) {
+	if GameEngine == nil {
+		return nil, fmt.Errorf("no analysis engine configured")
+	}
+	roots, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no game found")
+	}
+	pos, err := engine.PositionFromSGF(roots[0], -1)
+	if err != nil {
+		return nil, err
+	}
+	turns, err := GameEngine.AnalyzeGame(ctx, pos, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEngine, err)
+	}
+	return engine.EvaluateMoves(pos, turns), nil
+}
+
+// toMap converts a value to the plain JSON map expected in an
+// llm.ToolResult.
+func toMap(v interface{}) map[string]interface{} {
+	b, _ := json.Marshal(v)
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	return m
+}
+
+// Thresholds are the points lost that make a move an inaccuracy, a mistake
+// or a blunder in getKeyMistakes.
+var Thresholds = review.DefaultThresholds
+
+// keyMistakes rates every move, preferring KaTrain data in the record and
+// falling back to GameEngine, and returns the turning points of the game.
+func keyMistakes(ctx context.Context, sgfContent string, count int) (map[string]interface{}, error) {
+	roots, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no game found")
+	}
+
+	source := "katrain"
+	moves, err := review.FromSGF(roots[0])
+	if err != nil {
+		if GameEngine == nil {
+			return nil, fmt.Errorf("no engine analysis in the SGF and no analysis engine configured")
 		}
-		defer client.Close()
-		// ... (rest of the function body is same as before, I need to include it or cut it carefully)
-		// Since replace_file_content needs the whole block for what I'm replacing...
-		// I will copy the function body.
-
-		model := client.GenerativeModel("gemini-2.5-flash")
-		model.Tools = []*genai.Tool{
-			{
-				FunctionDeclarations: []*genai.FunctionDeclaration{
-					{
-						Name:        "readSgf",
-						Description: "Read and parse an SGF file content to extract game information.",
-						Parameters: &genai.Schema{
-							Type: genai.TypeObject,
-							Properties: map[string]*genai.Schema{
-								"sgfContent": {
-									Type:        genai.TypeString,
-									Description: "The content of the SGF file to parse",
-								},
-							},
-							Required: []string{"sgfContent"},
-						},
-					},
-					{
-						Name:        "generateBoardImage",
-						Description: "Generate an image of the Go board at a specific move number from an SGF file.",
-						Parameters: &genai.Schema{
-							Type: genai.TypeObject,
-							Properties: map[string]*genai.Schema{
-								"sgfContent": {
-									Type:        genai.TypeString,
-									Description: "The content of the SGF file",
-								},
-								"moveNumber": {
-									Type:        genai.TypeInteger,
-									Description: "The move number to generate the image for. If omitted, generates for the last move.",
-								},
-							},
-							Required: []string{"sgfContent"},
-						},
-					},
-				},
-			},
+		source = "engine"
+		evals, err := EngineReview(ctx, sgfContent)
+		if err != nil {
+			return nil, err
 		}
+		size, _ := game.BoardSize(roots[0])
+		moves = review.FromEngine(evals, size)
+	}
 
-		session := model.StartChat()
-		session.History = []*genai.Content{
-			{
-				Role: "user",
-				Parts: []genai.Part{
-					genai.Text("You are Sai, a Go AI coach. You analyze SGF files and provide feedback. You can also generate images of the board to illustrate your points using the generateBoardImage tool. Please ALWAYS respond in Japanese."),
-				},
-			},
-			{
-				Role: "model",
-				Parts: []genai.Part{
-					genai.Text("Understood. I am Sai, ready to analyze Go games."),
-				},
-			},
-		}
+	moves = review.Classify(moves, Thresholds)
+	return map[string]interface{}{
+		"source":        source,
+		"thresholds":    Thresholds,
+		"counts":        review.Counts(moves),
+		"turningPoints": review.TurningPoints(moves, count),
+	}, nil
+}
 
-		prompt := fmt.Sprintf(`Please analyze this Go game record (SGF). Use the readSgf tool to parse it.
+// analyzePrompt are the variables of prompts/analyze.prompt.
+type analyzePrompt struct {
+	SgfContent    string `json:"sgfContent"`
+	EngineSummary string `json:"engineSummary,omitempty"`
+}
 
-SGF Content:
-%s
+// engineSummary is the compact per-move table added to the analysis prompt.
+func engineSummary(moves []engine.MoveEvaluation) string {
+	type row struct {
+		MoveNumber int     `json:"n"`
+		Color      string  `json:"c"`
+		Move       string  `json:"m"`
+		PointsLost float64 `json:"lost"`
+		BestMove   string  `json:"best"`
+		ScoreLead  float64 `json:"lead"`
+	}
+	rows := make([]row, 0, len(moves))
+	for _, m := range moves {
+		rows = append(rows, row{
+			MoveNumber: m.MoveNumber,
+			Color:      m.Color,
+			Move:       m.Move,
+			PointsLost: math.Round(m.PointsLost*10) / 10,
+			BestMove:   m.BestMove,
+			ScoreLead:  math.Round(m.After.ScoreLead*10) / 10,
+		})
+	}
+	b, _ := json.Marshal(rows)
+	return string(b)
+}
 
-Provide a summary of the game and any advice.`, input.SgfContent)
+func evaluatePosition(ctx context.Context, sgfContent string, moveNumber int) (*engine.Evaluation, error) {
+	roots, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no game found")
+	}
+	pos, err := engine.PositionFromSGF(roots[0], moveNumber)
+	if err != nil {
+		return nil, err
+	}
+	return Engine.Analyze(ctx, pos)
+}
 
-		res, err := session.SendMessage(ctx, genai.Text(prompt))
-		if err != nil {
-			return AnalyzeOutput{}, fmt.Errorf("failed to send message: %w", err)
+type keyMistakesInput struct {
+	SgfContent string `json:"sgfContent" jsonschema_description:"The content of the SGF file"`
+	Count      int    `json:"count,omitempty" jsonschema_description:"How many turning points to return. Defaults to 5."`
+}
+
+type positionInput struct {
+	SgfContent string `json:"sgfContent" jsonschema_description:"The content of the SGF file"`
+	MoveNumber *int   `json:"moveNumber,omitempty" jsonschema_description:"The number of moves played before the position to evaluate. If omitted, evaluates the final position."`
+}
+
+// Tools are the tools of the analysis agent. Register new coaching tools
+// here; they are offered to the model with the schema of their input.
+var Tools = tools.NewRegistry(
+	tools.ReadSgfTool,
+	tools.GenerateBoardImageTool,
+	tools.New("getKeyMistakes", "Find the moves that decided the game. Uses KaTrain analysis in the SGF or the local engine, classifies every move as good, inaccuracy, mistake or blunder by points lost, and returns the worst ones. Coordinates are SGF coordinates.",
+		func(ctx context.Context, in keyMistakesInput) (map[string]interface{}, error) {
+			count := in.Count
+			if count <= 0 {
+				count = 5
+			}
+			return keyMistakes(ctx, in.SgfContent, count)
+		}),
+	evaluatePositionTool(),
+	tools.NewVariationTool(func(ctx context.Context, pos engine.Position) (*engine.Evaluation, error) {
+		if Engine == nil {
+			return nil, nil
 		}
+		return Engine.Analyze(ctx, pos)
+	}),
+)
 
-		// Tool loop
-		for {
-			if len(res.Candidates) == 0 {
-				break
+func evaluatePositionTool() *tools.Tool {
+	t := tools.New("evaluatePosition", "Evaluate the position after a given move with a real Go engine. Returns winrate and score lead from Black's point of view, the best move and candidate moves with variations, in GTP coordinates (e.g. D4).",
+		func(ctx context.Context, in positionInput) (*engine.Evaluation, error) {
+			if Engine == nil {
+				return nil, fmt.Errorf("no engine configured")
 			}
-			cand := res.Candidates[0]
-			if cand.Content == nil {
-				break
+			moveNumber := -1
+			if in.MoveNumber != nil {
+				moveNumber = *in.MoveNumber
 			}
+			return evaluatePosition(ctx, in.SgfContent, moveNumber)
+		})
+	t.Available = func() bool { return Engine != nil }
+	return t
+}
 
-			var functionCalls []genai.FunctionCall
-			for _, part := range cand.Content.Parts {
-				if fc, ok := part.(genai.FunctionCall); ok {
-					functionCalls = append(functionCalls, fc)
-				}
-			}
+// agentTools declares the available tools to the model.
+func agentTools() ([]llm.Tool, error) {
+	var decls []llm.Tool
+	for _, t := range Tools.Available() {
+		params, err := llm.SchemaFromJSON(t.InputSchema)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", t.Name, err)
+		}
+		decls = append(decls, llm.Tool{Name: t.Name, Description: t.Description, Parameters: params})
+	}
+	return decls, nil
+}
 
-			if len(functionCalls) == 0 {
-				// Final text response
-				var bufString string
-				for _, part := range cand.Content.Parts {
-					if txt, ok := part.(genai.Text); ok {
-						bufString += string(txt)
-					}
-				}
-				return AnalyzeOutput{Result: bufString}, nil
-			}
+// callTool runs one tool call of the agent. Failures are reported to the
+// model in the result rather than returned.
+func callTool(ctx context.Context, fc llm.ToolCall) map[string]interface{} {
+	args, err := json.Marshal(fc.Args)
+	if err != nil {
+		return map[string]interface{}{"error": "invalid arguments"}
+	}
+	out, err := Tools.Call(ctx, fc.Name, args)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	// Tool results must be plain JSON objects
+	return toMap(out)
+}
 
-			// Execute tools
-			var toolResponses []genai.Part
-			for _, fc := range functionCalls {
-				log.Printf("Calling tool: %s", fc.Name)
-				var toolResult map[string]interface{}
-
-				switch fc.Name {
-				case "readSgf":
-					// Parse args
-					sgfContent, ok1 := fc.Args["sgfContent"].(string)
-					if !ok1 {
-						toolResult = map[string]interface{}{"error": "invalid arguments"}
-					} else {
-						rootNodes, err := sgf.Parse(sgfContent)
-						if err != nil {
-							toolResult = map[string]interface{}{"error": err.Error()}
-						} else if len(rootNodes) == 0 {
-							toolResult = map[string]interface{}{"error": "No game found"}
-						} else {
-							data := sgf.ExtractGameData(rootNodes[0])
-							// Keep it concise? TS slices moves.
-							// Our ExtractGameData already slices moves to 20.
-							// Must convert to map[string]interface{} for GenAI SDK
-							// Simple way: Marshal to JSON then Unmarshal to map
-							resMap := map[string]interface{}{
-								"gameInfo":   data.GameInfo,
-								"movesCount": data.MovesCount,
-								"moves":      data.Moves,
-							}
-							b, _ := json.Marshal(resMap)
-							var finalMap map[string]interface{}
-							json.Unmarshal(b, &finalMap)
-							toolResult = finalMap
-						}
-					}
-				case "generateBoardImage":
-					sgfContent, ok1 := fc.Args["sgfContent"].(string)
-					moveNumVal, ok2 := fc.Args["moveNumber"]
-					moveNum := -1
-					if ok2 {
-						// json unmarshal number usually float64
-						if f, ok := moveNumVal.(float64); ok {
-							moveNum = int(f)
-						}
-					}
-
-					if !ok1 {
-						toolResult = map[string]interface{}{"error": "invalid arguments"}
-					} else {
-						imgBase64, err := image.GenerateBoardImage(sgfContent, moveNum)
-						if err != nil {
-							toolResult = map[string]interface{}{"error": err.Error()}
-						} else {
-							toolResult = map[string]interface{}{"image": imgBase64}
-						}
-					}
-				default:
-					toolResult = map[string]interface{}{"error": "unknown tool"}
-				}
-
-				// Marshal result to JSON map expected by FunctionResponse
-				// Actually GenAI Go SDK expects map[string]interface{} usually.
-				toolResponses = append(toolResponses, genai.FunctionResponse{
-					Name:     fc.Name,
-					Response: toolResult,
-				})
-			}
+// withoutSGF copies tool arguments for an event, leaving out the SGF.
+func withoutSGF(args map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		if k != "sgfContent" {
+			out[k] = v
+		}
+	}
+	return out
+}
 
-			// Send tool responses back
-			res, err = session.SendMessage(ctx, toolResponses...)
-			if err != nil {
-				return AnalyzeOutput{}, fmt.Errorf("failed to send tool response: %w", err)
+// emitResult reports a tool result. Board images get their own event so the
+// result stays small.
+func emitResult(emit func(Event) error, fc llm.ToolCall, result map[string]interface{}) error {
+	tool := fc.Name
+	img, ok := result["image"].(string)
+	if !ok {
+		return emit(Event{Type: EventToolResult, Tool: tool, Result: result})
+	}
+	if err := emit(Event{Type: EventImage, Tool: tool, Args: withoutSGF(fc.Args), Image: img}); err != nil {
+		return err
+	}
+	rest := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		if k != "image" {
+			rest[k] = v
+		}
+	}
+	return emit(Event{Type: EventToolResult, Tool: tool, Result: rest})
+}
+
+func init() {
+	// Initialize Genkit instance.
+	// Common pattern: genkit.Init(ctx, options...)
+	Kit = genkit.Init(context.Background())
+	Tools.DefineGenkit(Kit)
+
+	flow := genkit.DefineStreamingFlow(Kit, "analyzeFlow", func(ctx context.Context, input AnalyzeInput, cb core.StreamCallback[Event]) (AnalyzeOutput, error) {
+		ctx, model := request(ctx, input)
+		// The bound covers the engine review and the structured restatement
+		// as well as the agent loop.
+		if AnalysisTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, AnalysisTimeout)
+			defer cancel()
+		}
+		var onEvent func(Event) error
+		if cb != nil {
+			onEvent = func(e Event) error { return cb(ctx, e) }
+		}
+
+		vars := analyzePrompt{SgfContent: input.SgfContent}
+		if GameEngine != nil {
+			if moves, err := EngineReview(ctx, input.SgfContent); err != nil {
+				log.Printf("Engine analysis failed: %v", err)
+			} else {
+				vars.EngineSummary = engineSummary(moves)
 			}
 		}
+		system, prompt, version, err := renderPrompt("analyze", input.Persona, input.Language, vars)
+		if err != nil {
+			return AnalyzeOutput{}, err
+		}
+		decls, err := agentTools()
+		if err != nil {
+			return AnalyzeOutput{}, err
+		}
+
+		req := &llm.Request{
+			Model:    model,
+			System:   system,
+			Messages: []llm.Message{{Role: llm.RoleUser, Text: prompt}},
+			Tools:    decls,
+		}
+
+		msg, trace, err := NewRunner(onEvent).Run(ctx, req)
+		if err != nil {
+			return AnalyzeOutput{}, err
+		}
+		if msg.Text == "" {
+			return AnalyzeOutput{Result: "No response from AI", PromptVersion: version, Trace: trace}, nil
+		}
 
-		return AnalyzeOutput{Result: "No response from AI"}, nil
+		// Restate the answer in the Analysis structure, without the tool
+		// calls that led to it.
+		images := collectImages(req.Messages)
+		answer := *msg
+		answer.Role = llm.RoleModel
+		conv := []llm.Message{req.Messages[0], answer}
+		// The free-text result stands on its own, so a structure the model
+		// cannot get right only costs the structured view.
+		analysis, err := structure(ctx, model, system, conv, input.SgfContent, images, &trace.Usage)
+		if err != nil {
+			if ctx.Err() != nil {
+				return AnalyzeOutput{}, ctx.Err()
+			}
+			log.Printf("Structured analysis failed: %v", err)
+		}
+		s := tasks["structure"].meta
+		return AnalyzeOutput{
+			Result:        msg.Text,
+			Analysis:      analysis,
+			Images:        images,
+			PromptVersion: fmt.Sprintf("%s %s@%s", version, s.Name, s.Version),
+			Trace:         trace,
+		}, nil
 	})
 
 	Analyze = func(ctx context.Context, input AnalyzeInput) (AnalyzeOutput, error) {
 		return flow.Run(ctx, input)
 	}
+	AnalyzeStream = func(ctx context.Context, input AnalyzeInput, onEvent func(Event) error) (AnalyzeOutput, error) {
+		// Flow.Stream's iterator cannot be left early, so run the action
+		// with our own callback.
+		return (*core.ActionDef[AnalyzeInput, AnalyzeOutput, Event])(flow).Run(ctx, input, func(ctx context.Context, e Event) error {
+			return onEvent(e)
+		})
+	}
+
+	Review = defineReviewFlow(Kit)
+	Chat = defineChatFlow(Kit)
 }
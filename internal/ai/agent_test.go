@@ -0,0 +1,527 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/llm"
+	"github.com/sweetfish329/sai/internal/tools"
+)
+
+const testSGF = "(;GM[1]FF[4]SZ[9]KM[6.5]PB[Black]PW[White];B[ee];W[gc];B[cg])"
+
+// testAnalysis is a structured analysis of testSGF.
+const testAnalysis = `{"summary":"Even game.","phases":{"opening":{"assessment":"Calm.","advantage":"even"},"middleGame":{"assessment":"None yet.","advantage":"even"},"endgame":{"assessment":"None yet.","advantage":"even"}},"moves":[{"moveNumber":2,"color":"W","severity":"inaccuracy","explanation":"Too far.","alternative":"W at cc."}]}`
+
+func TestAnalyzeToolLoop(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{ID: "1", Name: "readSgf", Args: map[string]interface{}{"sgfContent": testSGF}}}},
+		{ToolCalls: []llm.ToolCall{{ID: "2", Name: "noSuchTool"}}},
+		{Text: "Black played well."},
+		{Text: testAnalysis},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+
+	out, err := Analyze(context.Background(), AnalyzeInput{SgfContent: testSGF, Model: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Result != "Black played well." {
+		t.Errorf("result = %q", out.Result)
+	}
+
+	if out.Analysis == nil || out.Analysis.Moves[0].Severity != "inaccuracy" || !strings.HasSuffix(out.PromptVersion, " structure@1") {
+		t.Errorf("analysis = %+v, version %q", out.Analysis, out.PromptVersion)
+	}
+
+	reqs := fake.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("got %d requests, want 4", len(reqs))
+	}
+	system, _, _, _ := renderPrompt("analyze", DefaultPersona, DefaultLanguage, analyzePrompt{})
+	if reqs[0].Model != "local" || reqs[0].System != system || len(reqs[0].Tools) == 0 {
+		t.Errorf("first request = %+v", reqs[0])
+	}
+
+	result := reqs[1].Messages[2]
+	if result.Role != llm.RoleTool || result.ToolResults[0].ID != "1" || result.ToolResults[0].Response["movesCount"] != 3.0 {
+		t.Errorf("readSgf result = %+v", result)
+	}
+	if r := reqs[2].Messages[4].ToolResults[0].Response; r["error"] != "unknown tool" {
+		t.Errorf("unknown tool result = %+v", r)
+	}
+}
+
+func TestStructuredAnalysis(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{Text: "Fine game."},
+		{Text: `not json`},
+		{Text: `{"summary":"Even game."}`},
+		{Text: strings.Replace(testAnalysis, `"color":"W"`, `"color":"B"`, 1)},
+		{Text: testAnalysis},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+	oldRetries := StructuredRetries
+	StructuredRetries = 3
+	defer func() { StructuredRetries = oldRetries }()
+
+	out, err := Analyze(context.Background(), AnalyzeInput{SgfContent: testSGF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Result != "Fine game." || out.Analysis == nil || out.Analysis.Summary != "Even game." {
+		t.Errorf("output = %+v", out)
+	}
+	reqs := fake.Requests()
+	if len(reqs) != 5 || reqs[1].ResponseSchema == nil || len(reqs[1].Tools) != 0 {
+		t.Fatalf("requests = %+v", reqs)
+	}
+	// Each rejected reply is sent back with the reason.
+	for i, want := range []string{"invalid JSON", "phases is required", "move 2 was played by W, not B"} {
+		msgs := reqs[i+2].Messages
+		if last := msgs[len(msgs)-1]; last.Role != llm.RoleUser || !strings.Contains(last.Text, want) {
+			t.Errorf("retry %d: %q, want %q", i+1, last.Text, want)
+		}
+	}
+
+	fake = &llm.Scripted{Replies: []llm.Message{{Text: "Fine game."}, {Text: `{}`}, {Text: `{}`}, {Text: `{}`}, {Text: `{}`}}}
+	Provider = fake
+	// The free-text result survives a structure that never validates.
+	if out, err := Analyze(context.Background(), AnalyzeInput{SgfContent: testSGF}); err != nil || out.Result != "Fine game." || out.Analysis != nil {
+		t.Errorf("Analyze = %+v, %v", out, err)
+	}
+	if n := len(fake.Requests()); n != 5 {
+		t.Errorf("%d requests, want 5", n)
+	}
+}
+
+func TestReviewDefaultModel(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{Text: `{"summary":"Close game.","annotations":[{"moveNumber":2,"comment":"Too far.","quality":"doubtful"}]}`},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+
+	out, err := Review(context.Background(), AnalyzeInput{SgfContent: testSGF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Summary != "Close game." || len(out.Annotations) != 1 || out.Annotations[0].MoveNumber != 2 {
+		t.Errorf("review = %+v", out)
+	}
+	if req := fake.Requests()[0]; req.Model != DefaultModel || req.ResponseSchema == nil {
+		t.Errorf("request = %+v", req)
+	}
+	if RequiresAuthToken() {
+		t.Error("RequiresAuthToken() = true for the fake provider")
+	}
+}
+
+func TestAnalyzeStream(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{Name: "generateBoardImage", Args: map[string]interface{}{"sgfContent": testSGF, "moveNumber": 2.0}}}},
+		{Text: "Look at move 2."},
+		{Text: `{"summary":"See move 2.","phases":{"opening":{"assessment":"-","advantage":"even"},"middleGame":{"assessment":"-","advantage":"even"},"endgame":{"assessment":"-","advantage":"even"}},"moves":[{"moveNumber":2,"color":"W","severity":"mistake","explanation":"Slow.","image":"image1"}],"images":[{"id":"image1","caption":"Move 2"}]}`},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+
+	var events []Event
+	out, err := AnalyzeStream(context.Background(), AnalyzeInput{SgfContent: testSGF}, func(e Event) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Result != "Look at move 2." {
+		t.Errorf("result = %q", out.Result)
+	}
+	if len(out.Images) != 1 || out.Images[0].ID != "image1" || *out.Images[0].MoveNumber != 2 || out.Analysis.Moves[0].Image != "image1" {
+		t.Errorf("images = %+v, analysis = %+v", out.Images, out.Analysis)
+	}
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []string{EventToolCall, EventImage, EventToolResult, EventText, EventText, EventText, EventText}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	if _, ok := events[0].Args["sgfContent"]; ok || events[0].Args["moveNumber"] != 2.0 {
+		t.Errorf("toolCall args = %v", events[0].Args)
+	}
+	if !strings.HasPrefix(events[1].Image, "data:image/png;base64,") {
+		t.Errorf("image = %.40q", events[1].Image)
+	}
+	if _, ok := events[2].Result["image"]; ok {
+		t.Error("toolResult repeats the image")
+	}
+
+	// An error from the callback stops the analysis.
+	fake = &llm.Scripted{Replies: []llm.Message{{Text: "Never sent."}}}
+	Provider = fake
+	stop := errors.New("client went away")
+	if _, err := AnalyzeStream(context.Background(), AnalyzeInput{SgfContent: testSGF}, func(Event) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("err = %v, want %v", err, stop)
+	}
+}
+
+func TestImageGuard(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{ID: "1", Name: "generateBoardImage", Args: map[string]interface{}{"sgfContent": testSGF}}}},
+		{Text: "No picture today."},
+		{Text: testAnalysis},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+
+	ctx := tools.WithImageGuard(context.Background(), func() error { return errors.New("daily image quota reached") })
+	var images int
+	if _, err := AnalyzeStream(ctx, AnalyzeInput{SgfContent: testSGF}, func(e Event) error {
+		if e.Type == EventImage {
+			images++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if images != 0 {
+		t.Errorf("got %d images past the guard", images)
+	}
+	if r := fake.Requests()[1].Messages[2].ToolResults[0].Response; r["error"] != "daily image quota reached" {
+		t.Errorf("tool result = %+v", r)
+	}
+}
+
+func TestRunnerTrace(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{ID: "1", Name: "readSgf", Args: map[string]interface{}{"sgfContent": testSGF}}}},
+		{ToolCalls: []llm.ToolCall{{ID: "2", Name: "noSuchTool", Args: map[string]interface{}{"x": 1.0}}}},
+		{Text: "Done."},
+		{Text: testAnalysis},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+
+	out, err := Analyze(context.Background(), AnalyzeInput{SgfContent: testSGF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := out.Trace
+	if tr == nil || tr.Turns != 3 || len(tr.ToolCalls) != 2 {
+		t.Fatalf("trace = %+v", tr)
+	}
+	if c := tr.ToolCalls[0]; c.Turn != 1 || c.Tool != "readSgf" || c.Error != "" || c.Args["sgfContent"] != nil {
+		t.Errorf("first call = %+v", c)
+	}
+	if c := tr.ToolCalls[1]; c.Turn != 2 || c.Error != "unknown tool" || c.Args["x"] != 1.0 {
+		t.Errorf("second call = %+v", c)
+	}
+}
+
+func TestRunnerTurnLimit(t *testing.T) {
+	loop := llm.Message{ToolCalls: []llm.ToolCall{{Name: "readSgf", Args: map[string]interface{}{"sgfContent": testSGF}}}}
+	r := &Runner{
+		Provider: &llm.Scripted{Replies: []llm.Message{loop, loop, loop}},
+		Call:     callTool,
+		MaxTurns: 2,
+	}
+	_, trace, err := r.Run(context.Background(), &llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Text: "Go"}}})
+	var tl *TurnLimitError
+	if !errors.As(err, &tl) || tl.MaxTurns != 2 {
+		t.Fatalf("err = %v, want TurnLimitError", err)
+	}
+	if trace.Turns != 2 || len(trace.ToolCalls) != 2 || tl.Trace != trace {
+		t.Errorf("trace = %+v", trace)
+	}
+}
+
+func TestRunnerTimeouts(t *testing.T) {
+	stuck := func(ctx context.Context, fc llm.ToolCall) map[string]interface{} {
+		if fc.Name == "slow" {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+		}
+		return map[string]interface{}{"ok": true}
+	}
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{Name: "slow"}}},
+		{Text: "Gave up on it."},
+	}}
+	r := &Runner{Provider: fake, Call: stuck, ToolTimeout: 20 * time.Millisecond}
+	msg, trace, err := r.Run(context.Background(), &llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Text: "Go"}}})
+	if err != nil || msg.Text != "Gave up on it." {
+		t.Fatalf("Run = %+v, %v", msg, err)
+	}
+	if trace.ToolCalls[0].Error != "tool call timed out" {
+		t.Errorf("trace = %+v", trace.ToolCalls)
+	}
+	if r := fake.Requests()[1].Messages[2].ToolResults[0].Response; r["error"] != "tool call timed out" {
+		t.Errorf("tool result = %+v", r)
+	}
+
+	// The total deadline ends the run, even inside a tool.
+	fake = &llm.Scripted{Replies: []llm.Message{{ToolCalls: []llm.ToolCall{{Name: "slow"}}}}}
+	r = &Runner{Provider: fake, Call: stuck, Timeout: 20 * time.Millisecond}
+	if _, _, err := r.Run(context.Background(), &llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Text: "Go"}}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+}
+
+// stalled answers from its script but never returns a structured reply.
+type stalled struct{ *llm.Scripted }
+
+func (s stalled) Generate(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	if req.ResponseSchema != nil {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.Scripted.Generate(ctx, req)
+}
+
+func TestAnalysisTimeout(t *testing.T) {
+	old := Provider
+	Provider = stalled{&llm.Scripted{Replies: []llm.Message{{Text: "Fine game."}}}}
+	defer func() { Provider = old }()
+	oldTimeout := AnalysisTimeout
+	AnalysisTimeout = 20 * time.Millisecond
+	defer func() { AnalysisTimeout = oldTimeout }()
+
+	// The deadline reaches past the agent loop into the structured step.
+	if _, err := Analyze(context.Background(), AnalyzeInput{SgfContent: testSGF}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+}
+
+type failingEngine struct{}
+
+func (failingEngine) AnalyzeGame(ctx context.Context, pos engine.Position, onTurn func(engine.TurnResult)) ([]engine.TurnResult, error) {
+	return nil, errors.New("engine crashed")
+}
+
+func TestEngineReviewErrors(t *testing.T) {
+	old := GameEngine
+	GameEngine = failingEngine{}
+	defer func() { GameEngine = old }()
+
+	// Bad records are the caller's fault; only engine failures are ErrEngine.
+	for _, sgf := range []string{"not sgf", "(;GM[1]SZ[9];B[ss])"} {
+		if _, err := EngineReview(context.Background(), sgf); err == nil || errors.Is(err, ErrEngine) {
+			t.Errorf("EngineReview(%q) err = %v", sgf, err)
+		}
+	}
+	if _, err := EngineReview(context.Background(), testSGF); !errors.Is(err, ErrEngine) {
+		t.Errorf("engine failure: err = %v", err)
+	}
+}
+
+func TestRunnerToolPanic(t *testing.T) {
+	broken := func(ctx context.Context, fc llm.ToolCall) map[string]interface{} {
+		var board []string
+		return map[string]interface{}{"point": board[25]}
+	}
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{Name: "broken"}}},
+		{Text: "Could not use it."},
+	}}
+	r := &Runner{Provider: fake, Call: broken}
+	msg, trace, err := r.Run(context.Background(), &llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Text: "Go"}}})
+	if err != nil || msg.Text != "Could not use it." {
+		t.Fatalf("Run = %+v, %v", msg, err)
+	}
+	if !strings.Contains(trace.ToolCalls[0].Error, "panicked") {
+		t.Errorf("trace = %+v", trace.ToolCalls)
+	}
+}
+
+func TestValidModel(t *testing.T) {
+	old := Models
+	Models = []string{"gemini-2.5-pro"}
+	defer func() { Models = old }()
+	for name, want := range map[string]bool{"": true, DefaultModel: true, "gemini-2.5-pro": true, "gemini-ultra": false} {
+		if got := ValidModel(name); got != want {
+			t.Errorf("ValidModel(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestPrompts(t *testing.T) {
+	if len(Personas()) < 4 || !ValidPersona(DefaultPersona) || !ValidPersona("") || ValidPersona("pirate") {
+		t.Errorf("personas = %+v", Personas())
+	}
+
+	// The typed inputs must declare exactly the variables of their template.
+	for task, vars := range map[string]interface{}{"analyze": analyzePrompt{}, "review": reviewPrompt{}, "language": languagePrompt{}, "structure": structurePrompt{}, "chat": chatPrompt{}} {
+		var declared []string
+		for k := range tasks[task].meta.Input.Schema.(map[string]interface{}) {
+			declared = append(declared, strings.TrimSuffix(strings.Fields(k)[0], "?"))
+		}
+		var fields []string
+		typ := reflect.TypeOf(vars)
+		for i := 0; i < typ.NumField(); i++ {
+			fields = append(fields, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+		}
+		sort.Strings(declared)
+		sort.Strings(fields)
+		if !reflect.DeepEqual(declared, fields) {
+			t.Errorf("%s declares %v, input has %v", task, declared, fields)
+		}
+	}
+
+	system, user, version, err := renderPrompt("analyze", "kids", "", analyzePrompt{SgfContent: testSGF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(system, "children") || !strings.Contains(user, testSGF) || strings.Contains(user, "Engine analysis") {
+		t.Errorf("system = %q\nuser = %q", system, user)
+	}
+	if version != "analyze@1 kids@2 language@2" {
+		t.Errorf("version = %q", version)
+	}
+	if _, user, _, _ := renderPrompt("analyze", "", "", analyzePrompt{SgfContent: testSGF, EngineSummary: `[{"n":1}]`}); !strings.Contains(user, `Engine analysis of every move`) || !strings.Contains(user, `[{"n":1}]`) {
+		t.Errorf("engine summary missing: %q", user)
+	}
+	if _, _, _, err := renderPrompt("analyze", "pirate", "", analyzePrompt{}); err == nil {
+		t.Error("rendered an unknown persona")
+	}
+	if _, _, _, err := renderPrompt("analyze", "", "klingon", analyzePrompt{}); err == nil {
+		t.Error("rendered an unknown language")
+	}
+
+	fake := &llm.Scripted{Replies: []llm.Message{{Text: `{"summary":"Fine.","annotations":[]}`}}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+	out, err := Review(context.Background(), AnalyzeInput{SgfContent: testSGF, Persona: "concise"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.PromptVersion != "review@1 concise@2 language@2" || !strings.Contains(fake.Requests()[0].System, "bullet") {
+		t.Errorf("review version %q, system %q", out.PromptVersion, fake.Requests()[0].System)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                          DefaultLanguage,
+		"en-US,en;q=0.9":            "en",
+		"fr-FR, ko;q=0.8, en;q=0.5": "ko",
+		"zh-CN":                     "zh",
+		"zh-TW":                     "zh",
+		"de":                        DefaultLanguage,
+		"not a header;;":            DefaultLanguage,
+	} {
+		if got := MatchLanguage(header); got != want {
+			t.Errorf("MatchLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+	if !ValidLanguage("en") || !ValidLanguage("") || ValidLanguage("EN") {
+		t.Error("ValidLanguage")
+	}
+
+	for code, want := range map[string][]string{
+		"ja": {"Japanese", "死活", "16の四"},
+		"en": {"English", "life and death", "tesuji", "Q16"},
+		"ko": {"Korean", "사활", "맥"},
+	} {
+		system, _, _, err := renderPrompt("review", "coach", code, reviewPrompt{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range want {
+			if !strings.Contains(system, s) {
+				t.Errorf("%s system prompt lacks %q:\n%s", code, s, system)
+			}
+		}
+	}
+}
+
+func TestChat(t *testing.T) {
+	fake := &llm.Scripted{Replies: []llm.Message{
+		{ToolCalls: []llm.ToolCall{{ID: "1", Name: "generateBoardImage", Args: map[string]interface{}{"sgfContent": testSGF, "moveNumber": 2.0}}}},
+		{Text: "Move 2 was too far from the corner."},
+		{Text: "Then Black blocks."},
+		{Text: "Yes."},
+	}}
+	old := Provider
+	Provider = fake
+	defer func() { Provider = old }()
+
+	in := ChatInput{AnalyzeInput: AnalyzeInput{SgfContent: testSGF, Language: "en"}, Analysis: "White was slow.", Message: "Why was move 2 bad?"}
+	out, err := Chat(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Reply != "Move 2 was too far from the corner." || len(out.History) != 4 || out.PromptVersion != "chat@2 coach@2 language@2" {
+		t.Fatalf("first reply = %+v", out)
+	}
+	if img := out.History[2].ToolResults[0].Response["image"]; img != "(shown to the user)" {
+		t.Errorf("history keeps the image: %.40v", img)
+	}
+	if sys := fake.Requests()[0].System; !strings.Contains(sys, testSGF) || !strings.Contains(sys, "White was slow.") || !strings.Contains(sys, "English") {
+		t.Errorf("system = %q", sys)
+	}
+
+	in.History, in.Message = out.History, "What if White played at cc?"
+	out, err = Chat(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := fake.Requests()[2]
+	if out.Reply != "Then Black blocks." || len(out.History) != 6 || len(req.Messages) != 5 || req.Messages[4].Text != "What if White played at cc?" {
+		t.Errorf("second reply = %+v, request messages = %+v", out, req.Messages)
+	}
+
+	// Only the model's view of the history is trimmed.
+	oldTokens := ChatHistoryTokens
+	ChatHistoryTokens = 1
+	defer func() { ChatHistoryTokens = oldTokens }()
+	in.History, in.Message = out.History, "Is that all?"
+	if out, err = Chat(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	req = fake.Requests()[3]
+	if len(out.History) != 8 || out.History[0].Text != "Why was move 2 bad?" || len(req.Messages) != 3 || req.Messages[0].Text != "What if White played at cc?" {
+		t.Errorf("third reply = %+v, request messages = %+v", out, req.Messages)
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	q := func(text string) llm.Message { return llm.Message{Role: llm.RoleUser, Text: text} }
+	a := func(text string) llm.Message { return llm.Message{Role: llm.RoleModel, Text: text} }
+	call := llm.Message{Role: llm.RoleModel, ToolCalls: []llm.ToolCall{{Name: "readSgf"}}}
+	result := llm.Message{Role: llm.RoleTool, ToolResults: []llm.ToolResult{{Name: "readSgf", Response: map[string]interface{}{"ok": true}}}}
+	msgs := []llm.Message{q("one"), call, result, a("1"), q("two"), a("2"), q("three"), a("3")}
+
+	if got := trimHistory(msgs, 1<<20); len(got) != len(msgs) {
+		t.Errorf("trimmed a short history to %d messages", len(got))
+	}
+	var budget int
+	for _, m := range msgs[4:] {
+		budget += estimateTokens(m)
+	}
+	// The whole first exchange goes, tool calls included.
+	if got := trimHistory(msgs, budget); len(got) != 4 || got[0].Text != "two" {
+		t.Errorf("trimmed to %+v", got)
+	}
+	// The last exchange stays even when it alone is too long.
+	if got := trimHistory(msgs, 1); len(got) != 2 || got[0].Text != "three" {
+		t.Errorf("trimmed to %+v", got)
+	}
+}
@@ -0,0 +1,227 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+	"github.com/sweetfish329/sai/internal/llm"
+	"github.com/sweetfish329/sai/internal/sgf"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// StructuredRetries is how many times the structured analysis is asked
+// for again when the model's JSON does not validate.
+var StructuredRetries = 2
+
+// Analysis is the structured result of an analysis.
+type Analysis struct {
+	Summary string        `json:"summary" jsonschema_description:"Overall assessment of the game: who played better, the decisive moment and the main lesson."`
+	Phases  Phases        `json:"phases"`
+	Moves   []MoveComment `json:"moves" jsonschema_description:"The moves worth commenting on, in game order."`
+	// Images are the board images the reader should look at, with what
+	// they show.
+	Images []ImageReference `json:"images,omitempty" jsonschema_description:"Board images generated during the analysis that illustrate the review, by ID."`
+}
+
+// Phases assesses each phase of the game.
+type Phases struct {
+	Opening    PhaseAssessment `json:"opening" jsonschema_description:"The opening (fuseki and joseki)."`
+	MiddleGame PhaseAssessment `json:"middleGame" jsonschema_description:"The middle game (fighting, invasions, life and death)."`
+	Endgame    PhaseAssessment `json:"endgame" jsonschema_description:"The endgame (yose)."`
+}
+
+// PhaseAssessment is the verdict on one phase of the game.
+type PhaseAssessment struct {
+	Assessment string `json:"assessment" jsonschema_description:"How both players handled this phase."`
+	// Advantage is who came out of the phase ahead.
+	Advantage string `json:"advantage" jsonschema:"enum=black,enum=white,enum=even" jsonschema_description:"Who was ahead at the end of this phase."`
+}
+
+// MoveComment is a comment on one move of the main line.
+type MoveComment struct {
+	MoveNumber  int    `json:"moveNumber" jsonschema_description:"1-based move number in the main line."`
+	Color       string `json:"color" jsonschema:"enum=B,enum=W" jsonschema_description:"Colour of the player of the move."`
+	Severity    string `json:"severity" jsonschema:"enum=good,enum=inaccuracy,enum=mistake,enum=blunder" jsonschema_description:"How good the move was."`
+	Explanation string `json:"explanation" jsonschema_description:"Why the move was good or bad."`
+	Alternative string `json:"alternative,omitempty" jsonschema_description:"The better move and the idea behind it, for moves that were not good."`
+	// Image is the ID of a board image showing the move.
+	Image string `json:"image,omitempty" jsonschema_description:"ID of a generated board image that shows this move."`
+}
+
+// ImageReference points to a board image generated during the analysis.
+type ImageReference struct {
+	ID      string `json:"id" jsonschema_description:"ID of the generated board image."`
+	Caption string `json:"caption" jsonschema_description:"What the image shows."`
+}
+
+// BoardImage is a board image generated during the analysis.
+type BoardImage struct {
+	ID string `json:"id"`
+	// MoveNumber is the move the image shows; nil for the final position.
+	MoveNumber *int `json:"moveNumber,omitempty"`
+	// Variation are the hypothetical moves played after MoveNumber, for
+	// images of a variation.
+	Variation []string `json:"variation,omitempty"`
+	// Image is a data URL of the PNG. Stored analyses leave it out, as the
+	// library keeps the images themselves.
+	Image string `json:"image,omitempty"`
+}
+
+// structurePrompt are the variables of prompts/structure.prompt.
+type structurePrompt struct {
+	Images string `json:"images,omitempty"`
+}
+
+// analysisJSONSchema is the JSON schema of Analysis that replies are
+// validated against, and analysisSchema the same schema for the model.
+var analysisJSONSchema, analysisSchema = func() ([]byte, *llm.Schema) {
+	r := &jsonschema.Reflector{DoNotReference: true, Anonymous: true, AllowAdditionalProperties: true}
+	s := r.ReflectFromType(reflect.TypeFor[Analysis]())
+	s.Version = ""
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(fmt.Sprintf("ai: analysis schema: %v", err))
+	}
+	schema, err := llm.SchemaFromJSON(json.RawMessage(b))
+	if err != nil {
+		panic(fmt.Sprintf("ai: analysis schema: %v", err))
+	}
+	return b, schema
+}()
+
+// collectImages returns the board images the tools drew in a conversation,
+// numbered in the order they were made.
+func collectImages(msgs []llm.Message) []BoardImage {
+	args := map[string]map[string]interface{}{}
+	var images []BoardImage
+	for _, m := range msgs {
+		for _, fc := range m.ToolCalls {
+			args[fc.ID] = fc.Args
+		}
+		for _, r := range m.ToolResults {
+			img, ok := r.Response["image"].(string)
+			if !ok {
+				continue
+			}
+			bi := BoardImage{ID: fmt.Sprintf("image%d", len(images)+1), Image: img}
+			if n, ok := args[r.ID]["moveNumber"].(float64); ok {
+				move := int(n)
+				bi.MoveNumber = &move
+			}
+			if moves, ok := args[r.ID]["moves"].([]interface{}); ok {
+				for _, m := range moves {
+					bi.Variation = append(bi.Variation, fmt.Sprint(m))
+				}
+			}
+			images = append(images, bi)
+		}
+	}
+	return images
+}
+
+// parseAnalysis decodes a structured reply and checks it against the schema
+// and the game: move numbers and colours must match the main line and
+// image IDs must name generated images.
+func parseAnalysis(text string, moves []*sgf.Node, images []BoardImage) (*Analysis, error) {
+	res, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(analysisJSONSchema), gojsonschema.NewStringLoader(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if !res.Valid() {
+		var errs []string
+		for _, e := range res.Errors() {
+			errs = append(errs, e.String())
+		}
+		return nil, fmt.Errorf("does not match the schema: %s", strings.Join(errs, "; "))
+	}
+	var a Analysis
+	if err := json.Unmarshal([]byte(text), &a); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	ids := map[string]bool{}
+	for _, img := range images {
+		ids[img.ID] = true
+	}
+	for _, m := range a.Moves {
+		if m.MoveNumber < 1 || m.MoveNumber > len(moves) {
+			return nil, fmt.Errorf("move %d is not in the game, which has %d moves", m.MoveNumber, len(moves))
+		}
+		if c := sgf.MoveColor(moves[m.MoveNumber-1]); c != m.Color {
+			return nil, fmt.Errorf("move %d was played by %s, not %s", m.MoveNumber, c, m.Color)
+		}
+		if m.Image != "" && !ids[m.Image] {
+			return nil, fmt.Errorf("move %d refers to unknown image %q", m.MoveNumber, m.Image)
+		}
+	}
+	for _, ref := range a.Images {
+		if !ids[ref.ID] {
+			return nil, fmt.Errorf("unknown image %q", ref.ID)
+		}
+	}
+	return &a, nil
+}
+
+// structure asks the model to restate its analysis, the last message of
+// conv, as an Analysis. Replies that do not validate are sent back with the
+// problem until StructuredRetries is used up.
+func structure(ctx context.Context, model, system string, conv []llm.Message, sgfContent string, images []BoardImage, usage *llm.Usage) (*Analysis, error) {
+	roots, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no game found")
+	}
+	moves := sgf.MainLine(roots[0])
+
+	var list strings.Builder
+	for _, img := range images {
+		position := "the final position"
+		if img.MoveNumber != nil {
+			position = fmt.Sprintf("the position after move %d", *img.MoveNumber)
+		}
+		if len(img.Variation) > 0 {
+			fmt.Fprintf(&list, "- %s: the variation %s from %s\n", img.ID, strings.Join(img.Variation, " "), position)
+		} else {
+			fmt.Fprintf(&list, "- %s: %s\n", img.ID, position)
+		}
+	}
+	prompt, err := tasks["structure"].execute(structurePrompt{Images: list.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	req := &llm.Request{
+		Model:          model,
+		System:         system,
+		Messages:       append(append([]llm.Message(nil), conv...), llm.Message{Role: llm.RoleUser, Text: prompt}),
+		ResponseSchema: analysisSchema,
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := Provider.Generate(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate structured analysis: %w", err)
+		}
+		usage.InputTokens += res.Usage.InputTokens
+		usage.OutputTokens += res.Usage.OutputTokens
+
+		a, err := parseAnalysis(res.Message.Text, moves, images)
+		if err == nil {
+			return a, nil
+		}
+		if attempt == StructuredRetries {
+			return nil, fmt.Errorf("ai: structured analysis still invalid after %d attempts: %w", attempt+1, err)
+		}
+		reply := res.Message
+		reply.Role = llm.RoleModel
+		req.Messages = append(req.Messages, reply, llm.Message{
+			Role: llm.RoleUser,
+			Text: fmt.Sprintf("That answer was rejected: %v. Answer again with JSON that matches the schema.", err),
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/sweetfish329/sai/internal/llm"
+)
+
+// ChatHistoryTokens is roughly how many tokens of earlier messages a chat
+// sends to the model. Older exchanges are left out first; the latest is
+// always sent.
+var ChatHistoryTokens = 32000
+
+// ChatInput is a question in a conversation about a game.
+type ChatInput struct {
+	// SgfContent, Model, Persona and Language are used as in an analysis.
+	AnalyzeInput
+	// Analysis is an earlier analysis of the game that the conversation
+	// follows up on.
+	Analysis string `json:"analysis,omitempty"`
+	// History is the conversation so far, as returned by the last reply.
+	History []llm.Message `json:"history,omitempty"`
+	Message string        `json:"message"`
+}
+
+type ChatOutput struct {
+	Reply string `json:"reply"`
+	// History is the whole conversation including the reply, without
+	// board image data. Send it with the next question; only the part that
+	// fits in ChatHistoryTokens goes to the model.
+	History []llm.Message `json:"history"`
+	// PromptVersion names the prompt templates the reply was made with.
+	PromptVersion string `json:"promptVersion"`
+	Trace         *Trace `json:"trace,omitempty"`
+}
+
+// chatPrompt are the variables of prompts/chat.prompt.
+type chatPrompt struct {
+	SgfContent string `json:"sgfContent"`
+	Analysis   string `json:"analysis,omitempty"`
+}
+
+func defineChatFlow(g *genkit.Genkit) func(context.Context, ChatInput) (ChatOutput, error) {
+	flow := genkit.DefineFlow(g, "chatFlow", func(ctx context.Context, input ChatInput) (ChatOutput, error) {
+		ctx, model := request(ctx, input.AnalyzeInput)
+
+		// The game is part of the system prompt, so the history holds
+		// only the conversation and can be trimmed freely.
+		system, game, version, err := renderPrompt("chat", input.Persona, input.Language, chatPrompt{
+			SgfContent: input.SgfContent,
+			Analysis:   input.Analysis,
+		})
+		if err != nil {
+			return ChatOutput{}, err
+		}
+
+		decls, err := agentTools()
+		if err != nil {
+			return ChatOutput{}, err
+		}
+
+		history := trimHistory(input.History, ChatHistoryTokens)
+		req := &llm.Request{
+			Model:    model,
+			System:   system + "\n\n" + game,
+			Messages: append(history, llm.Message{Role: llm.RoleUser, Text: input.Message}),
+			Tools:    decls,
+		}
+		msg, trace, err := NewRunner(nil).Run(ctx, req)
+		if err != nil {
+			return ChatOutput{}, err
+		}
+		reply := *msg
+		reply.Role = llm.RoleModel
+		if reply.Text == "" {
+			reply.Text = "No response from AI"
+		}
+		// The new turn goes after the whole history, not the trimmed part
+		// the model saw.
+		turn := withoutImages(append(req.Messages[len(history):], reply))
+		return ChatOutput{
+			Reply:         reply.Text,
+			History:       append(append([]llm.Message(nil), input.History...), turn...),
+			PromptVersion: version,
+			Trace:         trace,
+		}, nil
+	})
+
+	return func(ctx context.Context, input ChatInput) (ChatOutput, error) {
+		return flow.Run(ctx, input)
+	}
+}
+
+// estimateTokens is a rough token count of a message: a token per four
+// bytes of its JSON.
+func estimateTokens(m llm.Message) int {
+	b, _ := json.Marshal(m)
+	return len(b)/4 + 1
+}
+
+// trimHistory drops the oldest exchanges of a conversation until it fits in
+// about maxTokens. An exchange starts with a question from the user and
+// holds the tool calls and replies up to the next one, so tool calls are
+// never separated from their results. The last exchange is always kept.
+func trimHistory(msgs []llm.Message, maxTokens int) []llm.Message {
+	total := 0
+	for _, m := range msgs {
+		total += estimateTokens(m)
+	}
+	start := 0
+	for total > maxTokens {
+		next := start + 1
+		for next < len(msgs) && !(msgs[next].Role == llm.RoleUser && msgs[next].Text != "") {
+			next++
+		}
+		if next >= len(msgs) {
+			break
+		}
+		for _, m := range msgs[start:next] {
+			total -= estimateTokens(m)
+		}
+		start = next
+	}
+	return append([]llm.Message(nil), msgs[start:]...)
+}
+
+// withoutImages replaces the board images in tool results, which the user
+// has already seen, with a note, keeping the history small.
+func withoutImages(msgs []llm.Message) []llm.Message {
+	out := make([]llm.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = m
+		if len(m.ToolResults) == 0 {
+			continue
+		}
+		out[i].ToolResults = make([]llm.ToolResult, len(m.ToolResults))
+		for j, r := range m.ToolResults {
+			if _, ok := r.Response["image"]; ok {
+				resp := make(map[string]interface{}, len(r.Response))
+				for k, v := range r.Response {
+					resp[k] = v
+				}
+				resp["image"] = "(shown to the user)"
+				r.Response = resp
+			}
+			out[i].ToolResults[j] = r
+		}
+	}
+	return out
+}
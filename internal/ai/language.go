@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLanguage is the language used when a request names none that is
+// supported.
+const DefaultLanguage = "ja"
+
+// Language is a language the coach writes in.
+type Language struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Coordinates tells the model how readers of the language write board
+	// positions.
+	Coordinates string `json:"-"`
+}
+
+// Languages are the supported output languages, by code.
+var Languages = map[string]Language{
+	"ja": {
+		Code:        "ja",
+		Name:        "Japanese",
+		Coordinates: `Write positions the Japanese way: the column as an Arabic numeral counted from the left, then the row as a kanji numeral counted from the top, joined with "の" (SGF "pd" is 16の四, "dp" is 4の十六). Name well-known points by their place as well where it helps, e.g. 右上の星 or 左下の小目.`,
+	},
+	"en": {
+		Code:        "en",
+		Name:        "English",
+		Coordinates: `Write positions in Western notation: a column letter from A to T counted from the left, skipping I, then the row number counted from the bottom (on 19x19, SGF "pd" is Q16 and "dp" is D4). Name well-known points by their place as well where it helps, e.g. "the upper right star point".`,
+	},
+	"zh": {
+		Code:        "zh",
+		Name:        "Simplified Chinese",
+		Coordinates: `Write positions in the notation Chinese players use: a column letter from A to T counted from the left, skipping I, then the row number counted from the bottom (on 19x19, SGF "pd" is Q16 and "dp" is D4), and name the place on the board as well, e.g. 右上星位 or 左下小目.`,
+	},
+	"ko": {
+		Code:        "ko",
+		Name:        "Korean",
+		Coordinates: `Write positions in the notation Korean players use: a column letter from A to T counted from the left, skipping I, then the row number counted from the bottom (on 19x19, SGF "pd" is Q16 and "dp" is D4), and name the place on the board as well, e.g. 우상귀 화점 or 좌하귀 소목.`,
+	},
+}
+
+// glossary maps Go terms between the supported languages, so that the
+// model uses each audience's own terminology rather than translating word
+// for word.
+var glossary = []map[string]string{
+	{"ja": "手筋", "en": "tesuji", "zh": "手筋", "ko": "맥"},
+	{"ja": "死活", "en": "life and death", "zh": "死活", "ko": "사활"},
+	{"ja": "定石", "en": "joseki", "zh": "定式", "ko": "정석"},
+	{"ja": "布石", "en": "opening (fuseki)", "zh": "布局", "ko": "포석"},
+	{"ja": "ヨセ", "en": "endgame (yose)", "zh": "官子", "ko": "끝내기"},
+	{"ja": "厚み", "en": "thickness", "zh": "厚势", "ko": "두터움"},
+	{"ja": "地", "en": "territory", "zh": "实地", "ko": "집"},
+	{"ja": "模様", "en": "framework (moyo)", "zh": "模样", "ko": "세력"},
+	{"ja": "大石", "en": "large group", "zh": "大龙", "ko": "대마"},
+	{"ja": "眼", "en": "eye", "zh": "眼", "ko": "눈"},
+	{"ja": "コウ", "en": "ko", "zh": "劫", "ko": "패"},
+	{"ja": "シチョウ", "en": "ladder", "zh": "征子", "ko": "축"},
+	{"ja": "ゲタ", "en": "net", "zh": "枷吃", "ko": "장문"},
+	{"ja": "アタリ", "en": "atari", "zh": "打吃", "ko": "단수"},
+	{"ja": "先手", "en": "sente", "zh": "先手", "ko": "선수"},
+	{"ja": "後手", "en": "gote", "zh": "后手", "ko": "후수"},
+	{"ja": "悪手", "en": "bad move", "zh": "恶手", "ko": "악수"},
+	{"ja": "好手", "en": "good move", "zh": "好棋", "ko": "호수"},
+	{"ja": "星", "en": "star point (hoshi)", "zh": "星位", "ko": "화점"},
+	{"ja": "小目", "en": "3-4 point (komoku)", "zh": "小目", "ko": "소목"},
+	{"ja": "三々", "en": "3-3 point (san-san)", "zh": "三三", "ko": "삼삼"},
+}
+
+// ValidLanguage reports whether code is a supported language, or empty for
+// the default.
+func ValidLanguage(code string) bool {
+	_, ok := Languages[code]
+	return ok || code == ""
+}
+
+var languageMatcher = language.NewMatcher([]language.Tag{
+	language.Japanese, // the default comes first
+	language.English,
+	language.SimplifiedChinese,
+	language.Korean,
+})
+
+var matcherCodes = []string{"ja", "en", "zh", "ko"}
+
+// MatchLanguage picks the supported language that best fits an
+// Accept-Language header, or DefaultLanguage.
+func MatchLanguage(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLanguage
+	}
+	_, i, conf := languageMatcher.Match(tags...)
+	if conf == language.No {
+		return DefaultLanguage
+	}
+	return matcherCodes[i]
+}
+
+// languagePrompt are the variables of prompts/language.prompt.
+type languagePrompt struct {
+	Language    string `json:"language"`
+	Coordinates string `json:"coordinates"`
+	Glossary    string `json:"glossary"`
+}
+
+// newLanguagePrompt fills in the language instructions for code.
+func newLanguagePrompt(code string) (languagePrompt, error) {
+	if code == "" {
+		code = DefaultLanguage
+	}
+	lang, ok := Languages[code]
+	if !ok {
+		return languagePrompt{}, fmt.Errorf("unsupported language %q", code)
+	}
+	var terms strings.Builder
+	for _, term := range glossary {
+		fmt.Fprintf(&terms, "- %s (ja: %s, en: %s)\n", term[code], term["ja"], term["en"])
+	}
+	return languagePrompt{
+		Language:    lang.Name,
+		Coordinates: lang.Coordinates,
+		Glossary:    terms.String(),
+	}, nil
+}
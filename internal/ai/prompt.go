@@ -0,0 +1,160 @@
+package ai
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/dotprompt/go/dotprompt"
+)
+
+// The prompts are dotprompt templates: prompts/<task>.prompt holds the
+// request for a task, prompts/personas/<name>.prompt the system prompt
+// of a coaching persona and prompts/language.prompt the instructions for
+// the output language, which follow the persona's. Bump a template's version whenever its text
+// changes so stored results can be compared across versions.
+//
+//go:embed prompts
+var promptFiles embed.FS
+
+// DefaultPersona is the persona used when a request does not name one.
+const DefaultPersona = "coach"
+
+// Persona is a coaching style the user can choose.
+type Persona struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+// template is a compiled prompt file.
+type template struct {
+	meta dotprompt.PromptMetadata
+
+	mu     sync.Mutex
+	render dotprompt.PromptFunction
+}
+
+var (
+	personas = map[string]*template{}
+	tasks    = map[string]*template{}
+)
+
+func init() {
+	err := fs.WalkDir(promptFiles, "prompts", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".prompt" {
+			return err
+		}
+		src, err := promptFiles.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		t, err := compileTemplate(string(src))
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		name := strings.TrimSuffix(path.Base(p), ".prompt")
+		if t.meta.Name != name || t.meta.Version == "" {
+			return fmt.Errorf("%s: name and version must be set, and the name must match the file", p)
+		}
+		if path.Dir(p) == "prompts/personas" {
+			personas[name] = t
+		} else {
+			tasks[name] = t
+		}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("ai: loading prompts: %v", err))
+	}
+}
+
+func compileTemplate(src string) (*template, error) {
+	// A Dotprompt renders with the template it compiled last, so every
+	// file gets its own.
+	dp := dotprompt.NewDotprompt(nil)
+	meta, err := dp.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	render, err := dp.Compile(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &template{meta: meta.PromptMetadata, render: render}, nil
+}
+
+// execute renders the template with input, whose JSON fields are the
+// template's variables, and returns the text of its messages.
+func (t *template) execute(input interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rendered, err := t.render(&dotprompt.DataArgument{Input: toMap(input)}, nil)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, m := range rendered.Messages {
+		for _, part := range m.Content {
+			if p, ok := part.(*dotprompt.TextPart); ok {
+				text.WriteString(p.Text)
+			}
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// Personas returns the available personas by name.
+func Personas() []Persona {
+	out := make([]Persona, 0, len(personas))
+	for _, t := range personas {
+		out = append(out, Persona{Name: t.meta.Name, Description: t.meta.Description, Version: t.meta.Version})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ValidPersona reports whether name is a persona, or empty for the default.
+func ValidPersona(name string) bool {
+	_, ok := personas[name]
+	return ok || name == ""
+}
+
+// renderPrompt renders the system prompt of a persona in a language and the
+// request of a task. The version names the templates, e.g.
+// "analyze@1 coach@2 language@2".
+func renderPrompt(task, persona, lang string, input interface{}) (system, user, version string, err error) {
+	if persona == "" {
+		persona = DefaultPersona
+	}
+	p, ok := personas[persona]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown persona %q", persona)
+	}
+	t, ok := tasks[task]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown prompt %q", task)
+	}
+	l := tasks["language"]
+	langVars, err := newLanguagePrompt(lang)
+	if err != nil {
+		return "", "", "", err
+	}
+	if system, err = p.execute(struct{}{}); err != nil {
+		return "", "", "", err
+	}
+	instructions, err := l.execute(langVars)
+	if err != nil {
+		return "", "", "", err
+	}
+	system += "\n\n" + instructions
+	if user, err = t.execute(input); err != nil {
+		return "", "", "", err
+	}
+	version = fmt.Sprintf("%s@%s %s@%s %s@%s", t.meta.Name, t.meta.Version, p.meta.Name, p.meta.Version, l.meta.Name, l.meta.Version)
+	return system, user, version, nil
+}
@@ -0,0 +1,153 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/sweetfish329/sai/internal/llm"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// ReviewOutput is a move-indexed review that can be written back into the
+// game record with sgf.Annotate.
+type ReviewOutput struct {
+	Summary     string           `json:"summary"`
+	Annotations []sgf.Annotation `json:"annotations"`
+	// PromptVersion names the prompt templates the review was made with.
+	PromptVersion string `json:"promptVersion,omitempty"`
+}
+
+// reviewPrompt are the variables of prompts/review.prompt.
+type reviewPrompt struct {
+	GameInfo string `json:"gameInfo"`
+	Moves    string `json:"moves"`
+}
+
+var reviewSchema = &llm.Schema{
+	Type: "object",
+	Properties: map[string]*llm.Schema{
+		"summary": {
+			Type:        "string",
+			Description: "Overall review of the game.",
+		},
+		"annotations": {
+			Type: "array",
+			Items: &llm.Schema{
+				Type: "object",
+				Properties: map[string]*llm.Schema{
+					"moveNumber": {
+						Type:        "integer",
+						Description: "1-based move number from the move list.",
+					},
+					"comment": {
+						Type:        "string",
+						Description: "Coaching comment for this move.",
+					},
+					"quality": {
+						Type:        "string",
+						Description: "Move quality.",
+						Enum:        []string{sgf.QualityGood, sgf.QualityBad, sgf.QualityDoubtful, sgf.QualityInteresting},
+					},
+					"emphasis": {
+						Type:        "boolean",
+						Description: "True for a very good or very bad move.",
+					},
+					"variation": {
+						Type:        "array",
+						Description: "Better sequence to play instead of this move, in SGF coordinates (e.g. \"dd\"), starting with the same colour.",
+						Items:       &llm.Schema{Type: "string"},
+					},
+					"marks": {
+						Type:        "array",
+						Description: "Key points to mark on the board at this move.",
+						Items: &llm.Schema{
+							Type: "object",
+							Properties: map[string]*llm.Schema{
+								"type": {
+									Type: "string",
+									Enum: []string{"TR", "SQ", "CR", "MA", "LB"},
+								},
+								"point": {
+									Type:        "string",
+									Description: "SGF coordinate such as \"dd\".",
+								},
+								"label": {
+									Type:        "string",
+									Description: "Text for LB marks.",
+								},
+							},
+							Required: []string{"type", "point"},
+						},
+					},
+				},
+				Required: []string{"moveNumber", "comment"},
+			},
+		},
+	},
+	Required: []string{"summary", "annotations"},
+}
+
+func defineReviewFlow(g *genkit.Genkit) func(context.Context, AnalyzeInput) (ReviewOutput, error) {
+	flow := genkit.DefineFlow(g, "reviewFlow", func(ctx context.Context, input AnalyzeInput) (ReviewOutput, error) {
+		ctx, model := request(ctx, input)
+
+		roots, err := sgf.Parse(input.SgfContent)
+		if err != nil {
+			return ReviewOutput{}, err
+		}
+		if len(roots) == 0 {
+			return ReviewOutput{}, fmt.Errorf("no game found")
+		}
+		root := roots[0]
+		moves := sgf.MainLine(root)
+
+		info := sgf.ExtractGameData(root).GameInfo
+		infoJSON, _ := json.Marshal(info)
+
+		var moveList strings.Builder
+		for i, node := range moves {
+			color := sgf.MoveColor(node)
+			mv := node.Get(color)
+			if mv == "" {
+				mv = "pass"
+			}
+			fmt.Fprintf(&moveList, "%d. %s %s\n", i+1, color, mv)
+		}
+
+		system, prompt, version, err := renderPrompt("review", input.Persona, input.Language, reviewPrompt{
+			GameInfo: string(infoJSON),
+			Moves:    moveList.String(),
+		})
+		if err != nil {
+			return ReviewOutput{}, err
+		}
+
+		res, err := Provider.Generate(ctx, &llm.Request{
+			Model:          model,
+			System:         system,
+			Messages:       []llm.Message{{Role: llm.RoleUser, Text: prompt}},
+			ResponseSchema: reviewSchema,
+		})
+		if err != nil {
+			return ReviewOutput{}, fmt.Errorf("failed to generate review: %w", err)
+		}
+		text := res.Message.Text
+		if text == "" {
+			return ReviewOutput{}, fmt.Errorf("no response from AI")
+		}
+
+		var output ReviewOutput
+		if err := json.Unmarshal([]byte(text), &output); err != nil {
+			return ReviewOutput{}, fmt.Errorf("failed to decode review: %w", err)
+		}
+		output.PromptVersion = version
+		return output, nil
+	})
+
+	return func(ctx context.Context, input AnalyzeInput) (ReviewOutput, error) {
+		return flow.Run(ctx, input)
+	}
+}
@@ -0,0 +1,191 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sweetfish329/sai/internal/llm"
+)
+
+// Limits of the agent loop. The server may change them at startup.
+var (
+	// MaxTurns is how many model replies an analysis may take.
+	MaxTurns = 10
+	// ToolTimeout bounds a single tool call.
+	ToolTimeout = time.Minute
+	// AnalysisTimeout bounds a whole analysis.
+	AnalysisTimeout = 5 * time.Minute
+)
+
+// TurnLimitError is returned when the model is still calling tools after
+// the runner's last turn.
+type TurnLimitError struct {
+	MaxTurns int
+	Trace    *Trace
+}
+
+func (e *TurnLimitError) Error() string {
+	return fmt.Sprintf("ai: model still calling tools after %d turns", e.MaxTurns)
+}
+
+// ToolTrace records one tool call.
+type ToolTrace struct {
+	Turn int    `json:"turn"`
+	Tool string `json:"tool"`
+	// Args are the tool arguments without the SGF.
+	Args       map[string]interface{} `json:"args,omitempty"`
+	DurationMS int64                  `json:"durationMs"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Trace records what a run of the agent did.
+type Trace struct {
+	Turns      int         `json:"turns"`
+	ToolCalls  []ToolTrace `json:"toolCalls"`
+	DurationMS int64       `json:"durationMs"`
+	Usage      llm.Usage   `json:"usage"`
+}
+
+// Runner drives a model through a tool-calling loop: it sends the request,
+// runs the tools the model asks for, returns their results and repeats
+// until the model answers without calling tools.
+type Runner struct {
+	Provider llm.Provider
+	// Call runs a tool call; failures are reported in the result.
+	Call func(ctx context.Context, fc llm.ToolCall) map[string]interface{}
+	// MaxTurns bounds the model replies; 0 means no bound.
+	MaxTurns int
+	// ToolTimeout bounds every tool call and Timeout the whole run; 0
+	// means no bound.
+	ToolTimeout time.Duration
+	Timeout     time.Duration
+	// OnEvent, when set, streams the reply and receives tool calls and
+	// results as they happen. An error from it stops the run.
+	OnEvent func(Event) error
+}
+
+// NewRunner returns a Runner for the analysis agent with the package
+// limits.
+func NewRunner(onEvent func(Event) error) *Runner {
+	return &Runner{
+		Provider:    Provider,
+		Call:        callTool,
+		MaxTurns:    MaxTurns,
+		ToolTimeout: ToolTimeout,
+		Timeout:     AnalysisTimeout,
+		OnEvent:     onEvent,
+	}
+}
+
+// Run runs req to completion and returns the model's final message with a
+// trace of the run. req.Messages grows with the conversation.
+func (r *Runner) Run(ctx context.Context, req *llm.Request) (*llm.Message, *Trace, error) {
+	start := time.Now()
+	trace := &Trace{ToolCalls: []ToolTrace{}}
+	defer func() { trace.DurationMS = time.Since(start).Milliseconds() }()
+
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	emit := func(e Event) error {
+		if r.OnEvent == nil {
+			return nil
+		}
+		return r.OnEvent(e)
+	}
+
+	for r.MaxTurns == 0 || trace.Turns < r.MaxTurns {
+		trace.Turns++
+		var res *llm.Response
+		var err error
+		if r.OnEvent == nil {
+			res, err = r.Provider.Generate(ctx, req)
+		} else {
+			res, err = r.Provider.Stream(ctx, req, func(text string) error {
+				return emit(Event{Type: EventText, Text: text})
+			})
+		}
+		if err != nil {
+			return nil, trace, err
+		}
+		trace.Usage.InputTokens += res.Usage.InputTokens
+		trace.Usage.OutputTokens += res.Usage.OutputTokens
+
+		msg := res.Message
+		if len(msg.ToolCalls) == 0 {
+			return &msg, trace, nil
+		}
+
+		results := llm.Message{Role: llm.RoleTool}
+		for _, fc := range msg.ToolCalls {
+			log.Printf("Calling tool: %s", fc.Name)
+			if err := emit(Event{Type: EventToolCall, Tool: fc.Name, Args: withoutSGF(fc.Args)}); err != nil {
+				return nil, trace, err
+			}
+			result, call := r.call(ctx, fc)
+			call.Turn = trace.Turns
+			trace.ToolCalls = append(trace.ToolCalls, call)
+			if err := ctx.Err(); err != nil {
+				return nil, trace, err
+			}
+			if err := emitResult(emit, fc, result); err != nil {
+				return nil, trace, err
+			}
+			results.ToolResults = append(results.ToolResults, llm.ToolResult{
+				ID:       fc.ID,
+				Name:     fc.Name,
+				Response: result,
+			})
+		}
+		req.Messages = append(req.Messages, msg, results)
+	}
+	return nil, trace, &TurnLimitError{MaxTurns: r.MaxTurns, Trace: trace}
+}
+
+// call runs one tool call under the tool timeout. A tool that does not
+// return in time is abandoned and reported to the model as timed out; a
+// tool that panics is reported as failed rather than taking the process
+// down with it.
+func (r *Runner) call(ctx context.Context, fc llm.ToolCall) (map[string]interface{}, ToolTrace) {
+	start := time.Now()
+	if r.ToolTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ToolTimeout)
+		defer cancel()
+	}
+
+	done := make(chan map[string]interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- map[string]interface{}{"error": fmt.Sprintf("tool %s panicked: %v", fc.Name, p)}
+			}
+		}()
+		done <- r.Call(ctx, fc)
+	}()
+	var result map[string]interface{}
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		msg := "tool call cancelled"
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			msg = "tool call timed out"
+		}
+		result = map[string]interface{}{"error": msg}
+	}
+
+	call := ToolTrace{
+		Tool:       fc.Name,
+		Args:       withoutSGF(fc.Args),
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if msg, ok := result["error"].(string); ok {
+		call.Error = msg
+	}
+	return result, call
+}
@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+func key(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	testKeyOnce.Do(func() {
+		var err error
+		testKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	return testKey
+}
+
+// sign makes an RS256 token with key ID "k1".
+func sign(t *testing.T, priv *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func claims(mod func(map[string]interface{})) map[string]interface{} {
+	now := time.Now()
+	c := map[string]interface{}{
+		"iss":   "https://accounts.google.com",
+		"aud":   "client-1",
+		"sub":   "1234",
+		"email": "player@example.com",
+		"name":  "Player",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if mod != nil {
+		mod(c)
+	}
+	return c
+}
+
+func testVerifier(t *testing.T) *Verifier {
+	return &Verifier{
+		Keys:     StaticKeys{"k1": &key(t).PublicKey},
+		Audience: []string{"client-1"},
+		Issuers:  GoogleIssuers,
+		Leeway:   time.Minute,
+	}
+}
+
+func TestVerify(t *testing.T) {
+	v := testVerifier(t)
+	ctx := context.Background()
+
+	got, err := v.Verify(ctx, sign(t, key(t), claims(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "1234" || got.Email != "player@example.com" {
+		t.Errorf("claims = %+v", got)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", sign(t, key(t), claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), ErrExpiredToken},
+		{"audience", sign(t, key(t), claims(func(c map[string]interface{}) { c["aud"] = "client-2" })), ErrInvalidToken},
+		{"issuer", sign(t, key(t), claims(func(c map[string]interface{}) { c["iss"] = "evil.example.com" })), ErrInvalidToken},
+		{"future", sign(t, key(t), claims(func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() })), ErrInvalidToken},
+		{"signature", sign(t, other, claims(nil)), ErrInvalidToken},
+		{"malformed", "not.a.token", ErrInvalidToken},
+		{"none", "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`)) + ".", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(ctx, tt.token); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	pub := key(t).PublicKey
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	}))
+	defer ts.Close()
+
+	v := testVerifier(t)
+	v.Keys = &JWKS{URL: ts.URL}
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), sign(t, key(t), claims(nil))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := v.Keys.Key(context.Background(), "k2"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: err = %v", err)
+	}
+	// One fetch for k1; k2 was asked for right after, so it is not refetched.
+	if fetches != 1 {
+		t.Errorf("fetched keys %d times, want 1", fetches)
+	}
+}
+
+type memUsers map[string]User
+
+func (m memUsers) GetUser(ctx context.Context, id string) (*User, error) {
+	u, ok := m[id]
+	if !ok {
+		return nil, ErrNoUser
+	}
+	return &u, nil
+}
+
+func (m memUsers) PutUser(ctx context.Context, u *User) error {
+	m[u.ID] = *u
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	users := memUsers{}
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, UserFrom(c.Request().Context()).ID)
+	}
+	serve := func(mw echo.MiddlewareFunc, authz string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		rec := httptest.NewRecorder()
+		mw(handler)(echo.New().NewContext(req, rec))
+		return rec
+	}
+
+	mw := Middleware(testVerifier(t), users, nil)
+	if rec := serve(mw, "Bearer "+sign(t, key(t), claims(nil))); rec.Code != http.StatusOK || rec.Body.String() != "1234" {
+		t.Errorf("valid token: %d %s", rec.Code, rec.Body)
+	}
+	if u := users["1234"]; u.Email != "player@example.com" || u.CreatedAt.IsZero() {
+		t.Errorf("user record = %+v", u)
+	}
+	if rec := serve(mw, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: %d", rec.Code)
+	}
+	if rec := serve(mw, "Bearer junk"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: %d", rec.Code)
+	}
+
+	if rec := serve(Middleware(nil, users, nil), ""); rec.Code != http.StatusOK || rec.Body.String() != LocalUserID {
+		t.Errorf("sign-in off: %d %s", rec.Code, rec.Body)
+	}
+}
+
+type memSessions struct {
+	mu sync.Mutex
+	m  map[string]StoredSession
+}
+
+func (m *memSessions) PutSession(ctx context.Context, s *StoredSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m[s.ID] = *s
+	return nil
+}
+
+func (m *memSessions) GetSession(ctx context.Context, id string) (*StoredSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.m[id]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return &s, nil
+}
+
+func (m *memSessions) DeleteSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.m, id)
+	return nil
+}
+
+func testSessions(t *testing.T, tokenURL string) (*Sessions, *memSessions) {
+	t.Helper()
+	store := &memSessions{m: map[string]StoredSession{}}
+	s, err := NewSessions(store, &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenURL}}, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, store
+}
+
+// signIn creates a session and returns its cookie.
+func signIn(t *testing.T, s *Sessions, tok *oauth2.Token) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/auth/exchange", nil), rec)
+	if err := s.Create(c, "1234", tok); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	return cookies[0]
+}
+
+func TestSessions(t *testing.T) {
+	if _, err := NewSessions(&memSessions{}, &oauth2.Config{}, make([]byte, 16)); err == nil {
+		t.Error("accepted a 16-byte key")
+	}
+
+	s, store := testSessions(t, "")
+	cookie := signIn(t, s, &oauth2.Token{AccessToken: "at", RefreshToken: "rt", Expiry: time.Now().Add(time.Hour)})
+
+	for id, stored := range store.m {
+		if id == cookie.Value || strings.Contains(string(stored.Token), "rt") {
+			t.Errorf("session stored in the clear: %+v", stored)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	sess, err := s.Lookup(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := sess.TokenSource.Token()
+	if sess.UserID != "1234" || err != nil || tok.AccessToken != "at" {
+		t.Errorf("Lookup = %+v, token %v %v", sess, tok, err)
+	}
+
+	// A token sealed for one session cannot be opened by another.
+	for _, stored := range store.m {
+		if _, err := s.open(stored.Token, "other"); err == nil {
+			t.Error("opened a token under another session ID")
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookie, Value: "forged"})
+	if _, err := s.Lookup(req); !errors.Is(err, ErrNoSession) {
+		t.Errorf("forged cookie: %v", err)
+	}
+
+	s.TTL = -time.Minute
+	expired := signIn(t, s, &oauth2.Token{AccessToken: "at"})
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(expired)
+	if _, err := s.Lookup(req); !errors.Is(err, ErrNoSession) {
+		t.Errorf("expired session: %v", err)
+	}
+	if len(store.m) != 1 {
+		t.Errorf("expired session was kept: %d sessions", len(store.m))
+	}
+
+	// After a key change the stored tokens cannot be opened; the session
+	// is dropped rather than failing every request.
+	rekeyed, err := NewSessions(store, &oauth2.Config{}, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	if _, err := rekeyed.Lookup(req); !errors.Is(err, ErrNoSession) {
+		t.Errorf("session under an old key: %v", err)
+	}
+	if len(store.m) != 0 {
+		t.Errorf("unreadable session was kept: %d sessions", len(store.m))
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokens.Close()
+
+	s, _ := testSessions(t, tokens.URL)
+	cookie := signIn(t, s, &oauth2.Token{AccessToken: "stale", RefreshToken: "rt", Expiry: time.Now().Add(-time.Hour)})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	sess, err := s.Lookup(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, err := sess.TokenSource.Token(); err != nil || tok.AccessToken != "fresh" {
+		t.Fatalf("Token = %v, %v", tok, err)
+	}
+
+	// The refreshed token is what the next request gets.
+	sess, err = s.Lookup(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.oauth.Endpoint.TokenURL = "http://127.0.0.1:0"
+	if tok, err := sess.TokenSource.Token(); err != nil || tok.AccessToken != "fresh" {
+		t.Errorf("after refresh: %v, %v", tok, err)
+	}
+}
+
+func TestSessionMiddlewareAndDestroy(t *testing.T) {
+	var revoked string
+	revoker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked = r.FormValue("token")
+	}))
+	defer revoker.Close()
+	defer func(u string) { revokeURL = u }(revokeURL)
+	revokeURL = revoker.URL
+
+	s, store := testSessions(t, "")
+	users := memUsers{"1234": {ID: "1234"}}
+	cookie := signIn(t, s, &oauth2.Token{AccessToken: "at", RefreshToken: "rt", Expiry: time.Now().Add(time.Hour)})
+
+	var gotToken string
+	handler := func(c echo.Context) error {
+		if ts := TokenSourceFrom(c.Request().Context()); ts != nil {
+			tok, _ := ts.Token()
+			gotToken = tok.AccessToken
+		}
+		return c.String(http.StatusOK, UserFrom(c.Request().Context()).ID)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	Middleware(testVerifier(t), users, s)(handler)(echo.New().NewContext(req, rec))
+	if rec.Code != http.StatusOK || rec.Body.String() != "1234" || gotToken != "at" {
+		t.Errorf("session request: %d %s, token %q", rec.Code, rec.Body, gotToken)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	if err := s.Destroy(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if revoked != "rt" {
+		t.Errorf("revoked %q, want the refresh token", revoked)
+	}
+	if len(store.m) != 0 {
+		t.Errorf("%d sessions left after logout", len(store.m))
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookie not cleared: %+v", cookies)
+	}
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+// SessionCookie is the name of the HttpOnly session cookie.
+const SessionCookie = "sai_session"
+
+var revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// ErrNoSession is returned for unknown or expired sessions.
+var ErrNoSession = errors.New("auth: no such session")
+
+// StoredSession is a session as kept by a SessionStore. ID is a hash of the
+// cookie value, so a leaked store cannot be used to sign in, and Token is
+// the user's OAuth token, encrypted.
+type StoredSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Token     []byte    `json:"token"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SessionStore keeps sessions.
+type SessionStore interface {
+	PutSession(ctx context.Context, s *StoredSession) error
+	// GetSession returns ErrNoSession for unknown sessions.
+	GetSession(ctx context.Context, id string) (*StoredSession, error)
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// Sessions issues session cookies and keeps the OAuth tokens behind them on
+// the server.
+type Sessions struct {
+	store SessionStore
+	oauth *oauth2.Config
+	aead  cipher.AEAD
+	// TTL is how long a session lasts after sign-in.
+	TTL time.Duration
+}
+
+// NewSessions returns a session manager. key is the 32-byte AES key the
+// tokens are encrypted with; oauth is used to refresh them.
+func NewSessions(store SessionStore, oauth *oauth2.Config, key []byte) (*Sessions, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("auth: bad session key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("auth: session key must be 32 bytes, got %d", len(key))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sessions{store: store, oauth: oauth, aead: aead, TTL: 30 * 24 * time.Hour}, nil
+}
+
+func hashID(cookie string) string {
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Sessions) seal(tok *oauth2.Token, sessionID string) ([]byte, error) {
+	plain, err := json.Marshal(tok)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The session ID is bound as additional data, so a token cannot be
+	// moved to another session.
+	return s.aead.Seal(nonce, nonce, plain, []byte(sessionID)), nil
+}
+
+func (s *Sessions) open(sealed []byte, sessionID string) (*oauth2.Token, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("auth: corrupt session token")
+	}
+	plain, err := s.aead.Open(nil, sealed[:n], sealed[n:], []byte(sessionID))
+	if err != nil {
+		return nil, errors.New("auth: corrupt session token")
+	}
+	var tok oauth2.Token
+	if err := json.Unmarshal(plain, &tok); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// Exchange trades an authorization code from the sign-in popup for tokens.
+func (s *Sessions) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return s.oauth.Exchange(ctx, code)
+}
+
+// Create starts a session for a signed-in user and sets its cookie.
+func (s *Sessions) Create(c echo.Context, userID string, tok *oauth2.Token) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	cookie := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	stored := &StoredSession{
+		ID:        hashID(cookie),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.TTL),
+	}
+	sealed, err := s.seal(tok, stored.ID)
+	if err != nil {
+		return err
+	}
+	stored.Token = sealed
+	if err := s.store.PutSession(c.Request().Context(), stored); err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookie,
+		Value:    cookie,
+		Path:     "/",
+		Expires:  stored.ExpiresAt,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Session is the session of a request.
+type Session struct {
+	UserID string
+	// TokenSource yields the user's access token, refreshing and storing it
+	// as needed.
+	TokenSource oauth2.TokenSource
+}
+
+// Lookup returns the session named by the request's cookie.
+func (s *Sessions) Lookup(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	ctx := r.Context()
+	stored, err := s.store.GetSession(ctx, hashID(cookie.Value))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		s.store.DeleteSession(ctx, stored.ID)
+		return nil, ErrNoSession
+	}
+	tok, err := s.open(stored.Token, stored.ID)
+	if err != nil {
+		// Sealed under another key, e.g. before SAI_SESSION_KEY changed:
+		// the session is of no use, so the user signs in again.
+		s.store.DeleteSession(ctx, stored.ID)
+		return nil, ErrNoSession
+	}
+
+	// Refreshes outlive the request, e.g. in a background job.
+	base := oauth2.ReuseTokenSource(tok, s.oauth.TokenSource(context.Background(), tok))
+	return &Session{
+		UserID:      stored.UserID,
+		TokenSource: &storingSource{s: s, stored: stored, base: base, last: tok.AccessToken},
+	}, nil
+}
+
+// Destroy ends the request's session: the stored session is deleted, the
+// Google grant revoked and the cookie cleared.
+func (s *Sessions) Destroy(c echo.Context) error {
+	defer c.SetCookie(&http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+	ctx := c.Request().Context()
+	id := hashID(cookie.Value)
+	stored, err := s.store.GetSession(ctx, id)
+	if errors.Is(err, ErrNoSession) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if tok, err := s.open(stored.Token, id); err == nil {
+		revoke(ctx, tok)
+	}
+	return s.store.DeleteSession(ctx, id)
+}
+
+// revoke asks Google to drop the grant behind tok. Failure is only logged:
+// the session is gone either way.
+func revoke(ctx context.Context, tok *oauth2.Token) {
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("auth: failed to revoke token: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// storingSource writes refreshed tokens back to the session store.
+type storingSource struct {
+	s      *Sessions
+	stored *StoredSession
+	base   oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string
+}
+
+func (t *storingSource) Token() (*oauth2.Token, error) {
+	tok, err := t.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tok.AccessToken == t.last {
+		return tok, nil
+	}
+	t.last = tok.AccessToken
+	if sealed, err := t.s.seal(tok, t.stored.ID); err == nil {
+		updated := *t.stored
+		updated.Token = sealed
+		if err := t.s.store.PutSession(context.Background(), &updated); err != nil {
+			log.Printf("auth: failed to store refreshed token: %v", err)
+		}
+	}
+	return tok, nil
+}
+
+type tokenSourceKey struct{}
+
+// WithTokenSource returns a context carrying the user's Google credentials.
+func WithTokenSource(ctx context.Context, ts oauth2.TokenSource) context.Context {
+	return context.WithValue(ctx, tokenSourceKey{}, ts)
+}
+
+// TokenSourceFrom returns the Google credentials of a request context, or
+// nil.
+func TokenSourceFrom(ctx context.Context) oauth2.TokenSource {
+	ts, _ := ctx.Value(tokenSourceKey{}).(oauth2.TokenSource)
+	return ts
+}
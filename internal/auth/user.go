@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+// LocalUserID is the single user of a server running without sign-in.
+const LocalUserID = "local"
+
+// User is an account, keyed by the Google subject ID.
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Picture   string    `json:"picture,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// ErrNoUser is returned by UserStore.GetUser for unknown users.
+var ErrNoUser = errors.New("auth: no such user")
+
+// UserStore keeps user records.
+type UserStore interface {
+	GetUser(ctx context.Context, id string) (*User, error)
+	PutUser(ctx context.Context, u *User) error
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying the authenticated user.
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFrom returns the authenticated user of a request context, or nil.
+func UserFrom(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
+
+// lastSeenInterval limits how often a returning user's record is rewritten.
+const lastSeenInterval = time.Hour
+
+// AccessTokenHeader lets API clients that sign in with a bearer ID token
+// pass the Google access token used to call Gemini for them.
+const AccessTokenHeader = "X-Google-Access-Token"
+
+// Middleware authenticates requests and puts the user, and their Google
+// credentials if any, into the request context. Browsers are identified by
+// their session cookie, API clients by a Google ID token in the
+// Authorization header. With a nil verifier sign-in is off and every request
+// runs as the local user.
+func Middleware(v *Verifier, users UserStore, sessions *Sessions) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := req.Context()
+
+			if v == nil {
+				c.SetRequest(req.WithContext(WithUser(ctx, &User{ID: LocalUserID, Name: "Local"})))
+				return next(c)
+			}
+
+			if sessions != nil {
+				sess, err := sessions.Lookup(req)
+				if err == nil {
+					u, err := users.GetUser(ctx, sess.UserID)
+					if err != nil {
+						return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Session user not found"})
+					}
+					ctx = WithTokenSource(WithUser(ctx, u), sess.TokenSource)
+					c.SetRequest(req.WithContext(ctx))
+					return next(c)
+				}
+				if !errors.Is(err, ErrNoSession) {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				}
+			}
+
+			token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Not signed in"})
+			}
+			claims, err := v.Verify(ctx, token)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			}
+			u, err := RecordUser(ctx, users, claims)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			}
+			ctx = WithUser(ctx, u)
+			if access := req.Header.Get(AccessTokenHeader); access != "" {
+				ctx = WithTokenSource(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: access}))
+			}
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// RecordUser creates or refreshes the record of a signed-in user.
+func RecordUser(ctx context.Context, users UserStore, claims *Claims) (*User, error) {
+	now := time.Now()
+	u, err := users.GetUser(ctx, claims.Subject)
+	if errors.Is(err, ErrNoUser) {
+		u = &User{ID: claims.Subject, CreatedAt: now}
+	} else if err != nil {
+		return nil, err
+	}
+
+	changed := u.Email != claims.Email || u.Name != claims.Name || u.Picture != claims.Picture
+	if !changed && now.Sub(u.LastSeen) < lastSeenInterval {
+		return u, nil
+	}
+	u.Email = claims.Email
+	u.Name = claims.Name
+	u.Picture = claims.Picture
+	u.LastSeen = now
+	if err := users.PutUser(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
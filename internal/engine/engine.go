@@ -0,0 +1,164 @@
+// Package engine talks to local Go engines such as KataGo or GnuGo so the
+// coach can rely on real evaluations instead of the language model's guess.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/sweetfish329/sai/internal/game"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// Analyzer evaluates positions.
+type Analyzer interface {
+	Analyze(ctx context.Context, pos Position) (*Evaluation, error)
+	Close() error
+}
+
+// GameAnalyzer evaluates every position of a game in one batch.
+type GameAnalyzer interface {
+	AnalyzeGame(ctx context.Context, pos Position, onTurn func(TurnResult)) ([]TurnResult, error)
+}
+
+// Move is a move in GTP notation: Color is "B" or "W", Vertex is e.g. "D4"
+// or "pass".
+type Move struct {
+	Color  string `json:"color"`
+	Vertex string `json:"vertex"`
+}
+
+// Position is a game position described by the moves leading to it.
+type Position struct {
+	Size  int     `json:"size"`
+	Komi  float64 `json:"komi"`
+	Rules string  `json:"rules"`
+	// Setup are the stones placed before the first move (handicap or
+	// AB/AW/AE). Positions with setup after the first move are refused.
+	Setup []Move `json:"setup,omitempty"`
+	Moves []Move `json:"moves"`
+	// ToPlay is the colour to move, "B" or "W".
+	ToPlay string `json:"toPlay"`
+}
+
+// Evaluation is an engine's view of a position. Winrates and score leads
+// are from Black's point of view.
+type Evaluation struct {
+	Winrate    float64     `json:"winrate"`
+	ScoreLead  float64     `json:"scoreLead"`
+	Visits     int         `json:"visits"`
+	BestMove   string      `json:"bestMove"`
+	Candidates []Candidate `json:"candidates,omitempty"`
+}
+
+// Candidate is a move the engine considered, in GTP notation.
+type Candidate struct {
+	Move      string   `json:"move"`
+	Order     int      `json:"order"`
+	Visits    int      `json:"visits"`
+	Winrate   float64  `json:"winrate"`
+	ScoreLead float64  `json:"scoreLead"`
+	Prior     float64  `json:"prior"`
+	PV        []string `json:"pv,omitempty"`
+}
+
+// PositionFromSGF builds the position after moveNumber moves of the main
+// line (all moves if negative).
+func PositionFromSGF(root *sgf.Node, moveNumber int) (Position, error) {
+	size, err := game.BoardSize(root)
+	if err != nil {
+		return Position{}, err
+	}
+	if size > game.MaxGTPSize {
+		return Position{}, fmt.Errorf("engines cannot play on a %dx%d board; GTP addresses at most %dx%d", size, size, game.MaxGTPSize, game.MaxGTPSize)
+	}
+	rules, _ := game.RulesetFromSGF(root.Get("RU"))
+	komi, ok := game.KomiFromSGF(root)
+	if !ok {
+		komi = rules.Komi
+	}
+	pos := Position{Size: size, Komi: komi, Rules: rules.Name, ToPlay: "B"}
+
+	// Replay first so illegal records are rejected before reaching the
+	// engine.
+	if _, err := game.FromSGF(root, moveNumber); err != nil {
+		return Position{}, err
+	}
+
+	// GTP engines only take setup before the first move; stones added or
+	// removed later cannot be told apart from moves and would shift the
+	// turns that evaluations are matched to.
+	played := 0
+	addSetup := func(node *sgf.Node) error {
+		for _, s := range []struct{ key, color string }{{"AE", ""}, {"AB", "B"}, {"AW", "W"}} {
+			points, err := game.ExpandPoints(node.Properties[s.key], size)
+			if err != nil {
+				return err
+			}
+			if len(points) > 0 && played > 0 {
+				return fmt.Errorf("move %d: engines cannot take setup stones (%s) after the first move", played, s.key)
+			}
+			for _, p := range points {
+				vertex, err := game.GTPPoint(p[0], p[1], size)
+				if err != nil {
+					return err
+				}
+				pos.Setup = slices.DeleteFunc(pos.Setup, func(m Move) bool { return m.Vertex == vertex })
+				if s.color != "" {
+					pos.Setup = append(pos.Setup, Move{Color: s.color, Vertex: vertex})
+				}
+			}
+		}
+		return nil
+	}
+
+	for node := root; ; node = node.Children[0] {
+		if err := addSetup(node); err != nil {
+			return Position{}, err
+		}
+		if node == root {
+			// With handicap stones White moves first.
+			if len(root.Properties["AB"]) > 0 && len(root.Properties["AW"]) == 0 {
+				pos.ToPlay = "W"
+			}
+			if pl := root.Get("PL"); pl == "B" || pl == "W" {
+				pos.ToPlay = pl
+			}
+		}
+
+		if color := sgf.MoveColor(node); color != "" && (moveNumber < 0 || played < moveNumber) {
+			x, y, pass, err := game.ParsePoint(node.Get(color), size)
+			if err != nil {
+				return Position{}, fmt.Errorf("move %d: %w", played+1, err)
+			}
+			vertex := "pass"
+			if !pass {
+				if vertex, err = game.GTPPoint(x, y, size); err != nil {
+					return Position{}, fmt.Errorf("move %d: %w", played+1, err)
+				}
+			}
+			pos.Play(color, vertex)
+			played++
+		}
+
+		if len(node.Children) == 0 || (moveNumber >= 0 && played >= moveNumber) {
+			break
+		}
+	}
+	return pos, nil
+}
+
+// Play appends a move to the position; vertex is in GTP notation or
+// "pass".
+func (p *Position) Play(color, vertex string) {
+	p.Moves = append(p.Moves, Move{Color: color, Vertex: vertex})
+	p.ToPlay = opponent(color)
+}
+
+func opponent(color string) string {
+	if color == "B" {
+		return "W"
+	}
+	return "B"
+}
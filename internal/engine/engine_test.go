@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// TestMain lets the test binary double as a fake engine: when SAI_FAKE_GTP
+// or SAI_FAKE_KATAGO is set it serves that protocol on stdin/stdout instead
+// of running the tests.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("SAI_FAKE_GTP"); mode != "" {
+		fakeGTP(mode)
+		os.Exit(0)
+	}
+	if responses := os.Getenv("SAI_FAKE_KATAGO"); responses != "" {
+		fakeKataGo(responses)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPositionFromSGF(t *testing.T) {
+	roots, err := sgf.Parse("(;SZ[9]KM[7]RU[Chinese]AB[cc];W[ee];B[];W[gg])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	pos, err := PositionFromSGF(roots[0], 2)
+	if err != nil {
+		t.Fatalf("PositionFromSGF error: %v", err)
+	}
+	expected := []Move{{"W", "E5"}, {"B", "pass"}}
+	if fmt.Sprint(pos.Moves) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, pos.Moves)
+	}
+	if len(pos.Setup) != 1 || pos.Setup[0].Vertex != "C7" {
+		t.Errorf("expected setup stone at C7, got %v", pos.Setup)
+	}
+	if pos.ToPlay != "W" || pos.Komi != 7 || pos.Rules != "Chinese" {
+		t.Errorf("unexpected position: %+v", pos)
+	}
+}
+
+func TestPositionFromSGFLargeBoard(t *testing.T) {
+	roots, err := sgf.Parse("(;SZ[26];B[zz])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if _, err := PositionFromSGF(roots[0], -1); err == nil {
+		t.Error("expected an error for a board GTP cannot address")
+	}
+}
+
+func TestPositionFromSGFSetup(t *testing.T) {
+	// Setup before the first move is setup, AE included.
+	roots, err := sgf.Parse("(;SZ[9]AB[cc][gg];AE[gg]AW[ee];B[dd];AB[ff];W[ce])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	pos, err := PositionFromSGF(roots[0], 1)
+	if err != nil {
+		t.Fatalf("PositionFromSGF error: %v", err)
+	}
+	expected := []Move{{"B", "C7"}, {"W", "E5"}}
+	if fmt.Sprint(pos.Setup) != fmt.Sprint(expected) || len(pos.Moves) != 1 {
+		t.Errorf("expected setup %v and one move, got %+v", expected, pos)
+	}
+
+	// Stones added after the first move are refused rather than taken for
+	// moves.
+	if _, err := PositionFromSGF(roots[0], -1); err == nil {
+		t.Error("expected an error for setup after the first move")
+	}
+}
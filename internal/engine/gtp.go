@@ -0,0 +1,455 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GTPError is a failure response ("? message") from the engine.
+type GTPError struct {
+	Command string
+	Message string
+}
+
+func (e *GTPError) Error() string {
+	return fmt.Sprintf("gtp: %s: %s", e.Command, e.Message)
+}
+
+// ErrEngineExited is returned when the engine process stops responding.
+var ErrEngineExited = errors.New("gtp: engine exited")
+
+// GTPOptions configures a GTP engine.
+type GTPOptions struct {
+	// AnalyzeTime is how long kata-analyze runs per position. Zero means
+	// one second.
+	AnalyzeTime time.Duration
+	// Stderr receives the engine's diagnostic output. Nil discards it.
+	Stderr io.Writer
+}
+
+// GTP drives an engine speaking the Go Text Protocol over stdin/stdout. It
+// uses kata-analyze when the engine supports it and falls back to
+// reg_genmove or genmove otherwise. Commands are serialized, so a GTP value
+// may be shared between goroutines.
+type GTP struct {
+	opts     GTPOptions
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lines    chan string
+	commands map[string]bool
+	// id numbers the commands, so that a late response to a command whose
+	// wait was cancelled is not taken for the response to the next one.
+	id int
+
+	mu sync.Mutex
+}
+
+// StartGTP starts the engine command and checks that it speaks GTP.
+func StartGTP(ctx context.Context, opts GTPOptions, name string, args ...string) (*GTP, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = opts.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("gtp: failed to start %s: %w", name, err)
+	}
+
+	g := newGTP(opts, stdin, stdout)
+	g.cmd = cmd
+
+	if err := g.init(ctx); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+func newGTP(opts GTPOptions, stdin io.WriteCloser, stdout io.Reader) *GTP {
+	if opts.AnalyzeTime == 0 {
+		opts.AnalyzeTime = time.Second
+	}
+	g := &GTP{
+		opts:     opts,
+		stdin:    stdin,
+		lines:    make(chan string, 64),
+		commands: make(map[string]bool),
+	}
+
+	// Reading happens in its own goroutine so that every wait can honour
+	// a context.
+	go func() {
+		defer close(g.lines)
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			g.lines <- strings.TrimRight(sc.Text(), "\r")
+		}
+	}()
+	return g
+}
+
+func (g *GTP) init(ctx context.Context) error {
+	list, err := g.Command(ctx, "list_commands")
+	if err != nil {
+		return err
+	}
+	for _, c := range strings.Fields(list) {
+		g.commands[c] = true
+	}
+	return nil
+}
+
+// Supports reports whether the engine listed the command.
+func (g *GTP) Supports(command string) bool {
+	return g.commands[command]
+}
+
+// Command sends one command and returns the response text without the
+// leading "=".
+func (g *GTP) Command(ctx context.Context, command string, args ...string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.command(ctx, command, args...)
+}
+
+func (g *GTP) command(ctx context.Context, command string, args ...string) (string, error) {
+	id, err := g.send(command, args...)
+	if err != nil {
+		return "", err
+	}
+	return g.readResponse(ctx, command, id)
+}
+
+// send writes a command with the next ID and returns the ID.
+func (g *GTP) send(command string, args ...string) (string, error) {
+	g.id++
+	id := strconv.Itoa(g.id)
+	line := strings.Join(append([]string{id, command}, args...), " ")
+	if _, err := io.WriteString(g.stdin, line+"\n"); err != nil {
+		return "", fmt.Errorf("gtp: %s: %w", command, err)
+	}
+	return id, nil
+}
+
+// isResponse reports whether line starts the response to command id: "="
+// or "?", the ID, then a space or the end of the line. Responses to earlier
+// commands are not.
+func isResponse(line, id string) bool {
+	if !strings.HasPrefix(line, "=") && !strings.HasPrefix(line, "?") {
+		return false
+	}
+	rest, ok := strings.CutPrefix(line[1:], id)
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
+
+func (g *GTP) readLine(ctx context.Context) (string, error) {
+	select {
+	case line, ok := <-g.lines:
+		if !ok {
+			return "", ErrEngineExited
+		}
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// readResponse reads the full response to command id: a line starting with
+// '=' or '?' and the ID, followed by more lines up to an empty line. Output
+// left over from earlier commands is skipped.
+func (g *GTP) readResponse(ctx context.Context, command, id string) (string, error) {
+	var first string
+	for {
+		line, err := g.readLine(ctx)
+		if err != nil {
+			return "", err
+		}
+		if isResponse(line, id) {
+			first = line
+			break
+		}
+	}
+
+	body := []string{strings.TrimSpace(first[1+len(id):])}
+	for {
+		line, err := g.readLine(ctx)
+		if err != nil {
+			return "", err
+		}
+		if line == "" {
+			break
+		}
+		body = append(body, line)
+	}
+
+	text := strings.TrimSpace(strings.Join(body, "\n"))
+	if first[0] == '?' {
+		return "", &GTPError{Command: command, Message: text}
+	}
+	return text, nil
+}
+
+// setPosition loads a position with boardsize, clear_board, komi and play.
+func (g *GTP) setPosition(ctx context.Context, pos Position) error {
+	if _, err := g.command(ctx, "boardsize", strconv.Itoa(pos.Size)); err != nil {
+		return err
+	}
+	if _, err := g.command(ctx, "clear_board"); err != nil {
+		return err
+	}
+	if _, err := g.command(ctx, "komi", strconv.FormatFloat(pos.Komi, 'f', -1, 64)); err != nil {
+		return err
+	}
+	if pos.Rules != "" && g.commands["kata-set-rules"] {
+		if _, err := g.command(ctx, "kata-set-rules", kataRules(pos.Rules)); err != nil {
+			return err
+		}
+	}
+	// Concat makes a new slice; appending to Setup could write into the
+	// caller's array.
+	for _, m := range slices.Concat(pos.Setup, pos.Moves) {
+		if _, err := g.command(ctx, "play", m.Color, m.Vertex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenMove loads the position and asks the engine for a move without
+// keeping it on the board.
+func (g *GTP) GenMove(ctx context.Context, pos Position) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.setPosition(ctx, pos); err != nil {
+		return "", err
+	}
+	return g.genMove(ctx, pos.ToPlay)
+}
+
+func (g *GTP) genMove(ctx context.Context, color string) (string, error) {
+	if g.commands["reg_genmove"] {
+		return g.command(ctx, "reg_genmove", color)
+	}
+	move, err := g.command(ctx, "genmove", color)
+	if err != nil {
+		return "", err
+	}
+	if _, err := g.command(ctx, "undo"); err != nil {
+		return "", err
+	}
+	return move, nil
+}
+
+// Analyze evaluates a position. Engines without kata-analyze only report a
+// best move.
+func (g *GTP) Analyze(ctx context.Context, pos Position) (*Evaluation, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.setPosition(ctx, pos); err != nil {
+		return nil, err
+	}
+
+	if !g.commands["kata-analyze"] {
+		move, err := g.genMove(ctx, pos.ToPlay)
+		if err != nil {
+			return nil, err
+		}
+		return &Evaluation{BestMove: move}, nil
+	}
+
+	candidates, err := g.kataAnalyze(ctx, pos.ToPlay)
+	if err != nil {
+		return nil, err
+	}
+	return evaluationFromCandidates(candidates, pos.ToPlay), nil
+}
+
+// kataAnalyze runs kata-analyze for the configured time and returns the
+// last reported set of candidates.
+func (g *GTP) kataAnalyze(ctx context.Context, color string) ([]Candidate, error) {
+	id, err := g.send("kata-analyze", color, "10")
+	if err != nil {
+		return nil, err
+	}
+
+	// The "=" header comes first.
+	for {
+		line, err := g.readLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !isResponse(line, id) {
+			continue
+		}
+		if strings.HasPrefix(line, "?") {
+			return nil, &GTPError{Command: "kata-analyze", Message: strings.TrimSpace(line[1+len(id):])}
+		}
+		break
+	}
+
+	var latest []Candidate
+	timer := time.NewTimer(g.opts.AnalyzeTime)
+	defer timer.Stop()
+
+collect:
+	for {
+		select {
+		case line, ok := <-g.lines:
+			if !ok {
+				return nil, ErrEngineExited
+			}
+			if c := parseKataInfo(line); len(c) > 0 {
+				latest = c
+			}
+		case <-timer.C:
+			break collect
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Any new command stops the analysis, which then ends its response
+	// with an empty line.
+	stop, err := g.send("protocol_version")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		line, err := g.readLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if c := parseKataInfo(line); len(c) > 0 {
+			latest = c
+		}
+	}
+	if _, err := g.readResponse(ctx, "protocol_version", stop); err != nil {
+		return nil, err
+	}
+
+	return latest, nil
+}
+
+// parseKataInfo parses one line of kata-analyze output, which holds an
+// "info move ... pv ..." entry per candidate.
+func parseKataInfo(line string) []Candidate {
+	if !strings.HasPrefix(line, "info ") {
+		return nil
+	}
+
+	var candidates []Candidate
+	var c *Candidate
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		key := fields[i]
+		if key == "info" {
+			candidates = append(candidates, Candidate{})
+			c = &candidates[len(candidates)-1]
+			continue
+		}
+		if c == nil || i+1 >= len(fields) {
+			break
+		}
+		if key == "pv" {
+			// The principal variation runs to the next "info".
+			for i+1 < len(fields) && fields[i+1] != "info" {
+				i++
+				c.PV = append(c.PV, fields[i])
+			}
+			continue
+		}
+
+		i++
+		val := fields[i]
+		switch key {
+		case "move":
+			c.Move = val
+		case "order":
+			c.Order, _ = strconv.Atoi(val)
+		case "visits":
+			c.Visits, _ = strconv.Atoi(val)
+		case "winrate":
+			c.Winrate, _ = strconv.ParseFloat(val, 64)
+		case "scoreLead":
+			c.ScoreLead, _ = strconv.ParseFloat(val, 64)
+		case "prior":
+			c.Prior, _ = strconv.ParseFloat(val, 64)
+		}
+	}
+	return candidates
+}
+
+// evaluationFromCandidates turns kata-analyze output, which is from the
+// point of view of the player to move, into a Black-perspective evaluation.
+func evaluationFromCandidates(candidates []Candidate, toPlay string) *Evaluation {
+	eval := &Evaluation{}
+	for i := range candidates {
+		c := &candidates[i]
+		if toPlay == "W" {
+			c.Winrate = 1 - c.Winrate
+			c.ScoreLead = -c.ScoreLead
+		}
+		eval.Visits += c.Visits
+	}
+	eval.Candidates = candidates
+
+	for _, c := range candidates {
+		if c.Order == 0 {
+			eval.BestMove = c.Move
+			eval.Winrate = c.Winrate
+			eval.ScoreLead = c.ScoreLead
+			break
+		}
+	}
+	return eval
+}
+
+// Close asks the engine to quit and kills it if it does not exit in time.
+func (g *GTP) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	g.command(ctx, "quit")
+	g.stdin.Close()
+
+	if g.cmd == nil {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		// Wait must not run before stdout has been read to the end.
+		for range g.lines {
+		}
+		done <- g.cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		g.cmd.Process.Kill()
+		return <-done
+	}
+}
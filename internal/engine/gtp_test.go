@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGTP is a tiny engine. In "kata" mode it supports kata-analyze and
+// always prefers D4 with a 0.7 winrate for the player to move; in "basic"
+// mode it only has genmove. "slow" takes 200ms to answer.
+func fakeGTP(mode string) {
+	in := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			in <- sc.Text()
+		}
+		close(in)
+	}()
+
+	out := bufio.NewWriter(os.Stdout)
+	var id string
+	reply := func(s string) {
+		fmt.Fprintf(out, "=%s %s\n\n", id, s)
+		out.Flush()
+	}
+
+	for line := range in {
+		fields := strings.Fields(line)
+		id = ""
+		if len(fields) > 0 && strings.Trim(fields[0], "0123456789") == "" {
+			id, fields = fields[0], fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "list_commands":
+			cmds := "boardsize\nclear_board\nkomi\nplay\ngenmove\nundo\nquit"
+			if mode == "kata" {
+				cmds += "\nkata-analyze\nkata-set-rules"
+			}
+			reply(cmds)
+		case "kata-set-rules":
+			if fields[1] != "japanese" && fields[1] != "new-zealand" {
+				fmt.Fprintf(out, "?%s unknown rules\n\n", id)
+				out.Flush()
+				continue
+			}
+			reply("")
+		case "play":
+			if fields[2] == "Z99" {
+				fmt.Fprintf(out, "?%s illegal move\n\n", id)
+				out.Flush()
+				continue
+			}
+			reply("")
+		case "genmove":
+			reply("D4")
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+			reply("late")
+		case "kata-analyze":
+			fmt.Fprintf(out, "=%s\n", id)
+			out.Flush()
+			ticker := time.NewTicker(10 * time.Millisecond)
+			for analyzing := true; analyzing; {
+				select {
+				case <-ticker.C:
+					fmt.Fprint(out, "info move D4 visits 30 winrate 0.7 scoreLead 3.5 prior 0.4 order 0 pv D4 C3 "+
+						"info move C3 visits 10 winrate 0.6 scoreLead 1.5 prior 0.2 order 1 pv C3\n")
+					out.Flush()
+				case next := <-in:
+					ticker.Stop()
+					fmt.Fprint(out, "\n")
+					if f := strings.Fields(next); len(f) == 2 && f[1] == "protocol_version" {
+						id = f[0]
+						reply("2")
+					}
+					analyzing = false
+				}
+			}
+		case "quit":
+			reply("")
+			return
+		default:
+			reply("")
+		}
+	}
+}
+
+func startFake(t *testing.T, mode string) *GTP {
+	t.Helper()
+	t.Setenv("SAI_FAKE_GTP", mode)
+	g, err := StartGTP(context.Background(), GTPOptions{AnalyzeTime: 50 * time.Millisecond}, os.Args[0])
+	if err != nil {
+		t.Fatalf("StartGTP error: %v", err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestGTPKataAnalyze(t *testing.T) {
+	g := startFake(t, "kata")
+	if !g.Supports("kata-analyze") {
+		t.Fatal("expected kata-analyze to be listed")
+	}
+
+	pos := Position{Size: 9, Komi: 6.5, Moves: []Move{{Color: "B", Vertex: "E5"}}, ToPlay: "W"}
+	eval, err := g.Analyze(context.Background(), pos)
+	if err != nil {
+		t.Fatalf("Analyze error: %v", err)
+	}
+	if eval.BestMove != "D4" || len(eval.Candidates) != 2 || len(eval.Candidates[0].PV) != 2 {
+		t.Fatalf("unexpected evaluation: %+v", eval)
+	}
+	// White is to move, so the numbers are flipped to Black's view.
+	if eval.Winrate > 0.31 || eval.ScoreLead != -3.5 {
+		t.Errorf("expected Black-perspective numbers, got %f %f", eval.Winrate, eval.ScoreLead)
+	}
+
+	// SGF rule names are translated for kata-set-rules, and the caller's
+	// setup is left alone.
+	setup := make([]Move, 1, 2)
+	setup[0] = Move{Color: "B", Vertex: "C3"}
+	pos = Position{Size: 9, Rules: "NZ", Setup: setup, Moves: []Move{{Color: "W", Vertex: "E5"}}, ToPlay: "B"}
+	if _, err := g.Analyze(context.Background(), pos); err != nil {
+		t.Fatalf("Analyze with NZ rules: %v", err)
+	}
+	if spare := setup[:2][1]; spare != (Move{}) {
+		t.Errorf("setup was written to: %+v", spare)
+	}
+
+	// The engine is still usable after analysis stops.
+	if _, err := g.Command(context.Background(), "komi", "7.5"); err != nil {
+		t.Errorf("command after analysis failed: %v", err)
+	}
+}
+
+func TestGTPGenMoveFallback(t *testing.T) {
+	g := startFake(t, "basic")
+
+	eval, err := g.Analyze(context.Background(), Position{Size: 19, ToPlay: "B"})
+	if err != nil {
+		t.Fatalf("Analyze error: %v", err)
+	}
+	if eval.BestMove != "D4" || len(eval.Candidates) != 0 {
+		t.Errorf("unexpected evaluation: %+v", eval)
+	}
+}
+
+func TestGTPError(t *testing.T) {
+	g := startFake(t, "basic")
+
+	_, err := g.Analyze(context.Background(), Position{Size: 19, Moves: []Move{{Color: "B", Vertex: "Z99"}}})
+	var gtpErr *GTPError
+	if !errors.As(err, &gtpErr) || gtpErr.Message != "illegal move" {
+		t.Errorf("expected GTPError, got %v", err)
+	}
+}
+
+func TestGTPCancelledCommand(t *testing.T) {
+	g := startFake(t, "basic")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := g.Command(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+
+	// The late answer to "slow" must not be read as the answer to genmove.
+	move, err := g.Command(context.Background(), "genmove", "B")
+	if err != nil || move != "D4" {
+		t.Errorf("expected D4, got %q, %v", move, err)
+	}
+}
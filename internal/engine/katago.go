@@ -0,0 +1,343 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KataGoOptions configures the KataGo analysis engine.
+type KataGoOptions struct {
+	// MaxVisits limits the search per position. Zero uses the engine's
+	// configured default.
+	MaxVisits int
+	// Stderr receives the engine's diagnostic output. Nil discards it.
+	Stderr io.Writer
+}
+
+// KataGo drives "katago analysis", which takes JSON queries on stdin and
+// answers with one JSON line per analysed turn. Queries may run
+// concurrently; responses are routed by query ID.
+type KataGo struct {
+	opts  KataGoOptions
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  chan struct{}
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan kataResponse
+	err     error
+}
+
+// TurnResult is the evaluation of the position after Turn moves.
+type TurnResult struct {
+	Turn int `json:"turn"`
+	Evaluation
+}
+
+// MoveEvaluation rates a played move by comparing the positions before and
+// after it.
+type MoveEvaluation struct {
+	MoveNumber int    `json:"moveNumber"`
+	Color      string `json:"color"`
+	Move       string `json:"move"`
+	// BestMove is the engine's preferred move in the position before.
+	BestMove string `json:"bestMove"`
+	// PointsLost is how many points the move cost the player who made it,
+	// compared with the engine's evaluation before the move. It can be
+	// slightly negative when the move was better than expected.
+	PointsLost float64 `json:"pointsLost"`
+	// WinrateLost is the equivalent in winrate for the mover.
+	WinrateLost float64     `json:"winrateLost"`
+	Before      *Evaluation `json:"before"`
+	After       *Evaluation `json:"after"`
+}
+
+type kataQuery struct {
+	ID               string         `json:"id"`
+	InitialStones    [][2]string    `json:"initialStones"`
+	InitialPlayer    string         `json:"initialPlayer,omitempty"`
+	Moves            [][2]string    `json:"moves"`
+	Rules            string         `json:"rules"`
+	Komi             float64        `json:"komi"`
+	BoardXSize       int            `json:"boardXSize"`
+	BoardYSize       int            `json:"boardYSize"`
+	AnalyzeTurns     []int          `json:"analyzeTurns"`
+	MaxVisits        int            `json:"maxVisits,omitempty"`
+	OverrideSettings map[string]any `json:"overrideSettings,omitempty"`
+}
+
+type kataResponse struct {
+	ID             string `json:"id"`
+	Error          string `json:"error"`
+	Warning        string `json:"warning"`
+	TurnNumber     int    `json:"turnNumber"`
+	IsDuringSearch bool   `json:"isDuringSearch"`
+	RootInfo       struct {
+		Winrate   float64 `json:"winrate"`
+		ScoreLead float64 `json:"scoreLead"`
+		Visits    int     `json:"visits"`
+	} `json:"rootInfo"`
+	MoveInfos []Candidate `json:"moveInfos"`
+}
+
+// StartKataGo starts the analysis engine command, e.g.
+// "katago analysis -model model.bin.gz -config analysis.cfg".
+func StartKataGo(opts KataGoOptions, name string, args ...string) (*KataGo, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = opts.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("katago: failed to start %s: %w", name, err)
+	}
+
+	k := &KataGo{
+		opts:    opts,
+		cmd:     cmd,
+		stdin:   stdin,
+		done:    make(chan struct{}),
+		pending: make(map[string]chan kataResponse),
+	}
+	go k.read(stdout)
+	return k, nil
+}
+
+// read dispatches response lines to the queries waiting for them.
+func (k *KataGo) read(stdout io.Reader) {
+	defer close(k.done)
+
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var resp kataResponse
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			log.Printf("katago: invalid response: %v", err)
+			continue
+		}
+		if resp.Warning != "" {
+			log.Printf("katago: warning for %s: %s", resp.ID, resp.Warning)
+			continue
+		}
+
+		k.mu.Lock()
+		ch := k.pending[resp.ID]
+		k.mu.Unlock()
+		if ch == nil {
+			if resp.Error != "" {
+				log.Printf("katago: error: %s", resp.Error)
+			}
+			continue
+		}
+		ch <- resp
+	}
+
+	k.mu.Lock()
+	k.err = errors.New("katago: engine exited")
+	k.mu.Unlock()
+}
+
+// AnalyzeGame evaluates every position of pos: the one before the first
+// move and the one after each move. onTurn, if not nil, is called as soon
+// as each turn is ready; turns may arrive in any order. The result is
+// sorted by turn.
+func (k *KataGo) AnalyzeGame(ctx context.Context, pos Position, onTurn func(TurnResult)) ([]TurnResult, error) {
+	turns := make([]int, len(pos.Moves)+1)
+	for i := range turns {
+		turns[i] = i
+	}
+	return k.query(ctx, pos, turns, onTurn)
+}
+
+// Analyze evaluates the final position of pos.
+func (k *KataGo) Analyze(ctx context.Context, pos Position) (*Evaluation, error) {
+	results, err := k.query(ctx, pos, []int{len(pos.Moves)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &results[0].Evaluation, nil
+}
+
+func (k *KataGo) query(ctx context.Context, pos Position, turns []int, onTurn func(TurnResult)) ([]TurnResult, error) {
+	k.mu.Lock()
+	if k.err != nil {
+		k.mu.Unlock()
+		return nil, k.err
+	}
+	k.nextID++
+	id := strconv.Itoa(k.nextID)
+	ch := make(chan kataResponse, len(turns))
+	k.pending[id] = ch
+	k.mu.Unlock()
+
+	defer func() {
+		k.mu.Lock()
+		delete(k.pending, id)
+		k.mu.Unlock()
+	}()
+
+	q := kataQuery{
+		ID:            id,
+		InitialStones: [][2]string{},
+		Moves:         [][2]string{},
+		Rules:         kataRules(pos.Rules),
+		Komi:          pos.Komi,
+		BoardXSize:    pos.Size,
+		BoardYSize:    pos.Size,
+		AnalyzeTurns:  turns,
+		MaxVisits:     k.opts.MaxVisits,
+		// Everything in this package is from Black's point of view.
+		OverrideSettings: map[string]any{"reportAnalysisWinratesAs": "BLACK"},
+	}
+	for _, m := range pos.Setup {
+		q.InitialStones = append(q.InitialStones, [2]string{m.Color, m.Vertex})
+	}
+	for _, m := range pos.Moves {
+		q.Moves = append(q.Moves, [2]string{m.Color, m.Vertex})
+	}
+	if len(pos.Moves) == 0 {
+		q.InitialPlayer = pos.ToPlay
+	} else {
+		q.InitialPlayer = pos.Moves[0].Color
+	}
+
+	line, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	_, err = k.stdin.Write(append(line, '\n'))
+	k.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("katago: %w", err)
+	}
+
+	results := make([]TurnResult, 0, len(turns))
+	for len(results) < len(turns) {
+		select {
+		case resp := <-ch:
+			if resp.Error != "" {
+				return nil, fmt.Errorf("katago: %s", resp.Error)
+			}
+			if resp.IsDuringSearch {
+				continue
+			}
+			r := TurnResult{Turn: resp.TurnNumber, Evaluation: evaluationFromKata(resp)}
+			results = append(results, r)
+			if onTurn != nil {
+				onTurn(r)
+			}
+		case <-k.done:
+			return nil, errors.New("katago: engine exited")
+		case <-ctx.Done():
+			// Stop the search so the engine does not keep working for
+			// nobody.
+			k.terminate(id)
+			return nil, ctx.Err()
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Turn < results[j].Turn })
+	return results, nil
+}
+
+func (k *KataGo) terminate(id string) {
+	line, _ := json.Marshal(map[string]string{"id": "terminate-" + id, "action": "terminate", "terminateId": id})
+	k.mu.Lock()
+	k.stdin.Write(append(line, '\n'))
+	k.mu.Unlock()
+}
+
+func evaluationFromKata(resp kataResponse) Evaluation {
+	eval := Evaluation{
+		Winrate:    resp.RootInfo.Winrate,
+		ScoreLead:  resp.RootInfo.ScoreLead,
+		Visits:     resp.RootInfo.Visits,
+		Candidates: resp.MoveInfos,
+	}
+	sort.Slice(eval.Candidates, func(i, j int) bool { return eval.Candidates[i].Order < eval.Candidates[j].Order })
+	if len(eval.Candidates) > 0 {
+		eval.BestMove = eval.Candidates[0].Move
+	}
+	return eval
+}
+
+// kataRules maps game.Ruleset names to KataGo rule names.
+func kataRules(name string) string {
+	switch strings.ToLower(name) {
+	case "japanese":
+		return "japanese"
+	case "chinese":
+		return "chinese"
+	case "aga":
+		return "aga"
+	case "nz":
+		return "new-zealand"
+	case "tromp-taylor":
+		return "tromp-taylor"
+	}
+	return "japanese"
+}
+
+// EvaluateMoves compares consecutive turns of an AnalyzeGame result and
+// rates every move of pos.
+func EvaluateMoves(pos Position, turns []TurnResult) []MoveEvaluation {
+	byTurn := make(map[int]*Evaluation, len(turns))
+	for i := range turns {
+		byTurn[turns[i].Turn] = &turns[i].Evaluation
+	}
+
+	var result []MoveEvaluation
+	for i, m := range pos.Moves {
+		before, after := byTurn[i], byTurn[i+1]
+		if before == nil || after == nil {
+			continue
+		}
+		// Numbers are from Black's view, so flip the sign for White.
+		sign := 1.0
+		if m.Color == "W" {
+			sign = -1
+		}
+		result = append(result, MoveEvaluation{
+			MoveNumber:  i + 1,
+			Color:       m.Color,
+			Move:        m.Vertex,
+			BestMove:    before.BestMove,
+			PointsLost:  sign * (before.ScoreLead - after.ScoreLead),
+			WinrateLost: sign * (before.Winrate - after.Winrate),
+			Before:      before,
+			After:       after,
+		})
+	}
+	return result
+}
+
+// Close stops the engine.
+func (k *KataGo) Close() error {
+	k.stdin.Close()
+	select {
+	case <-k.done:
+	case <-time.After(2 * time.Second):
+		k.cmd.Process.Kill()
+		<-k.done
+	}
+	return k.cmd.Wait()
+}
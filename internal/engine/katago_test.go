@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKataGo replays canned analysis responses from a JSON lines file, one
+// per turn, for every turn a query asks for.
+func fakeKataGo(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	canned := make(map[int]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var resp map[string]any
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			panic(err)
+		}
+		canned[int(resp["turnNumber"].(float64))] = resp
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var q kataQuery
+		if err := json.Unmarshal(sc.Bytes(), &q); err != nil || q.ID == "" {
+			continue
+		}
+		if len(q.Moves) > 0 && q.Moves[0][1] == "Z99" {
+			out.Encode(map[string]string{"id": q.ID, "error": "Illegal move 0: Z99"})
+			continue
+		}
+		// Answer in reverse to check that turns are sorted.
+		for i := len(q.AnalyzeTurns) - 1; i >= 0; i-- {
+			resp := canned[q.AnalyzeTurns[i]]
+			resp["id"] = q.ID
+			out.Encode(resp)
+		}
+	}
+}
+
+func startFakeKataGo(t *testing.T) *KataGo {
+	t.Helper()
+	t.Setenv("SAI_FAKE_KATAGO", "testdata/katago_responses.jsonl")
+	k, err := StartKataGo(KataGoOptions{}, os.Args[0])
+	if err != nil {
+		t.Fatalf("StartKataGo error: %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+var testGame = Position{
+	Size:   9,
+	Komi:   6.5,
+	Rules:  "Japanese",
+	Moves:  []Move{{"B", "E5"}, {"W", "G6"}, {"B", "C4"}},
+	ToPlay: "W",
+}
+
+func TestKataGoAnalyzeGame(t *testing.T) {
+	k := startFakeKataGo(t)
+
+	var mu sync.Mutex
+	var streamed []int
+	turns, err := k.AnalyzeGame(context.Background(), testGame, func(r TurnResult) {
+		mu.Lock()
+		streamed = append(streamed, r.Turn)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("AnalyzeGame error: %v", err)
+	}
+	if len(turns) != 4 || len(streamed) != 4 {
+		t.Fatalf("expected 4 turns, got %d (streamed %v)", len(turns), streamed)
+	}
+	for i, r := range turns {
+		if r.Turn != i {
+			t.Errorf("turns not sorted: %v", turns)
+		}
+	}
+	if turns[0].BestMove != "E5" || len(turns[0].Candidates) != 2 {
+		t.Errorf("unexpected first turn: %+v", turns[0])
+	}
+
+	moves := EvaluateMoves(testGame, turns)
+	expected := []float64{-0.1, 6.0, -0.4}
+	if len(moves) != len(expected) {
+		t.Fatalf("expected %d move evaluations, got %d", len(expected), len(moves))
+	}
+	for i, m := range moves {
+		if math.Abs(m.PointsLost-expected[i]) > 1e-9 {
+			t.Errorf("move %d: expected %.1f points lost, got %f", m.MoveNumber, expected[i], m.PointsLost)
+		}
+	}
+	if moves[1].BestMove != "C4" || moves[1].Color != "W" {
+		t.Errorf("unexpected evaluation of move 2: %+v", moves[1])
+	}
+}
+
+func TestKataGoAnalyzeAndErrors(t *testing.T) {
+	k := startFakeKataGo(t)
+
+	eval, err := k.Analyze(context.Background(), testGame)
+	if err != nil {
+		t.Fatalf("Analyze error: %v", err)
+	}
+	if eval.BestMove != "G6" || eval.ScoreLead != 6.9 {
+		t.Errorf("expected the final position, got %+v", eval)
+	}
+
+	bad := testGame
+	bad.Moves = []Move{{"B", "Z99"}}
+	if _, err := k.Analyze(context.Background(), bad); err == nil || !strings.Contains(err.Error(), "Illegal move") {
+		t.Errorf("expected engine error, got %v", err)
+	}
+}
+
+// Both backends can stand in for each other where only Analyze is needed.
+var (
+	_ Analyzer     = (*GTP)(nil)
+	_ Analyzer     = (*KataGo)(nil)
+	_ GameAnalyzer = (*KataGo)(nil)
+)
@@ -8,6 +8,27 @@ const (
 	White
 )
 
+// Opponent returns the other colour. Empty stays Empty.
+func (c StoneColor) Opponent() StoneColor {
+	switch c {
+	case Black:
+		return White
+	case White:
+		return Black
+	}
+	return Empty
+}
+
+func (c StoneColor) String() string {
+	switch c {
+	case Black:
+		return "B"
+	case White:
+		return "W"
+	}
+	return "-"
+}
+
 type Board struct {
 	Size int
 	Grid [][]StoneColor
@@ -21,49 +42,84 @@ func NewBoard(size int) *Board {
 	return &Board{Size: size, Grid: grid}
 }
 
+// Clone returns an independent copy of the board.
+func (b *Board) Clone() *Board {
+	nb := NewBoard(b.Size)
+	for x := range b.Grid {
+		copy(nb.Grid[x], b.Grid[x])
+	}
+	return nb
+}
+
 func (b *Board) Get(x, y int) StoneColor {
-	if x < 0 || x >= b.Size || y < 0 || y >= b.Size {
+	if !b.OnBoard(x, y) {
 		return Empty
 	}
 	return b.Grid[x][y]
 }
 
-func (b *Board) Play(x, y int, c StoneColor) {
-	if x < 0 || x >= b.Size || y < 0 || y >= b.Size {
-		return
+// OnBoard reports whether (x, y) is a point of the board.
+func (b *Board) OnBoard(x, y int) bool {
+	return x >= 0 && x < b.Size && y >= 0 && y < b.Size
+}
+
+// Set puts a stone (or Empty) on a point without resolving captures, as the
+// SGF setup properties AB, AW and AE do.
+func (b *Board) Set(x, y int, c StoneColor) {
+	if b.OnBoard(x, y) {
+		b.Grid[x][y] = c
+	}
+}
+
+// Play places a stone and resolves captures without checking legality. It
+// returns the opponent stones it captured and, if the move was suicide, the
+// player's own stones that were removed. Use Game for rule-checked play.
+func (b *Board) Play(x, y int, c StoneColor) (captured, suicide [][2]int) {
+	if !b.OnBoard(x, y) {
+		return nil, nil
 	}
 
 	// Place stone
 	b.Grid[x][y] = c
 
 	// Check opponent neighbors for capture
-	opp := Black
-	if c == Black {
-		opp = White
-	}
-
-	neighbors := [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
-	for _, n := range neighbors {
+	opp := c.Opponent()
+	for _, n := range neighbors(x, y) {
 		nx, ny := n[0], n[1]
 		if b.Get(nx, ny) == opp {
 			group, liberties := b.getGroupAndLiberties(nx, ny)
 			if liberties == 0 {
 				b.removeGroup(group)
+				captured = append(captured, group...)
 			}
 		}
 	}
 
-	// Check self for suicide (optional, but good for correctness if input is weird)
-	// Usually SGFs are valid, but suicide might remove the stone itself?
-	// Standard rules: suicide is forbidden, but some rules allow it.
-	// Sabaki usually enforces rules. I'll just check if self capture happens.
-	// Check self for suicide
-	_, liberties := b.getGroupAndLiberties(x, y)
+	// A group left without liberties after captures is suicide. Rule sets
+	// that allow it remove the group, so do the same here.
+	group, liberties := b.getGroupAndLiberties(x, y)
 	if liberties == 0 {
-		// Capture self? Or revert?
-		// For replay, we assume moves are valid. If it's suicide, we might leave it or remove it.
-		// I'll leave it for now unless I want to be strict.
+		b.removeGroup(group)
+		suicide = group
+	}
+
+	return captured, suicide
+}
+
+// key returns a compact representation of the position, used to detect
+// repetition.
+func (b *Board) key() string {
+	buf := make([]byte, 0, b.Size*b.Size)
+	for x := range b.Grid {
+		for _, c := range b.Grid[x] {
+			buf = append(buf, byte('0'+c))
+		}
 	}
+	return string(buf)
+}
+
+func neighbors(x, y int) [][2]int {
+	return [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
 }
 
 func (b *Board) getGroupAndLiberties(x, y int) ([][2]int, int) {
@@ -85,9 +141,7 @@ func (b *Board) getGroupAndLiberties(x, y int) ([][2]int, int) {
 		group = append(group, curr)
 
 		cx, cy := curr[0], curr[1]
-		neighbors := [][2]int{{cx - 1, cy}, {cx + 1, cy}, {cx, cy - 1}, {cx, cy + 1}}
-
-		for _, n := range neighbors {
+		for _, n := range neighbors(cx, cy) {
 			nx, ny := n[0], n[1]
 			if nx < 0 || nx >= b.Size || ny < 0 || ny >= b.Size {
 				continue
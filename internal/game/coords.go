@@ -0,0 +1,110 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParsePoint converts an SGF coordinate such as "dd" to board coordinates.
+// An empty value, or "tt" on boards up to 19x19, is a pass.
+func ParsePoint(s string, size int) (x, y int, pass bool, err error) {
+	if s == "" || (s == "tt" && size <= 19) {
+		return 0, 0, true, nil
+	}
+	if len(s) != 2 {
+		return 0, 0, false, fmt.Errorf("invalid point %q", s)
+	}
+	x, y = coord(s[0]), coord(s[1])
+	if x < 0 || x >= size || y < 0 || y >= size {
+		return 0, 0, false, fmt.Errorf("point %q is off a %dx%d board", s, size, size)
+	}
+	return x, y, false, nil
+}
+
+// PointString is the inverse of ParsePoint for points on the board.
+func PointString(x, y int) string {
+	return string([]byte{letter(x), letter(y)})
+}
+
+// coord maps SGF letters a-z to 0-25 and A-Z to 26-51.
+func coord(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 26
+	}
+	return -1
+}
+
+func letter(i int) byte {
+	if i < 26 {
+		return byte('a' + i)
+	}
+	return byte('A' + i - 26)
+}
+
+// ExpandPoints expands an SGF point list, including compressed rectangles
+// such as "aa:cc", into board coordinates.
+func ExpandPoints(values []string, size int) ([][2]int, error) {
+	var points [][2]int
+	for _, v := range values {
+		from, to, compressed := strings.Cut(v, ":")
+		x1, y1, pass, err := ParsePoint(from, size)
+		if err != nil || pass {
+			return nil, fmt.Errorf("invalid point %q", v)
+		}
+		x2, y2 := x1, y1
+		if compressed {
+			if x2, y2, pass, err = ParsePoint(to, size); err != nil || pass {
+				return nil, fmt.Errorf("invalid point %q", v)
+			}
+		}
+		for x := min(x1, x2); x <= max(x1, x2); x++ {
+			for y := min(y1, y2); y <= max(y1, y2); y++ {
+				points = append(points, [2]int{x, y})
+			}
+		}
+	}
+	return points, nil
+}
+
+// gtpColumns are the GTP column letters; GTP skips I.
+const gtpColumns = "ABCDEFGHJKLMNOPQRSTUVWXYZ"
+
+// MaxGTPSize is the largest board GTP can address.
+const MaxGTPSize = len(gtpColumns)
+
+// GTPPoint converts board coordinates to a GTP vertex such as "D4".
+func GTPPoint(x, y, size int) (string, error) {
+	if size > MaxGTPSize {
+		return "", fmt.Errorf("GTP cannot address a %dx%d board", size, size)
+	}
+	if x < 0 || x >= size || y < 0 || y >= size {
+		return "", fmt.Errorf("point (%d, %d) is off a %dx%d board", x, y, size, size)
+	}
+	return fmt.Sprintf("%c%d", gtpColumns[x], size-y), nil
+}
+
+// ParseGTP converts a GTP vertex such as "D4" to board coordinates. "pass"
+// is reported as a pass.
+func ParseGTP(s string, size int) (x, y int, pass bool, err error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "PASS" {
+		return 0, 0, true, nil
+	}
+	if len(s) < 2 {
+		return 0, 0, false, fmt.Errorf("invalid vertex %q", s)
+	}
+	x = strings.IndexByte(gtpColumns, s[0])
+	row, err := strconv.Atoi(s[1:])
+	if x < 0 || err != nil {
+		return 0, 0, false, fmt.Errorf("invalid vertex %q", s)
+	}
+	y = size - row
+	if x >= size || y < 0 || y >= size {
+		return 0, 0, false, fmt.Errorf("vertex %q is off a %dx%d board", s, size, size)
+	}
+	return x, y, false, nil
+}
@@ -0,0 +1,222 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrOutOfBounds = errors.New("point is off the board")
+	ErrOccupied    = errors.New("point is occupied")
+	ErrSuicide     = errors.New("suicide is not allowed")
+	ErrKo          = errors.New("move retakes a ko")
+	ErrSuperko     = errors.New("move repeats an earlier position")
+)
+
+// IllegalMoveError reports why a move was rejected. It unwraps to one of
+// the Err* values above.
+type IllegalMoveError struct {
+	X, Y  int
+	Color StoneColor
+	Err   error
+}
+
+func (e *IllegalMoveError) Error() string {
+	return fmt.Sprintf("illegal move %s at (%d, %d): %v", e.Color, e.X, e.Y, e.Err)
+}
+
+func (e *IllegalMoveError) Unwrap() error {
+	return e.Err
+}
+
+// Move is an entry of the game history.
+type Move struct {
+	Color StoneColor
+	X, Y  int
+	Pass  bool
+	// Captured is the number of opponent stones the move captured.
+	Captured int
+}
+
+// Game is a board with rule enforcement, prisoner counts and undo.
+type Game struct {
+	Board *Board
+	Rules Ruleset
+
+	captures [3]int
+	koPoint  *[2]int
+	koColor  StoneColor
+	moves    []Move
+
+	// positions counts how often each position key has occurred, for
+	// superko detection.
+	positions map[string]int
+	history   []snapshot
+}
+
+// snapshot holds what Undo needs to restore the state before a move.
+type snapshot struct {
+	board    *Board
+	captures [3]int
+	koPoint  *[2]int
+	koColor  StoneColor
+	position string
+}
+
+func NewGame(size int, rules Ruleset) *Game {
+	g := &Game{
+		Board:     NewBoard(size),
+		Rules:     rules,
+		positions: make(map[string]int),
+	}
+	g.positions[g.positionKey(g.Board, Empty)]++
+	return g
+}
+
+// Captures returns the number of prisoners taken by c.
+func (g *Game) Captures(c StoneColor) int {
+	return g.captures[c]
+}
+
+// Moves returns the moves played so far, passes included.
+func (g *Game) Moves() []Move {
+	return g.moves
+}
+
+// Setup places stones as SGF setup properties do: no captures, no rule
+// checks. Empty clears the point. Setup cannot be undone.
+func (g *Game) Setup(x, y int, c StoneColor) error {
+	if !g.Board.OnBoard(x, y) {
+		return &IllegalMoveError{X: x, Y: y, Color: c, Err: ErrOutOfBounds}
+	}
+	g.Board.Set(x, y, c)
+	g.setupDone()
+	return nil
+}
+
+// setupDone records the position created by setup stones.
+func (g *Game) setupDone() {
+	g.koPoint = nil
+	g.positions[g.positionKey(g.Board, Empty)]++
+}
+
+// Legal checks whether c may play at (x, y). The error is an
+// *IllegalMoveError.
+func (g *Game) Legal(x, y int, c StoneColor) error {
+	_, _, _, err := g.try(x, y, c)
+	return err
+}
+
+// Play plays a move after checking it against the rules.
+func (g *Game) Play(x, y int, c StoneColor) error {
+	board, captured, suicide, err := g.try(x, y, c)
+	if err != nil {
+		return err
+	}
+
+	g.push()
+
+	g.Board = board
+	g.captures[c] += len(captured)
+	// Stones lost to an allowed suicide are prisoners for the opponent.
+	g.captures[c.Opponent()] += len(suicide)
+
+	// A single stone capturing a single stone and left in atari is a ko:
+	// the opponent may not retake immediately.
+	g.koPoint = nil
+	if len(captured) == 1 {
+		group, liberties := board.getGroupAndLiberties(x, y)
+		if len(group) == 1 && liberties == 1 {
+			g.koPoint = &captured[0]
+			g.koColor = c.Opponent()
+		}
+	}
+
+	key := g.positionKey(board, c)
+	g.positions[key]++
+	g.history[len(g.history)-1].position = key
+	g.moves = append(g.moves, Move{Color: c, X: x, Y: y, Captured: len(captured)})
+	return nil
+}
+
+// Pass records a pass by c.
+func (g *Game) Pass(c StoneColor) {
+	g.push()
+	g.koPoint = nil
+	g.moves = append(g.moves, Move{Color: c, Pass: true})
+}
+
+// Undo takes back the last move. It returns false if there is nothing to
+// undo.
+func (g *Game) Undo() bool {
+	if len(g.history) == 0 {
+		return false
+	}
+	s := g.history[len(g.history)-1]
+	g.history = g.history[:len(g.history)-1]
+	g.moves = g.moves[:len(g.moves)-1]
+
+	if s.position != "" {
+		g.positions[s.position]--
+		if g.positions[s.position] == 0 {
+			delete(g.positions, s.position)
+		}
+	}
+	g.Board = s.board
+	g.captures = s.captures
+	g.koPoint = s.koPoint
+	g.koColor = s.koColor
+	return true
+}
+
+func (g *Game) push() {
+	g.history = append(g.history, snapshot{
+		board:    g.Board.Clone(),
+		captures: g.captures,
+		koPoint:  g.koPoint,
+		koColor:  g.koColor,
+	})
+}
+
+// try plays the move on a copy of the board and returns the result along
+// with the captured and self-captured stones, or the reason the move is
+// illegal.
+func (g *Game) try(x, y int, c StoneColor) (*Board, [][2]int, [][2]int, error) {
+	illegal := func(err error) (*Board, [][2]int, [][2]int, error) {
+		return nil, nil, nil, &IllegalMoveError{X: x, Y: y, Color: c, Err: err}
+	}
+
+	if c != Black && c != White {
+		return illegal(fmt.Errorf("invalid colour %d", c))
+	}
+	if !g.Board.OnBoard(x, y) {
+		return illegal(ErrOutOfBounds)
+	}
+	if g.Board.Get(x, y) != Empty {
+		return illegal(ErrOccupied)
+	}
+	if g.koPoint != nil && *g.koPoint == [2]int{x, y} && c == g.koColor {
+		return illegal(ErrKo)
+	}
+
+	board := g.Board.Clone()
+	captured, suicide := board.Play(x, y, c)
+	if len(suicide) > 0 && !g.Rules.SuicideAllowed {
+		return illegal(ErrSuicide)
+	}
+
+	if g.Rules.Superko != NoSuperko && g.positions[g.positionKey(board, c)] > 0 {
+		return illegal(ErrSuperko)
+	}
+
+	return board, captured, suicide, nil
+}
+
+// positionKey identifies a position for superko. Situational superko also
+// takes into account who moved last, i.e. who is to play next.
+func (g *Game) positionKey(b *Board, lastMover StoneColor) string {
+	if g.Rules.Superko == SituationalSuperko {
+		return lastMover.String() + b.key()
+	}
+	return b.key()
+}
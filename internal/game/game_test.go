@@ -0,0 +1,188 @@
+package game
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// play is a test helper that plays SGF-coordinate moves alternately,
+// starting with Black, and fails on the first illegal one.
+func play(t *testing.T, g *Game, moves ...string) {
+	t.Helper()
+	c := Black
+	for _, m := range moves {
+		x, y, pass, err := ParsePoint(m, g.Board.Size)
+		if err != nil {
+			t.Fatalf("bad point %q: %v", m, err)
+		}
+		if pass {
+			g.Pass(c)
+		} else if err := g.Play(x, y, c); err != nil {
+			t.Fatalf("move %s: %v", m, err)
+		}
+		c = c.Opponent()
+	}
+}
+
+func TestKo(t *testing.T) {
+	g := NewGame(5, Japanese)
+	//   a b c d
+	// a . B W .
+	// b B . B W
+	// c . B W .
+	for _, s := range []struct {
+		p string
+		c StoneColor
+	}{{"ba", Black}, {"ab", Black}, {"bc", Black}, {"ca", White}, {"db", White}, {"cc", White}, {"cb", Black}} {
+		x, y, _, _ := ParsePoint(s.p, 5)
+		g.Setup(x, y, s.c)
+	}
+
+	// White captures the black stone at cb by playing bb.
+	if err := g.Play(1, 1, White); err != nil {
+		t.Fatalf("capture failed: %v", err)
+	}
+	if g.Captures(White) != 1 || g.Board.Get(2, 1) != Empty {
+		t.Fatalf("expected white to capture cb")
+	}
+
+	// Black may not retake immediately.
+	err := g.Legal(2, 1, Black)
+	if !errors.Is(err, ErrKo) {
+		t.Fatalf("expected ErrKo, got %v", err)
+	}
+	var illegal *IllegalMoveError
+	if !errors.As(err, &illegal) || illegal.X != 2 || illegal.Y != 1 {
+		t.Errorf("expected *IllegalMoveError with position, got %v", err)
+	}
+
+	// After a ko threat exchange the retake is legal.
+	play(t, g, "ee", "ed")
+	if err := g.Play(2, 1, Black); err != nil {
+		t.Errorf("retake after threat should be legal: %v", err)
+	}
+}
+
+func TestOccupiedAndSuicide(t *testing.T) {
+	g := NewGame(5, Japanese)
+	play(t, g, "ba", "ee", "ab")
+
+	if err := g.Legal(1, 0, White); !errors.Is(err, ErrOccupied) {
+		t.Errorf("expected ErrOccupied, got %v", err)
+	}
+	if err := g.Legal(0, 0, White); !errors.Is(err, ErrSuicide) {
+		t.Errorf("expected ErrSuicide, got %v", err)
+	}
+
+	// New Zealand rules allow suicide; the stone is removed and counted
+	// as a prisoner for the opponent.
+	g.Rules = NewZealand
+	if err := g.Play(0, 0, White); err != nil {
+		t.Fatalf("suicide should be legal under NZ rules: %v", err)
+	}
+	if g.Board.Get(0, 0) != Empty || g.Captures(Black) != 1 {
+		t.Errorf("suicided stone not removed or not counted")
+	}
+}
+
+func TestSuperko(t *testing.T) {
+	// Under positional superko a single-stone suicide recreates the
+	// previous position and is rejected.
+	g := NewGame(5, TrompTaylor)
+	play(t, g, "ba", "ee", "ab")
+	if err := g.Legal(0, 0, White); !errors.Is(err, ErrSuperko) {
+		t.Errorf("expected ErrSuperko, got %v", err)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	g := NewGame(5, Japanese)
+	play(t, g, "ba", "aa", "ab")
+	if g.Captures(Black) != 1 || g.Board.Get(0, 0) != Empty {
+		t.Fatalf("expected black to capture aa")
+	}
+
+	if !g.Undo() {
+		t.Fatal("undo failed")
+	}
+	if g.Captures(Black) != 0 || g.Board.Get(0, 0) != White || g.Board.Get(0, 1) != Empty {
+		t.Errorf("undo did not restore the position")
+	}
+	if len(g.Moves()) != 2 {
+		t.Errorf("expected 2 moves after undo, got %d", len(g.Moves()))
+	}
+
+	g.Undo()
+	g.Undo()
+	if g.Undo() {
+		t.Error("undo on an empty history should return false")
+	}
+}
+
+func TestRulesetFromSGF(t *testing.T) {
+	cases := map[string]string{
+		"japanese":     "Japanese",
+		"Chinese":      "Chinese",
+		"AGA":          "AGA",
+		"NZ":           "NZ",
+		"Tromp-Taylor": "Tromp-Taylor",
+	}
+	for ru, expected := range cases {
+		if r, ok := RulesetFromSGF(ru); !ok || r.Name != expected {
+			t.Errorf("RulesetFromSGF(%q) = %s, %v", ru, r.Name, ok)
+		}
+	}
+	if _, ok := RulesetFromSGF("unknown"); ok {
+		t.Error("unknown rules should not be recognised")
+	}
+}
+
+func TestFromSGFSample(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	roots, err := sgf.Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	g, err := FromSGF(roots[0], -1)
+	if err != nil {
+		t.Fatalf("FromSGF error: %v", err)
+	}
+	if g.Rules.Name != "Japanese" || len(g.Moves()) != len(sgf.MainLine(roots[0])) {
+		t.Errorf("unexpected replay: rules %s, %d moves", g.Rules.Name, len(g.Moves()))
+	}
+
+	g, err = FromSGF(roots[0], 3)
+	if err != nil || len(g.Moves()) != 3 {
+		t.Errorf("expected 3 moves, got %d (%v)", len(g.Moves()), err)
+	}
+}
+
+func TestBoardFromSGF(t *testing.T) {
+	// White's suicide at aa is legal under New Zealand rules, but the record
+	// has no RU and so reads as Japanese.
+	roots, err := sgf.Parse("(;SZ[5];B[ba];W[ee];B[ab];W[aa];B[cc])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if _, err := FromSGF(roots[0], -1); !errors.Is(err, ErrSuicide) {
+		t.Errorf("FromSGF error = %v, want suicide", err)
+	}
+
+	b, err := BoardFromSGF(roots[0], -1)
+	if err != nil {
+		t.Fatalf("BoardFromSGF error: %v", err)
+	}
+	if b.Get(0, 0) != Empty || b.Get(1, 0) != Black || b.Get(0, 1) != Black || b.Get(2, 2) != Black {
+		t.Errorf("unexpected board after the suicide")
+	}
+	if b, err := BoardFromSGF(roots[0], 1); err != nil || b.Get(4, 4) != Empty {
+		t.Errorf("expected one move, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// BoardSize reads the SZ property of a root node. Rectangular boards are not
+// supported; the first dimension is used.
+func BoardSize(root *sgf.Node) (int, error) {
+	sz := root.Get("SZ")
+	if sz == "" {
+		return 19, nil
+	}
+	sz, _, _ = strings.Cut(sz, ":")
+	size, err := strconv.Atoi(strings.TrimSpace(sz))
+	if err != nil || size < 1 || size > 52 {
+		return 0, fmt.Errorf("invalid board size %q", root.Get("SZ"))
+	}
+	return size, nil
+}
+
+// FromSGF replays the main line of a game tree with the rules from its RU
+// property. It stops after moveNumber moves, or plays the whole line if
+// moveNumber is negative. Illegal moves are reported with their move number.
+func FromSGF(root *sgf.Node, moveNumber int) (*Game, error) {
+	size, err := BoardSize(root)
+	if err != nil {
+		return nil, err
+	}
+	rules, _ := RulesetFromSGF(root.Get("RU"))
+	g := NewGame(size, rules)
+
+	setup := func(node *sgf.Node) error {
+		changed, err := applySetup(g.Board, node)
+		if changed {
+			g.setupDone()
+		}
+		return err
+	}
+	play := func(x, y int, pass bool, c StoneColor) error {
+		if pass {
+			g.Pass(c)
+			return nil
+		}
+		return g.Play(x, y, c)
+	}
+	if err := replay(root, size, moveNumber, setup, play); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// BoardFromSGF replays the main line like FromSGF but without enforcing any
+// rules: stones are placed and captures resolved as recorded. It is for
+// showing records, which may break their rule set or lack RU, rather than
+// checking them.
+func BoardFromSGF(root *sgf.Node, moveNumber int) (*Board, error) {
+	size, err := BoardSize(root)
+	if err != nil {
+		return nil, err
+	}
+	b := NewBoard(size)
+
+	setup := func(node *sgf.Node) error {
+		_, err := applySetup(b, node)
+		return err
+	}
+	play := func(x, y int, pass bool, c StoneColor) error {
+		if !pass {
+			b.Play(x, y, c)
+		}
+		return nil
+	}
+	if err := replay(root, size, moveNumber, setup, play); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// replay walks the main line of a game tree, passing each node to setup and
+// each move to play, until moveNumber moves have been played (all of them
+// if moveNumber is negative). Errors are reported with their move number.
+func replay(root *sgf.Node, size, moveNumber int, setup func(*sgf.Node) error, play func(x, y int, pass bool, c StoneColor) error) error {
+	played := 0
+	for node := root; ; node = node.Children[0] {
+		if err := setup(node); err != nil {
+			return fmt.Errorf("move %d: %w", played, err)
+		}
+
+		if color := sgf.MoveColor(node); color != "" && (moveNumber < 0 || played < moveNumber) {
+			c := Black
+			if color == "W" {
+				c = White
+			}
+			x, y, pass, err := ParsePoint(node.Get(color), size)
+			if err != nil {
+				return fmt.Errorf("move %d: %w", played+1, err)
+			}
+			if err := play(x, y, pass, c); err != nil {
+				return fmt.Errorf("move %d: %w", played+1, err)
+			}
+			played++
+		}
+
+		if len(node.Children) == 0 || (moveNumber >= 0 && played >= moveNumber) {
+			return nil
+		}
+	}
+}
+
+// applySetup applies the AE, AB and AW properties of a node to a board and
+// reports whether it had any.
+func applySetup(b *Board, node *sgf.Node) (bool, error) {
+	setup := []struct {
+		key   string
+		color StoneColor
+	}{{"AE", Empty}, {"AB", Black}, {"AW", White}}
+
+	changed := false
+	for _, s := range setup {
+		points, err := ExpandPoints(node.Properties[s.key], b.Size)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", s.key, err)
+		}
+		for _, p := range points {
+			b.Set(p[0], p[1], s.color)
+			changed = true
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,74 @@
+package game
+
+import "strings"
+
+// Superko selects how whole-board repetition is treated.
+type Superko int
+
+const (
+	// NoSuperko only forbids the immediate recapture of a simple ko.
+	NoSuperko Superko = iota
+	// PositionalSuperko forbids recreating any earlier board position.
+	PositionalSuperko
+	// SituationalSuperko forbids recreating an earlier position with the
+	// same player to move.
+	SituationalSuperko
+)
+
+// Scoring selects how a finished game is counted.
+type Scoring int
+
+const (
+	// TerritoryScoring counts surrounded empty points plus prisoners.
+	TerritoryScoring Scoring = iota
+	// AreaScoring counts surrounded empty points plus stones on the board.
+	AreaScoring
+)
+
+func (s Scoring) String() string {
+	if s == AreaScoring {
+		return "area"
+	}
+	return "territory"
+}
+
+// Ruleset describes the parts of a rule set that affect move legality and
+// counting.
+type Ruleset struct {
+	Name           string
+	SuicideAllowed bool
+	Superko        Superko
+	Scoring        Scoring
+	// Komi is the customary komi, used when the game record has none.
+	Komi float64
+}
+
+var (
+	Japanese    = Ruleset{Name: "Japanese", Superko: NoSuperko, Scoring: TerritoryScoring, Komi: 6.5}
+	Chinese     = Ruleset{Name: "Chinese", Superko: PositionalSuperko, Scoring: AreaScoring, Komi: 7.5}
+	AGA         = Ruleset{Name: "AGA", Superko: SituationalSuperko, Scoring: AreaScoring, Komi: 7.5}
+	NewZealand  = Ruleset{Name: "NZ", SuicideAllowed: true, Superko: SituationalSuperko, Scoring: AreaScoring, Komi: 7}
+	TrompTaylor = Ruleset{Name: "Tromp-Taylor", SuicideAllowed: true, Superko: PositionalSuperko, Scoring: AreaScoring, Komi: 7.5}
+)
+
+// RulesetFromSGF maps the value of the SGF RU property to a rule set. The
+// second return value is false if the value was not recognised, in which
+// case Japanese rules are returned.
+func RulesetFromSGF(ru string) (Ruleset, bool) {
+	norm := strings.ToLower(ru)
+	norm = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(norm)
+
+	switch norm {
+	case "japanese", "jp", "jpn", "korean":
+		return Japanese, true
+	case "chinese", "cn", "chn":
+		return Chinese, true
+	case "aga", "bga", "french":
+		return AGA, true
+	case "nz", "newzealand":
+		return NewZealand, true
+	case "tromptaylor", "tt":
+		return TrompTaylor, true
+	}
+	return Japanese, false
+}
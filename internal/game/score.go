@@ -0,0 +1,291 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// PlayerScore is one player's part of a count.
+type PlayerScore struct {
+	// Territory is the number of empty points (and points of removed dead
+	// stones) surrounded by the player.
+	Territory int `json:"territory"`
+	// Stones is the number of the player's living stones on the board. It
+	// only counts towards the total under area scoring.
+	Stones int `json:"stones"`
+	// Captures is the number of prisoners taken during the game plus the
+	// opponent's dead stones. It only counts under territory scoring.
+	Captures int     `json:"captures"`
+	Komi     float64 `json:"komi"`
+	Total    float64 `json:"total"`
+}
+
+// Score is the count of a finished position.
+type Score struct {
+	Scoring string      `json:"scoring"`
+	Black   PlayerScore `json:"black"`
+	White   PlayerScore `json:"white"`
+	// Dame are the neutral points, including those in seki.
+	Dame [][2]int `json:"dame"`
+	// Seki are the stones judged to be alive in seki.
+	Seki [][2]int `json:"seki"`
+	Dead [][2]int `json:"dead"`
+	// KomiAssumed is set when the record gives no valid komi and the
+	// usual komi of its rule set was counted instead.
+	KomiAssumed bool `json:"komiAssumed,omitempty"`
+}
+
+// Winner returns Black or White, or Empty for a draw.
+func (s Score) Winner() StoneColor {
+	switch {
+	case s.Black.Total > s.White.Total:
+		return Black
+	case s.White.Total > s.Black.Total:
+		return White
+	}
+	return Empty
+}
+
+// Result formats the score like the SGF RE property, e.g. "B+0.5".
+func (s Score) Result() string {
+	w := s.Winner()
+	if w == Empty {
+		return "0"
+	}
+	margin := s.Black.Total - s.White.Total
+	if w == White {
+		margin = -margin
+	}
+	return fmt.Sprintf("%s+%s", w, strconv.FormatFloat(margin, 'f', -1, 64))
+}
+
+// Score counts the current position with the game's rule set. Stones at the
+// dead points are removed before counting.
+func (g *Game) Score(komi float64, dead [][2]int) Score {
+	b := g.Board.Clone()
+	s := Score{Scoring: g.Rules.Scoring.String()}
+	s.Black.Captures = g.captures[Black]
+	s.White.Captures = g.captures[White]
+	s.White.Komi = komi
+
+	for _, p := range dead {
+		c := b.Get(p[0], p[1])
+		if c == Empty {
+			continue
+		}
+		b.Set(p[0], p[1], Empty)
+		s.Dead = append(s.Dead, p)
+		if c == Black {
+			s.White.Captures++
+		} else {
+			s.Black.Captures++
+		}
+	}
+
+	seki := findSeki(b)
+	for x := 0; x < b.Size; x++ {
+		for y := 0; y < b.Size; y++ {
+			switch b.Grid[x][y] {
+			case Black:
+				s.Black.Stones++
+			case White:
+				s.White.Stones++
+			}
+			if seki[[2]int{x, y}] {
+				s.Seki = append(s.Seki, [2]int{x, y})
+			}
+		}
+	}
+
+	for _, r := range emptyRegions(b) {
+		owner := r.owner()
+		// Under territory scoring the eyes of groups in seki are not
+		// territory.
+		if owner != Empty && g.Rules.Scoring == TerritoryScoring && r.touches(seki) {
+			owner = Empty
+		}
+		switch owner {
+		case Black:
+			s.Black.Territory += len(r.points)
+		case White:
+			s.White.Territory += len(r.points)
+		default:
+			s.Dame = append(s.Dame, r.points...)
+		}
+	}
+
+	total := func(p PlayerScore) float64 {
+		if g.Rules.Scoring == AreaScoring {
+			return float64(p.Territory+p.Stones) + p.Komi
+		}
+		return float64(p.Territory+p.Captures) + p.Komi
+	}
+	s.Black.Total = total(s.Black)
+	s.White.Total = total(s.White)
+	return s
+}
+
+// region is a connected set of empty points and the stones bordering it.
+type region struct {
+	points  [][2]int
+	borders map[StoneColor]bool
+	stones  [][2]int
+}
+
+func (r region) owner() StoneColor {
+	if r.borders[Black] && !r.borders[White] {
+		return Black
+	}
+	if r.borders[White] && !r.borders[Black] {
+		return White
+	}
+	return Empty
+}
+
+func (r region) touches(points map[[2]int]bool) bool {
+	for _, p := range r.stones {
+		if points[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func emptyRegions(b *Board) []region {
+	seen := make(map[[2]int]bool)
+	var regions []region
+	for x := 0; x < b.Size; x++ {
+		for y := 0; y < b.Size; y++ {
+			start := [2]int{x, y}
+			if b.Grid[x][y] != Empty || seen[start] {
+				continue
+			}
+			r := region{borders: make(map[StoneColor]bool)}
+			queue := [][2]int{start}
+			seen[start] = true
+			for len(queue) > 0 {
+				p := queue[0]
+				queue = queue[1:]
+				r.points = append(r.points, p)
+				for _, n := range neighbors(p[0], p[1]) {
+					if !b.OnBoard(n[0], n[1]) || seen[n] {
+						continue
+					}
+					if c := b.Grid[n[0]][n[1]]; c != Empty {
+						r.borders[c] = true
+						r.stones = append(r.stones, n)
+						continue
+					}
+					seen[n] = true
+					queue = append(queue, n)
+				}
+			}
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// findSeki returns the stones of groups in seki. A neutral region is taken
+// to be seki when neither player can fill any of its points without putting
+// their own stones in atari; the groups around it are then in seki.
+func findSeki(b *Board) map[[2]int]bool {
+	seki := make(map[[2]int]bool)
+	for _, r := range emptyRegions(b) {
+		if r.owner() != Empty || !r.borders[Black] || !r.borders[White] {
+			continue
+		}
+		fillable := false
+		for _, p := range r.points {
+			for _, c := range []StoneColor{Black, White} {
+				nb := b.Clone()
+				captured, suicide := nb.Play(p[0], p[1], c)
+				if len(suicide) > 0 {
+					continue
+				}
+				if _, libs := nb.getGroupAndLiberties(p[0], p[1]); libs > 1 || len(captured) > 0 {
+					fillable = true
+				}
+			}
+		}
+		if fillable {
+			continue
+		}
+		for _, st := range r.stones {
+			group, _ := b.getGroupAndLiberties(st[0], st[1])
+			for _, p := range group {
+				seki[p] = true
+			}
+		}
+	}
+	return seki
+}
+
+// KomiFromSGF reads the KM property. The second return value is false if the
+// property is missing or invalid.
+func KomiFromSGF(root *sgf.Node) (float64, bool) {
+	km := strings.TrimSpace(root.Get("KM"))
+	if km == "" {
+		return 0, false
+	}
+	komi, err := strconv.ParseFloat(km, 64)
+	if err != nil {
+		return 0, false
+	}
+	return komi, true
+}
+
+// DeadFromMarkup derives dead stones from TB/TW territory markup: a white
+// stone inside Black's territory is dead, and vice versa.
+func DeadFromMarkup(node *sgf.Node, b *Board) ([][2]int, error) {
+	var dead [][2]int
+	markup := []struct {
+		key   string
+		owner StoneColor
+	}{{"TB", Black}, {"TW", White}}
+
+	for _, m := range markup {
+		points, err := ExpandPoints(node.Properties[m.key], b.Size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.key, err)
+		}
+		for _, p := range points {
+			if b.Get(p[0], p[1]) == m.owner.Opponent() {
+				dead = append(dead, p)
+			}
+		}
+	}
+	return dead, nil
+}
+
+// ScoreSGF replays the main line of a game and counts the final position
+// with its rule set and komi. If dead is nil, dead stones are taken from the
+// TB/TW markup of the last node. An illegal move fails the count, with the
+// move number in the error.
+func ScoreSGF(root *sgf.Node, dead [][2]int) (Score, error) {
+	g, err := FromSGF(root, -1)
+	if err != nil {
+		return Score{}, err
+	}
+	komi, ok := KomiFromSGF(root)
+	if !ok {
+		komi = g.Rules.Komi
+	}
+
+	if dead == nil {
+		last := root
+		for len(last.Children) > 0 {
+			last = last.Children[0]
+		}
+		if dead, err = DeadFromMarkup(last, g.Board); err != nil {
+			return Score{}, err
+		}
+	}
+
+	score := g.Score(komi, dead)
+	score.KomiAssumed = !ok
+	return score, nil
+}
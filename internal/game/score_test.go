@@ -0,0 +1,108 @@
+package game
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// setup fills a board from rows of 'X' (Black), 'O' (White) and '.'.
+func setup(t *testing.T, rules Ruleset, rows ...string) *Game {
+	t.Helper()
+	g := NewGame(len(rows), rules)
+	for y, row := range rows {
+		for x, ch := range row {
+			switch ch {
+			case 'X':
+				g.Setup(x, y, Black)
+			case 'O':
+				g.Setup(x, y, White)
+			}
+		}
+	}
+	return g
+}
+
+func TestScoreSample(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	roots, err := sgf.Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	score, err := ScoreSGF(roots[0], nil)
+	if err != nil {
+		t.Fatalf("ScoreSGF error: %v", err)
+	}
+	if score.Result() != roots[0].Get("RE") {
+		t.Errorf("Expected %s, got %s (%+v)", roots[0].Get("RE"), score.Result(), score)
+	}
+	if score.White.Komi != 6.5 || score.KomiAssumed || score.Scoring != "territory" {
+		t.Errorf("Expected komi 6.5 and territory scoring, got %v %s", score.White.Komi, score.Scoring)
+	}
+}
+
+func TestScoreDeadStones(t *testing.T) {
+	rows := []string{
+		"..X..",
+		".OX..",
+		"..XOO",
+		"XXXO.",
+		"...O.",
+	}
+
+	g := setup(t, Japanese, rows...)
+	score := g.Score(0.5, [][2]int{{1, 1}})
+	// Black: 5 empty points plus the dead stone's point, and one prisoner.
+	// The open top-right and bottom-left areas are dame.
+	if score.Black.Territory != 6 || score.Black.Captures != 1 || score.White.Territory != 2 || len(score.Dame) != 7 {
+		t.Errorf("Unexpected territory score: %+v", score)
+	}
+	if score.Result() != "B+4.5" {
+		t.Errorf("Expected B+4.5, got %s", score.Result())
+	}
+
+	g = setup(t, Chinese, rows...)
+	score = g.Score(7.5, [][2]int{{1, 1}})
+	// Area: 6 + 6 stones for Black, 2 + 4 stones + 7.5 for White.
+	if score.Black.Total != 12 || score.White.Total != 13.5 {
+		t.Errorf("Unexpected area score: %+v", score)
+	}
+}
+
+func TestScoreSeki(t *testing.T) {
+	g := setup(t, Japanese,
+		".XX",
+		"O.X",
+		"OOX",
+	)
+	score := g.Score(0, nil)
+	if len(score.Seki) != 7 || len(score.Dame) != 2 {
+		t.Errorf("Expected all stones in seki and 2 dame, got %d and %d", len(score.Seki), len(score.Dame))
+	}
+	if score.Black.Territory != 0 || score.White.Territory != 0 {
+		t.Errorf("Seki points should not be territory: %+v", score)
+	}
+}
+
+func TestDeadFromMarkup(t *testing.T) {
+	roots, err := sgf.Parse("(;SZ[5]AB[ca][cb][cc][ad][bd][cd]AW[bb][dc][ec][dd][de]TB[aa:bc]TW[ed])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	score, err := ScoreSGF(roots[0], nil)
+	if err != nil {
+		t.Fatalf("ScoreSGF error: %v", err)
+	}
+	if len(score.Dead) != 1 || score.Dead[0] != [2]int{1, 1} {
+		t.Errorf("Expected bb to be dead, got %v", score.Dead)
+	}
+	// Without KM the count says it used the rule set's komi.
+	if !score.KomiAssumed || score.White.Komi != Japanese.Komi {
+		t.Errorf("Expected the assumed komi %v, got %v (assumed %v)", Japanese.Komi, score.White.Komi, score.KomiAssumed)
+	}
+}
@@ -5,21 +5,15 @@ import (
 	"encoding/base64"
 	"fmt"
 	"image/color"
-	"strconv"
 
 	"github.com/fogleman/gg"
 	"github.com/sweetfish329/sai/internal/game"
 	"github.com/sweetfish329/sai/internal/sgf"
 )
 
-// SgfCoordToNum converts "aa" -> 0, etc.
-func SgfCoordToNum(c byte) int {
-	if c >= 'a' && c <= 'z' {
-		return int(c - 'a')
-	}
-	return -1
-}
-
+// GenerateBoardImage draws the main line of a game after moveNumber moves,
+// or the final position if moveNumber is negative, as a PNG data URL. The
+// record is drawn as played, even where it breaks its rules.
 func GenerateBoardImage(sgfContent string, moveNumber int) (string, error) {
 	roots, err := sgf.Parse(sgfContent)
 	if err != nil {
@@ -30,73 +24,17 @@ func GenerateBoardImage(sgfContent string, moveNumber int) (string, error) {
 	}
 	root := roots[0]
 
-	szStr := root.Get("SZ")
-	size := 19
-	if szStr != "" {
-		if s, err := strconv.Atoi(szStr); err == nil {
-			size = s
-		}
-	}
-
-	board := game.NewBoard(size)
-
-	// Setup AB/AW
-	// AB/AW usually contains list of points.
-	// parser returns []string.
-	// Each string could be "aa" or "ab" etc.
-	if ab, ok := root.Properties["AB"]; ok {
-		for _, coords := range ab {
-			// coords might be "aa", "ab"...
-			// Actually my parser returns values as string inside list.
-			// property AB[aa][ab] -> Properties["AB"] = ["aa", "ab"]
-			if len(coords) >= 2 {
-				x := SgfCoordToNum(coords[0])
-				y := SgfCoordToNum(coords[1])
-				board.Play(x, y, game.Black)
-			}
-		}
-	}
-	if aw, ok := root.Properties["AW"]; ok {
-		for _, coords := range aw {
-			if len(coords) >= 2 {
-				x := SgfCoordToNum(coords[0])
-				y := SgfCoordToNum(coords[1])
-				board.Play(x, y, game.White)
-			}
-		}
-	}
-
-	// Extract all moves
-	type manualMove struct {
-		x, y  int
-		color game.StoneColor
-	}
-	var moves []manualMove
-
-	// Standard SGF: Moves are in children nodes recursively.
-	// Sequence: Root -> Node1 -> Node2 ...
-	curr := root
-	for len(curr.Children) > 0 {
-		curr = curr.Children[0]
-		if b := curr.Get("B"); b != "" {
-			if len(b) >= 2 {
-				moves = append(moves, manualMove{SgfCoordToNum(b[0]), SgfCoordToNum(b[1]), game.Black})
-			}
-		} else if w := curr.Get("W"); w != "" {
-			if len(w) >= 2 {
-				moves = append(moves, manualMove{SgfCoordToNum(w[0]), SgfCoordToNum(w[1]), game.White})
-			}
-		}
-	}
-
-	limit := len(moves)
-	if moveNumber >= 0 && moveNumber < limit {
-		limit = moveNumber
+	board, err := game.BoardFromSGF(root, moveNumber)
+	if err != nil {
+		return "", err
 	}
+	return RenderBoard(board, nil)
+}
 
-	for i := 0; i < limit; i++ {
-		board.Play(moves[i].x, moves[i].y, moves[i].color)
-	}
+// RenderBoard draws a board as a PNG data URL. Labels, such as the move
+// numbers of a variation, are written on the stones or points they name.
+func RenderBoard(board *game.Board, labels map[[2]int]string) (string, error) {
+	size := board.Size
 
 	// Draw
 	cellSize := 40.0
@@ -141,6 +79,24 @@ func GenerateBoardImage(sgfContent string, moveNumber int) (string, error) {
 		}
 	}
 
+	for p, label := range labels {
+		cx := padding + float64(p[0])*cellSize
+		cy := padding + float64(p[1])*cellSize
+		switch board.Get(p[0], p[1]) {
+		case game.Black:
+			dc.SetColor(color.White)
+		case game.White:
+			dc.SetColor(color.Black)
+		default:
+			// Clear the grid under labels on empty points.
+			dc.SetColor(color.RGBA{0xdc, 0xb3, 0x5c, 0xff})
+			dc.DrawCircle(cx, cy, radius/2)
+			dc.Fill()
+			dc.SetColor(color.Black)
+		}
+		dc.DrawStringAnchored(label, cx, cy, 0.5, 0.35)
+	}
+
 	var buf bytes.Buffer
 	if err := dc.EncodePNG(&buf); err != nil {
 		return "", err
@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// wait polls a job until it has finished.
+func wait(t *testing.T, q *Queue, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := q.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.Finished() {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	q := NewQueue(NewMemoryStore(time.Hour), 2, 10)
+	defer q.Close()
+	ctx := context.Background()
+
+	ok, err := q.Submit(ctx, "alice", "test", func(ctx context.Context) (interface{}, error) {
+		return map[string]string{"result": "B+R"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok.Status != StatusQueued || len(ok.ID) != 32 || ok.Owner != "alice" {
+		t.Errorf("submitted job = %+v", ok)
+	}
+	failed, _ := q.Submit(ctx, "alice", "test", func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("engine crashed")
+	})
+	panicked, _ := q.Submit(ctx, "alice", "test", func(ctx context.Context) (interface{}, error) {
+		panic("boom")
+	})
+
+	if job := wait(t, q, ok.ID); job.Status != StatusDone || string(job.Result) != `{"result":"B+R"}` || job.StartedAt == nil {
+		t.Errorf("done job = %+v", job)
+	}
+	if job := wait(t, q, failed.ID); job.Status != StatusFailed || job.Error != "engine crashed" {
+		t.Errorf("failed job = %+v", job)
+	}
+	if job := wait(t, q, panicked.ID); job.Status != StatusFailed {
+		t.Errorf("panicked job = %+v", job)
+	}
+
+	if _, err := q.Get(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(unknown) err = %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	q := NewQueue(NewMemoryStore(0), 1, 10)
+	defer q.Close()
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	running, _ := q.Submit(ctx, "alice", "test", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	ran := false
+	queued, _ := q.Submit(ctx, "alice", "test", func(ctx context.Context) (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+	<-started
+
+	job, err := q.Cancel(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusCancelled {
+		t.Errorf("queued job after Cancel = %+v", job)
+	}
+
+	if _, err := q.Cancel(ctx, running.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job := wait(t, q, running.ID); job.Status != StatusCancelled {
+		t.Errorf("running job after Cancel = %+v", job)
+	}
+
+	// The cancelled job must be skipped, not run, by the worker.
+	last, _ := q.Submit(ctx, "alice", "test", func(ctx context.Context) (interface{}, error) { return nil, nil })
+	wait(t, q, last.ID)
+	if ran {
+		t.Error("cancelled queued job ran")
+	}
+	if job, _ := q.Get(ctx, queued.ID); job.Status != StatusCancelled {
+		t.Errorf("queued job = %+v", job)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := NewQueue(NewMemoryStore(0), 1, 1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	q.Submit(context.Background(), "alice", "test", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-block
+		return nil, nil
+	})
+	<-started
+	waiting, err := q.Submit(context.Background(), "alice", "test", func(ctx context.Context) (interface{}, error) { return nil, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Submit(context.Background(), "alice", "test", nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("err = %v, want ErrQueueFull", err)
+	}
+
+	close(block)
+	q.Close()
+	if job, _ := q.Get(context.Background(), waiting.ID); job.Status != StatusCancelled && job.Status != StatusDone {
+		t.Errorf("job left %s after Close", job.Status)
+	}
+	if _, err := q.Submit(context.Background(), "alice", "test", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("err = %v, want ErrClosed", err)
+	}
+}
+
+func TestMemoryStoreRetention(t *testing.T) {
+	s := NewMemoryStore(time.Minute)
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour)
+	s.Create(ctx, &Job{ID: "old", Status: StatusDone, FinishedAt: &old})
+	s.Create(ctx, &Job{ID: "queued", Status: StatusQueued, CreatedAt: old})
+	s.Create(ctx, &Job{ID: "new", Status: StatusQueued})
+
+	if _, err := s.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired job still stored: %v", err)
+	}
+	if _, err := s.Get(ctx, "queued"); err != nil {
+		t.Errorf("unfinished job dropped: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Submit when the queue is at capacity.
+var ErrQueueFull = errors.New("jobs: queue is full")
+
+// ErrClosed is returned by Submit after Close.
+var ErrClosed = errors.New("jobs: queue is closed")
+
+// Func is the work of a job. Its result is stored as JSON. It must return
+// promptly once ctx is cancelled.
+type Func func(ctx context.Context) (interface{}, error)
+
+type task struct {
+	id string
+	fn Func
+}
+
+// Queue runs jobs on a fixed number of workers.
+type Queue struct {
+	store Store
+	tasks chan task
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	running map[string]context.CancelFunc
+	// cancelled holds queued jobs cancelled before a worker took them.
+	cancelled map[string]bool
+}
+
+// NewQueue starts workers goroutines taking jobs from a queue of the given
+// capacity.
+func NewQueue(store Store, workers, capacity int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		store:     store,
+		tasks:     make(chan task, capacity),
+		ctx:       ctx,
+		cancel:    cancel,
+		running:   make(map[string]context.CancelFunc),
+		cancelled: make(map[string]bool),
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues fn for owner and returns its job record.
+func (q *Queue) Submit(ctx context.Context, owner, kind string, fn Func) (*Job, error) {
+	job := &Job{
+		ID:        newID(),
+		Owner:     owner,
+		Kind:      kind,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+	if len(q.tasks) == cap(q.tasks) {
+		return nil, ErrQueueFull
+	}
+	if err := q.store.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	// Cannot block: only Submit sends, under q.mu, and there is room.
+	q.tasks <- task{id: job.ID, fn: fn}
+	return job, nil
+}
+
+// Get returns the current record of a job.
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	return q.store.Get(ctx, id)
+}
+
+// Cancel stops a job. A queued job is cancelled at once; a running job has
+// its context cancelled and is marked cancelled when it returns. Cancelling
+// a finished job does nothing.
+func (q *Queue) Cancel(ctx context.Context, id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case StatusQueued:
+		q.cancelled[id] = true
+		finish(job, StatusCancelled, nil, context.Canceled)
+		if err := q.store.Update(ctx, job); err != nil {
+			return nil, err
+		}
+	case StatusRunning:
+		if cancel, ok := q.running[id]; ok {
+			cancel()
+		}
+	}
+	return job, nil
+}
+
+// Close cancels running jobs, waits for the workers to stop and marks jobs
+// still queued as cancelled.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.cancel()
+	close(q.tasks)
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for t := range q.tasks {
+		q.run(t)
+	}
+}
+
+func (q *Queue) run(t task) {
+	// Records are updated with a fresh context: the work context may be
+	// cancelled, but its outcome still has to be stored.
+	bg := context.Background()
+
+	q.mu.Lock()
+	if q.cancelled[t.id] {
+		delete(q.cancelled, t.id)
+		q.mu.Unlock()
+		return
+	}
+	job, err := q.store.Get(bg, t.id)
+	if err != nil {
+		q.mu.Unlock()
+		log.Printf("jobs: lost job %s: %v", t.id, err)
+		return
+	}
+	if q.ctx.Err() != nil {
+		// Closing: drain without running.
+		finish(job, StatusCancelled, nil, q.ctx.Err())
+		q.update(bg, job)
+		q.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	q.running[t.id] = cancel
+	now := time.Now()
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	q.update(bg, job)
+	q.mu.Unlock()
+
+	result, err := q.call(ctx, t.fn)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.running, t.id)
+	switch {
+	case err == nil:
+		finish(job, StatusDone, result, nil)
+	case ctx.Err() != nil:
+		finish(job, StatusCancelled, nil, ctx.Err())
+	default:
+		finish(job, StatusFailed, nil, err)
+	}
+	q.update(bg, job)
+}
+
+// call runs fn, turning a panic into a failure of its job.
+func (q *Queue) call(ctx context.Context, fn Func) (result json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("jobs: job panicked")
+			log.Printf("jobs: panic: %v", r)
+		}
+	}()
+	v, err := fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (q *Queue) update(ctx context.Context, job *Job) {
+	if err := q.store.Update(ctx, job); err != nil {
+		log.Printf("jobs: failed to update job %s: %v", job.ID, err)
+	}
+}
+
+func finish(job *Job, status Status, result json.RawMessage, err error) {
+	now := time.Now()
+	job.Status = status
+	job.Result = result
+	job.FinishedAt = &now
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,319 @@
+// Package katrain decodes the engine analysis that KaTrain embeds in SGF
+// files as KT[] properties.
+//
+// KaTrain stores three values per analysed node, each gzip-compressed and
+// base64-encoded: the ownership map and the policy as little-endian float16
+// arrays, and KataGo's JSON analysis response. Winrates and score leads are
+// reported from Black's point of view.
+package katrain
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/sweetfish329/sai/internal/game"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// Analysis is KaTrain's analysis of the position after a node's move.
+type Analysis struct {
+	// Size is the board size inferred from the policy array, or 0 if the
+	// node carries no policy.
+	Size int
+	Root RootInfo
+	// Moves are the engine's candidate moves for the player to move,
+	// best first.
+	Moves     []CandidateMove
+	Ownership []float64
+	// Policy holds Size*Size priors in row-major order from the top-left
+	// corner, followed by the prior for passing.
+	Policy    []float64
+	Completed bool
+}
+
+// RootInfo is the evaluation of the position itself.
+type RootInfo struct {
+	CurrentPlayer string   `json:"currentPlayer"`
+	Winrate       float64  `json:"winrate"`
+	ScoreLead     float64  `json:"scoreLead"`
+	ScoreStdev    float64  `json:"scoreStdev"`
+	Visits        int      `json:"visits"`
+	PV            []string `json:"pv"`
+}
+
+// CandidateMove is one move the engine considered. Moves and PVs use GTP
+// coordinates such as "D4".
+type CandidateMove struct {
+	Move      string   `json:"move"`
+	Order     int      `json:"order"`
+	Visits    int      `json:"visits"`
+	Winrate   float64  `json:"winrate"`
+	ScoreLead float64  `json:"scoreLead"`
+	Prior     float64  `json:"prior"`
+	LCB       float64  `json:"lcb"`
+	PV        []string `json:"pv"`
+}
+
+type mainData struct {
+	Root      RootInfo                 `json:"root"`
+	Moves     map[string]CandidateMove `json:"moves"`
+	Completed bool                     `json:"completed"`
+}
+
+// Decode decodes the values of a KT property.
+func Decode(values []string) (*Analysis, error) {
+	if len(values) < 3 {
+		return nil, fmt.Errorf("katrain: expected 3 KT values, got %d", len(values))
+	}
+
+	raw := make([][]byte, 3)
+	for i := range raw {
+		b, err := unpack(values[i])
+		if err != nil {
+			return nil, fmt.Errorf("katrain: value %d: %w", i, err)
+		}
+		raw[i] = b
+	}
+
+	var main mainData
+	if err := json.Unmarshal(raw[2], &main); err != nil {
+		return nil, fmt.Errorf("katrain: invalid analysis json: %w", err)
+	}
+
+	a := &Analysis{
+		Root:      main.Root,
+		Ownership: float16s(raw[0]),
+		Policy:    float16s(raw[1]),
+		Completed: main.Completed,
+	}
+	if n := len(a.Policy) - 1; n > 0 {
+		a.Size = int(math.Sqrt(float64(n)))
+	}
+	for _, m := range main.Moves {
+		a.Moves = append(a.Moves, m)
+	}
+	sort.Slice(a.Moves, func(i, j int) bool { return a.Moves[i].Order < a.Moves[j].Order })
+
+	return a, nil
+}
+
+// DecodeNode decodes the KT property of node. It returns nil, nil when the
+// node has no analysis.
+func DecodeNode(node *sgf.Node) (*Analysis, error) {
+	values, ok := node.Properties["KT"]
+	if !ok {
+		return nil, nil
+	}
+	return Decode(values)
+}
+
+// Prior returns the policy prior of an SGF coordinate ("" for pass), or -1
+// if it is not available.
+func (a *Analysis) Prior(move string) float64 {
+	idx := -1
+	switch {
+	case move == "" || move == "tt" && a.Size <= 19:
+		idx = a.Size * a.Size
+	case len(move) == 2:
+		x, y := int(move[0]-'a'), int(move[1]-'a')
+		if x >= 0 && x < a.Size && y >= 0 && y < a.Size {
+			idx = y*a.Size + x
+		}
+	}
+	if idx < 0 || idx >= len(a.Policy) {
+		return -1
+	}
+	return a.Policy[idx]
+}
+
+// MoveAnalysis pairs a main-line move with the analysis stored for the
+// positions before and after it.
+type MoveAnalysis struct {
+	MoveNumber int
+	Color      string
+	Move       string
+	// Before is the analysis of the position the move was played in; its
+	// candidate moves are what the engine would have preferred.
+	Before *Analysis
+	// After is the analysis stored on the move's own node.
+	After *Analysis
+}
+
+// DecodeError is a node of the main line whose analysis could not be
+// decoded.
+type DecodeError struct {
+	// MoveNumber is the move of the node, 0 for the root.
+	MoveNumber int
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	if e.MoveNumber == 0 {
+		return fmt.Sprintf("root: %v", e.Err)
+	}
+	return fmt.Sprintf("move %d: %v", e.MoveNumber, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// MainLine decodes the analysis of every move on the main line. A node
+// whose analysis cannot be decoded is treated as unanalysed and reported in
+// skipped, so one damaged node does not cost the rest of the game.
+func MainLine(root *sgf.Node) (moves []MoveAnalysis, skipped []*DecodeError) {
+	prev, err := DecodeNode(root)
+	if err != nil {
+		skipped = append(skipped, &DecodeError{MoveNumber: 0, Err: err})
+	}
+
+	for i, node := range sgf.MainLine(root) {
+		a, err := DecodeNode(node)
+		if err != nil {
+			skipped = append(skipped, &DecodeError{MoveNumber: i + 1, Err: err})
+		}
+		color := sgf.MoveColor(node)
+		moves = append(moves, MoveAnalysis{
+			MoveNumber: i + 1,
+			Color:      color,
+			Move:       node.Get(color),
+			Before:     prev,
+			After:      a,
+		})
+		prev = a
+	}
+	return moves, skipped
+}
+
+// GTPToSGF converts a GTP coordinate such as "D4" to an SGF coordinate on a
+// board of the given size. Pass and invalid vertices become "".
+func GTPToSGF(move string, size int) string {
+	x, y, pass, err := game.ParseGTP(move, size)
+	if pass || err != nil {
+		return ""
+	}
+	return game.PointString(x, y)
+}
+
+func unpack(value string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func float16s(b []byte) []float64 {
+	if len(b) == 0 {
+		return nil
+	}
+	out := make([]float64, len(b)/2)
+	for i := range out {
+		out[i] = halfToFloat(binary.LittleEndian.Uint16(b[2*i:]))
+	}
+	return out
+}
+
+// halfToFloat converts an IEEE 754 binary16 value.
+func halfToFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * frac * math.Pow(2, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * (1 + frac/1024) * math.Pow(2, float64(exp-15))
+}
+
+// MoveSummary is a compact view of a move's analysis for the AI coach.
+// Coordinates are SGF coordinates; winrate and score lead are for Black.
+type MoveSummary struct {
+	MoveNumber int     `json:"moveNumber"`
+	Color      string  `json:"color"`
+	Move       string  `json:"move"`
+	Winrate    float64 `json:"winrate"`
+	ScoreLead  float64 `json:"scoreLead"`
+	Visits     int     `json:"visits"`
+	// Policy is the prior the engine gave the played move.
+	Policy    float64     `json:"policy"`
+	BestMoves []Candidate `json:"bestMoves,omitempty"`
+}
+
+// Candidate is an engine suggestion for the position a move was played in.
+type Candidate struct {
+	Move      string   `json:"move"`
+	Winrate   float64  `json:"winrate"`
+	ScoreLead float64  `json:"scoreLead"`
+	Visits    int      `json:"visits"`
+	PV        []string `json:"pv,omitempty"`
+}
+
+// Summarize builds summaries for the analysed moves, keeping topN candidate
+// moves with principal variations of at most pvLen moves. Moves without
+// analysis, including those MainLine skipped, are left out.
+func Summarize(moves []MoveAnalysis, topN, pvLen int) []MoveSummary {
+	var result []MoveSummary
+	for _, m := range moves {
+		if m.After == nil {
+			continue
+		}
+		s := MoveSummary{
+			MoveNumber: m.MoveNumber,
+			Color:      m.Color,
+			Move:       m.Move,
+			Winrate:    round(m.After.Root.Winrate, 3),
+			ScoreLead:  round(m.After.Root.ScoreLead, 1),
+			Visits:     m.After.Root.Visits,
+			Policy:     -1,
+		}
+		if m.Before != nil {
+			s.Policy = round(m.Before.Prior(m.Move), 3)
+			size := m.Before.Size
+			for i, c := range m.Before.Moves {
+				if i >= topN {
+					break
+				}
+				cand := Candidate{
+					Move:      GTPToSGF(c.Move, size),
+					Winrate:   round(c.Winrate, 3),
+					ScoreLead: round(c.ScoreLead, 1),
+					Visits:    c.Visits,
+				}
+				for j, pv := range c.PV {
+					if j >= pvLen {
+						break
+					}
+					cand.PV = append(cand.PV, GTPToSGF(pv, size))
+				}
+				s.BestMoves = append(s.BestMoves, cand)
+			}
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func round(v float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,90 @@
+package katrain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+func TestMainLineSample(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	roots, err := sgf.Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	moves, skipped := MainLine(roots[0])
+	if len(skipped) != 0 {
+		t.Fatalf("MainLine skipped %v", skipped)
+	}
+	if len(moves) == 0 {
+		t.Fatal("Expected analysed moves")
+	}
+
+	first := moves[0]
+	if first.Before == nil || first.After == nil {
+		t.Fatal("Expected analysis before and after the first move")
+	}
+	if first.Before.Size != 9 || len(first.Before.Policy) != 82 || len(first.Before.Ownership) != 81 {
+		t.Errorf("Unexpected array sizes: size=%d policy=%d ownership=%d",
+			first.Before.Size, len(first.Before.Policy), len(first.Before.Ownership))
+	}
+	if len(first.Before.Moves) == 0 || first.Before.Moves[0].Order != 0 || len(first.Before.Moves[0].PV) == 0 {
+		t.Errorf("Candidate moves not decoded or not sorted")
+	}
+	if w := first.After.Root.Winrate; w <= 0 || w >= 1 {
+		t.Errorf("Implausible winrate %f", w)
+	}
+
+	var sum float64
+	for _, p := range first.Before.Policy {
+		sum += p
+	}
+	if sum < 0.95 || sum > 1.05 {
+		t.Errorf("Policy should sum to about 1, got %f", sum)
+	}
+
+	summaries := Summarize(moves, 3, 5)
+	if len(summaries) == 0 || len(summaries[0].BestMoves) == 0 || len(summaries[0].BestMoves[0].Move) != 2 {
+		t.Errorf("Unexpected summaries: %+v", summaries)
+	}
+}
+
+func TestGTPToSGF(t *testing.T) {
+	cases := map[string]string{"A19": "aa", "T1": "ss", "J10": "ij", "D4": "dp", "pass": ""}
+	for gtp, expected := range cases {
+		if got := GTPToSGF(gtp, 19); got != expected {
+			t.Errorf("GTPToSGF(%s) = %q, expected %q", gtp, got, expected)
+		}
+	}
+}
+
+func TestMainLineSkipsBadNodes(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	roots, err := sgf.Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	sgf.MainLine(roots[0])[1].Properties["KT"] = []string{"not", "base64", "!"}
+
+	moves, skipped := MainLine(roots[0])
+	if len(skipped) != 1 || skipped[0].MoveNumber != 2 || skipped[0].Error() == "" {
+		t.Fatalf("skipped = %v", skipped)
+	}
+	// Only the damaged node loses its analysis.
+	if moves[0].After == nil || moves[1].After != nil || moves[2].Before != nil || moves[2].After == nil {
+		t.Errorf("analysis around the damaged node: %+v", moves[:3])
+	}
+	for _, s := range Summarize(moves, 3, 5) {
+		if s.MoveNumber == 2 {
+			t.Errorf("summarized the damaged move: %+v", s)
+		}
+	}
+}
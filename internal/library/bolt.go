@@ -0,0 +1,402 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sweetfish329/sai/internal/auth"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket layout:
+//
+//	games/<id>                 game JSON
+//	owners/<owner>/<id>        index of a user's games
+//	analyses/<gameID>/<id>     analysis JSON
+//	images/<gameID>/<id>       image JSON, PNG included
+//	chats/<gameID>/<id>        chat JSON, messages included
+//	users/<id>                 user JSON
+//	sessions/<id>              session JSON, token encrypted
+var (
+	gamesBucket    = []byte("games")
+	ownersBucket   = []byte("owners")
+	analysesBucket = []byte("analyses")
+	imagesBucket   = []byte("images")
+	chatsBucket    = []byte("chats")
+	usersBucket    = []byte("users")
+	sessionsBucket = []byte("sessions")
+)
+
+// Bolt is a Store in a single BoltDB file.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the library file at path.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gamesBucket, ownersBucket, analysesBucket, imagesBucket, chatsBucket, usersBucket, sessionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func put(bucket *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+// game reads a game and checks its owner.
+func game(tx *bolt.Tx, owner, id string) (*Game, error) {
+	data := tx.Bucket(gamesBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var g Game
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	// Owner is not part of the JSON form; the index is the authority.
+	ob := tx.Bucket(ownersBucket).Bucket([]byte(owner))
+	if ob == nil || ob.Get([]byte(id)) == nil {
+		return nil, ErrNotFound
+	}
+	g.Owner = owner
+	return &g, nil
+}
+
+func (b *Bolt) SaveGame(ctx context.Context, g *Game) error {
+	if g.Owner == "" {
+		return errors.New("library: game has no owner")
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ob, err := tx.Bucket(ownersBucket).CreateBucketIfNotExists([]byte(g.Owner))
+		if err != nil {
+			return err
+		}
+		g.ID = newID()
+		g.CreatedAt = time.Now()
+		if err := put(tx.Bucket(gamesBucket), g.ID, g); err != nil {
+			return err
+		}
+		return ob.Put([]byte(g.ID), []byte{})
+	})
+}
+
+func (b *Bolt) ListGames(ctx context.Context, owner string) ([]*Game, error) {
+	games := []*Game{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		ob := tx.Bucket(ownersBucket).Bucket([]byte(owner))
+		if ob == nil {
+			return nil
+		}
+		return ob.ForEach(func(k, _ []byte) error {
+			g, err := game(tx, owner, string(k))
+			if err != nil {
+				return err
+			}
+			g.SGF = ""
+			games = append(games, g)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(games, func(i, j int) bool { return games[i].CreatedAt.After(games[j].CreatedAt) })
+	return games, nil
+}
+
+func (b *Bolt) GetGame(ctx context.Context, owner, id string) (*Game, error) {
+	var g *Game
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		g, err = game(tx, owner, id)
+		return err
+	})
+	return g, err
+}
+
+func (b *Bolt) DeleteGame(ctx context.Context, owner, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, id); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{analysesBucket, imagesBucket, chatsBucket} {
+			if err := tx.Bucket(name).DeleteBucket([]byte(id)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		if err := tx.Bucket(ownersBucket).Bucket([]byte(owner)).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(gamesBucket).Delete([]byte(id))
+	})
+}
+
+func (b *Bolt) SaveAnalysis(ctx context.Context, owner string, a *Analysis) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, a.GameID); err != nil {
+			return err
+		}
+		bucket, err := tx.Bucket(analysesBucket).CreateBucketIfNotExists([]byte(a.GameID))
+		if err != nil {
+			return err
+		}
+		a.ID = newID()
+		a.CreatedAt = time.Now()
+		return put(bucket, a.ID, a)
+	})
+}
+
+func (b *Bolt) ListAnalyses(ctx context.Context, owner, gameID string) ([]*Analysis, error) {
+	analyses := []*Analysis{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, gameID); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(analysesBucket).Bucket([]byte(gameID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var a Analysis
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			analyses = append(analyses, &a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(analyses, func(i, j int) bool { return analyses[i].CreatedAt.Before(analyses[j].CreatedAt) })
+	return analyses, nil
+}
+
+func (b *Bolt) SaveImage(ctx context.Context, owner string, img *Image) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, img.GameID); err != nil {
+			return err
+		}
+		bucket, err := tx.Bucket(imagesBucket).CreateBucketIfNotExists([]byte(img.GameID))
+		if err != nil {
+			return err
+		}
+		img.ID = newID()
+		img.CreatedAt = time.Now()
+		return put(bucket, img.ID, img)
+	})
+}
+
+func (b *Bolt) ListImages(ctx context.Context, owner, gameID string) ([]*Image, error) {
+	images := []*Image{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, gameID); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(imagesBucket).Bucket([]byte(gameID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var img Image
+			if err := json.Unmarshal(v, &img); err != nil {
+				return err
+			}
+			img.PNG = nil
+			images = append(images, &img)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].CreatedAt.Before(images[j].CreatedAt) })
+	return images, nil
+}
+
+func (b *Bolt) GetImage(ctx context.Context, owner, gameID, id string) (*Image, error) {
+	var img Image
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, gameID); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(imagesBucket).Bucket([]byte(gameID))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &img)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+func (b *Bolt) SaveChat(ctx context.Context, owner string, c *Chat) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, c.GameID); err != nil {
+			return err
+		}
+		bucket, err := tx.Bucket(chatsBucket).CreateBucketIfNotExists([]byte(c.GameID))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if c.ID == "" {
+			c.ID = newID()
+			c.CreatedAt = now
+		} else if bucket.Get([]byte(c.ID)) == nil {
+			return ErrNotFound
+		}
+		c.UpdatedAt = now
+		return put(bucket, c.ID, c)
+	})
+}
+
+func (b *Bolt) ListChats(ctx context.Context, owner, gameID string) ([]*Chat, error) {
+	chats := []*Chat{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, gameID); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(chatsBucket).Bucket([]byte(gameID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var c Chat
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			c.Messages = nil
+			chats = append(chats, &c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(chats, func(i, j int) bool { return chats[i].CreatedAt.Before(chats[j].CreatedAt) })
+	return chats, nil
+}
+
+func (b *Bolt) GetChat(ctx context.Context, owner, gameID, id string) (*Chat, error) {
+	var c Chat
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if _, err := game(tx, owner, gameID); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(chatsBucket).Bucket([]byte(gameID))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (b *Bolt) GetUser(ctx context.Context, id string) (*auth.User, error) {
+	var u auth.User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get([]byte(id))
+		if data == nil {
+			return auth.ErrNoUser
+		}
+		return json.Unmarshal(data, &u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (b *Bolt) PutUser(ctx context.Context, u *auth.User) error {
+	if u.ID == "" {
+		return errors.New("library: user has no ID")
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(usersBucket), u.ID, u)
+	})
+}
+
+func (b *Bolt) GetSession(ctx context.Context, id string) (*auth.StoredSession, error) {
+	var sess auth.StoredSession
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return auth.ErrNoSession
+		}
+		return json.Unmarshal(data, &sess)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// PutSession stores a session and drops expired ones.
+func (b *Bolt) PutSession(ctx context.Context, sess *auth.StoredSession) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		now := time.Now()
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var s auth.StoredSession
+			if json.Unmarshal(v, &s) == nil && now.After(s.ExpiresAt) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return put(bucket, sess.ID, sess)
+	})
+}
+
+func (b *Bolt) DeleteSession(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
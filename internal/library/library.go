@@ -0,0 +1,133 @@
+// Package library stores each user's games together with the analyses run
+// on them, the board images generated along the way and the conversations
+// held about them.
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/llm"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+// ErrNotFound is returned for games, analyses or images that do not exist
+// or belong to another user.
+var ErrNotFound = errors.New("library: not found")
+
+// Game is a stored game record. SGF is left empty in listings.
+type Game struct {
+	ID         string       `json:"id"`
+	Owner      string       `json:"-"`
+	Info       sgf.GameInfo `json:"info"`
+	MovesCount int          `json:"movesCount"`
+	SGF        string       `json:"sgf,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+}
+
+// Analysis is one run of the coach on a game.
+type Analysis struct {
+	ID     string `json:"id"`
+	GameID string `json:"gameId"`
+	// Kind is the flow that produced it, e.g. "analyze" or "review".
+	Kind          string `json:"kind"`
+	Model         string `json:"model"`
+	PromptVersion string `json:"promptVersion"`
+	// Result is the flow output as JSON.
+	Result    json.RawMessage `json:"result"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Image is a board image generated for a game.
+type Image struct {
+	ID         string `json:"id"`
+	GameID     string `json:"gameId"`
+	AnalysisID string `json:"analysisId,omitempty"`
+	MoveNumber int    `json:"moveNumber"`
+	// PNG is left empty in listings.
+	PNG       []byte    `json:"png,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Chat is a follow-up conversation with the coach about a game.
+type Chat struct {
+	ID     string `json:"id"`
+	GameID string `json:"gameId"`
+	// Model, Persona and Language are those of every reply.
+	Model    string `json:"model"`
+	Persona  string `json:"persona,omitempty"`
+	Language string `json:"language,omitempty"`
+	// PromptVersion names the prompt templates of the latest reply.
+	PromptVersion string `json:"promptVersion,omitempty"`
+	// Messages is the whole history; only the newest messages that fit the
+	// token budget are sent with the next question. It is left empty in
+	// listings.
+	Messages  []llm.Message `json:"messages,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+// Store keeps the library, its users and their sessions. Every game method is scoped to
+// the owner of the game, so one user can never reach another's records.
+type Store interface {
+	auth.UserStore
+	auth.SessionStore
+
+	// SaveGame stores a new game and fills in its ID and CreatedAt.
+	SaveGame(ctx context.Context, g *Game) error
+	// ListGames returns the owner's games, newest first, without SGF.
+	ListGames(ctx context.Context, owner string) ([]*Game, error)
+	GetGame(ctx context.Context, owner, id string) (*Game, error)
+	// DeleteGame removes a game with its analyses, images and chats.
+	DeleteGame(ctx context.Context, owner, id string) error
+
+	SaveAnalysis(ctx context.Context, owner string, a *Analysis) error
+	// ListAnalyses returns the analyses of a game, oldest first.
+	ListAnalyses(ctx context.Context, owner, gameID string) ([]*Analysis, error)
+
+	SaveImage(ctx context.Context, owner string, img *Image) error
+	// ListImages returns the images of a game, oldest first, without PNG.
+	ListImages(ctx context.Context, owner, gameID string) ([]*Image, error)
+	GetImage(ctx context.Context, owner, gameID, id string) (*Image, error)
+
+	// SaveChat stores a chat, creating it when its ID is empty.
+	SaveChat(ctx context.Context, owner string, c *Chat) error
+	// ListChats returns the chats about a game, oldest first, without
+	// messages.
+	ListChats(ctx context.Context, owner, gameID string) ([]*Chat, error)
+	GetChat(ctx context.Context, owner, gameID, id string) (*Chat, error)
+
+	Close() error
+}
+
+// NewGame parses an SGF and returns an unsaved game with its metadata.
+func NewGame(owner, sgfContent string) (*Game, error) {
+	roots, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no game found")
+	}
+	data := sgf.ExtractGameData(roots[0])
+	return &Game{
+		Owner:      owner,
+		Info:       data.GameInfo,
+		MovesCount: data.MovesCount,
+		SGF:        sgfContent,
+	}, nil
+}
+
+// newID returns a random ID. Creation order is kept separately, so IDs
+// need not sort.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,175 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/auth"
+	"github.com/sweetfish329/sai/internal/llm"
+)
+
+const testSGF = "(;GM[1]FF[4]SZ[9]KM[6.5]DT[2024-05-01]PB[Shusaku]PW[Gennan]RE[B+2];B[ee];W[gc])"
+
+func openTest(t *testing.T) *Bolt {
+	t.Helper()
+	b, err := OpenBolt(filepath.Join(t.TempDir(), "sai.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestGames(t *testing.T) {
+	b := openTest(t)
+	ctx := context.Background()
+
+	g, err := NewGame("alice", testSGF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Info.Date != "2024-05-01" || g.Info.BlackPlayer != "Shusaku" || g.Info.Komi != "6.5" || g.MovesCount != 2 {
+		t.Errorf("NewGame = %+v", g)
+	}
+	if err := b.SaveGame(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewGame("alice", testSGF)
+	b.SaveGame(ctx, second)
+	other, _ := NewGame("bob", testSGF)
+	b.SaveGame(ctx, other)
+
+	games, err := b.ListGames(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 2 || games[0].ID != second.ID || games[0].SGF != "" {
+		t.Errorf("ListGames = %+v", games)
+	}
+
+	got, err := b.GetGame(ctx, "alice", g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SGF != testSGF || got.Info.Result != "B+2" {
+		t.Errorf("GetGame = %+v", got)
+	}
+	if _, err := b.GetGame(ctx, "bob", g.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetGame by another user: err = %v", err)
+	}
+	if err := b.DeleteGame(ctx, "bob", g.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteGame by another user: err = %v", err)
+	}
+	if err := b.SaveGame(ctx, &Game{}); err == nil {
+		t.Error("saved a game without owner")
+	}
+}
+
+func TestAnalysesAndImages(t *testing.T) {
+	b := openTest(t)
+	ctx := context.Background()
+
+	g, _ := NewGame("alice", testSGF)
+	b.SaveGame(ctx, g)
+
+	a := &Analysis{GameID: g.ID, Kind: "analyze", Model: "gemini-2.5-flash", PromptVersion: "1", Result: []byte(`{"result":"ok"}`)}
+	if err := b.SaveAnalysis(ctx, "alice", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveAnalysis(ctx, "bob", &Analysis{GameID: g.ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveAnalysis by another user: err = %v", err)
+	}
+	img := &Image{GameID: g.ID, AnalysisID: a.ID, MoveNumber: 2, PNG: []byte("\x89PNG")}
+	if err := b.SaveImage(ctx, "alice", img); err != nil {
+		t.Fatal(err)
+	}
+
+	analyses, err := b.ListAnalyses(ctx, "alice", g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(analyses) != 1 || string(analyses[0].Result) != `{"result":"ok"}` || analyses[0].Model != "gemini-2.5-flash" {
+		t.Errorf("ListAnalyses = %+v", analyses)
+	}
+
+	images, _ := b.ListImages(ctx, "alice", g.ID)
+	if len(images) != 1 || images[0].PNG != nil || images[0].MoveNumber != 2 {
+		t.Errorf("ListImages = %+v", images)
+	}
+	got, err := b.GetImage(ctx, "alice", g.ID, img.ID)
+	if err != nil || string(got.PNG) != "\x89PNG" {
+		t.Errorf("GetImage = %+v, %v", got, err)
+	}
+
+	if err := b.DeleteGame(ctx, "alice", g.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetImage(ctx, "alice", g.ID, img.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("image survived its game: %v", err)
+	}
+	if games, _ := b.ListGames(ctx, "alice"); len(games) != 0 {
+		t.Errorf("games after delete = %+v", games)
+	}
+}
+
+func TestChats(t *testing.T) {
+	b := openTest(t)
+	ctx := context.Background()
+
+	g, _ := NewGame("alice", testSGF)
+	b.SaveGame(ctx, g)
+
+	c := &Chat{GameID: g.ID, Model: "gemini-2.5-flash", Persona: "kids"}
+	if err := b.SaveChat(ctx, "alice", c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == "" || c.CreatedAt.IsZero() {
+		t.Fatalf("SaveChat = %+v", c)
+	}
+	c.Messages = []llm.Message{{Role: llm.RoleUser, Text: "Why was move 2 bad?"}, {Role: llm.RoleModel, Text: "Too far."}}
+	c.PromptVersion = "chat@1"
+	if err := b.SaveChat(ctx, "alice", c); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveChat(ctx, "bob", &Chat{GameID: g.ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveChat by another user: err = %v", err)
+	}
+	if err := b.SaveChat(ctx, "alice", &Chat{ID: "nope", GameID: g.ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SaveChat with an unknown ID: err = %v", err)
+	}
+
+	chats, err := b.ListChats(ctx, "alice", g.ID)
+	if err != nil || len(chats) != 1 || chats[0].Messages != nil || chats[0].Persona != "kids" {
+		t.Errorf("ListChats = %+v, %v", chats, err)
+	}
+	got, err := b.GetChat(ctx, "alice", g.ID, c.ID)
+	if err != nil || len(got.Messages) != 2 || got.Messages[1].Text != "Too far." || got.PromptVersion != "chat@1" {
+		t.Errorf("GetChat = %+v, %v", got, err)
+	}
+	if _, err := b.GetChat(ctx, "bob", g.ID, c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetChat by another user: err = %v", err)
+	}
+
+	b.DeleteGame(ctx, "alice", g.ID)
+	if _, err := b.GetChat(ctx, "alice", g.ID, c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("chat survived its game: %v", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	b := openTest(t)
+	ctx := context.Background()
+
+	if _, err := b.GetUser(ctx, "123"); !errors.Is(err, auth.ErrNoUser) {
+		t.Errorf("GetUser(unknown) err = %v", err)
+	}
+	if err := b.PutUser(ctx, &auth.User{ID: "123", Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := b.GetUser(ctx, "123")
+	if err != nil || u.Email != "a@example.com" {
+		t.Errorf("GetUser = %+v, %v", u, err)
+	}
+}
@@ -0,0 +1,84 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrScriptExhausted is returned by Scripted when it has no replies left.
+var ErrScriptExhausted = errors.New("llm: scripted provider has no replies left")
+
+// Scripted is a deterministic provider for tests and offline runs. It
+// returns its Replies in order and records every request.
+type Scripted struct {
+	Replies []Message
+
+	mu       sync.Mutex
+	next     int
+	requests []Request
+}
+
+// LoadScript reads a Scripted provider from a JSON array of messages.
+func LoadScript(path string) (*Scripted, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var replies []Message
+	if err := json.Unmarshal(b, &replies); err != nil {
+		return nil, fmt.Errorf("llm: bad script %s: %w", path, err)
+	}
+	return &Scripted{Replies: replies}, nil
+}
+
+// Requests returns the requests received so far.
+func (s *Scripted) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Scripted) Generate(ctx context.Context, req *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := *req
+	r.Messages = append([]Message(nil), req.Messages...)
+	s.requests = append(s.requests, r)
+
+	if s.next >= len(s.Replies) {
+		return nil, ErrScriptExhausted
+	}
+	msg := s.Replies[s.next]
+	s.next++
+	msg.Role = RoleModel
+	return &Response{Message: msg}, nil
+}
+
+// Stream hands the reply's text to onText word by word.
+func (s *Scripted) Stream(ctx context.Context, req *Request, onText func(string) error) (*Response, error) {
+	resp, err := s.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	text := resp.Message.Text
+	for text != "" {
+		i := strings.IndexByte(text, ' ') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		if err := onText(text[:i]); err != nil {
+			return nil, err
+		}
+		text = text[i:]
+	}
+	return resp, nil
+}
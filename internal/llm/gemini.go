@@ -0,0 +1,255 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type accessTokenKey struct{}
+
+type tokenSourceKey struct{}
+
+// WithAccessToken returns a context carrying the user's Google OAuth access
+// token, which Gemini uses when it has no credentials of its own.
+func WithAccessToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, accessTokenKey{}, token)
+}
+
+// WithTokenSource is WithAccessToken for credentials that refresh
+// themselves. It takes precedence over an access token.
+func WithTokenSource(ctx context.Context, ts oauth2.TokenSource) context.Context {
+	return context.WithValue(ctx, tokenSourceKey{}, ts)
+}
+
+// Gemini calls the Gemini API.
+type Gemini struct {
+	// Options are passed to genai.NewClient. When empty, every call is made
+	// with the credentials in its context.
+	Options []option.ClientOption
+}
+
+// geminiScope is the OAuth scope service accounts call Gemini with.
+const geminiScope = "https://www.googleapis.com/auth/generative-language"
+
+// newGemini returns a Gemini provider using the credentials cfg.Auth names.
+// Credentials are loaded here so that a misconfigured server fails at
+// startup rather than on its first analysis.
+func newGemini(cfg Config) (*Gemini, error) {
+	switch strings.ToLower(cfg.Auth) {
+	case "", "user":
+		return &Gemini{}, nil
+	case "api-key":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("llm: the api-key auth mode needs an API key")
+		}
+		return &Gemini{Options: []option.ClientOption{option.WithAPIKey(cfg.APIKey)}}, nil
+	case "service-account":
+		ctx := context.Background()
+		var creds *google.Credentials
+		var err error
+		if cfg.Credentials == "" {
+			creds, err = google.FindDefaultCredentials(ctx, geminiScope)
+		} else {
+			var data []byte
+			data, err = os.ReadFile(cfg.Credentials)
+			if err == nil {
+				creds, err = google.CredentialsFromJSON(ctx, data, geminiScope)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("llm: failed to load service account credentials: %w", err)
+		}
+		return &Gemini{Options: []option.ClientOption{option.WithCredentials(creds)}}, nil
+	}
+	return nil, fmt.Errorf("llm: unknown Gemini auth mode %q", cfg.Auth)
+}
+
+// NeedsAccessToken reports whether calls must carry the user's access token.
+func (g *Gemini) NeedsAccessToken() bool {
+	return len(g.Options) == 0
+}
+
+func (g *Gemini) client(ctx context.Context) (*genai.Client, error) {
+	opts := g.Options
+	if len(opts) == 0 {
+		ts, _ := ctx.Value(tokenSourceKey{}).(oauth2.TokenSource)
+		if ts == nil {
+			token, _ := ctx.Value(accessTokenKey{}).(string)
+			if token == "" {
+				return nil, fmt.Errorf("missing auth token")
+			}
+			ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+		}
+		opts = []option.ClientOption{option.WithTokenSource(ts)}
+	}
+	client, err := genai.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return client, nil
+}
+
+// session prepares a chat holding every message but the last, which is
+// returned as the parts to send.
+func (g *Gemini) session(client *genai.Client, req *Request) (*genai.ChatSession, []genai.Part, error) {
+	if len(req.Messages) == 0 {
+		return nil, nil, fmt.Errorf("llm: no messages")
+	}
+
+	model := client.GenerativeModel(req.Model)
+	if req.System != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
+	}
+	if len(req.Tools) > 0 {
+		tool := &genai.Tool{}
+		for _, t := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, &genai.FunctionDeclaration{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  geminiSchema(t.Parameters),
+			})
+		}
+		model.Tools = []*genai.Tool{tool}
+	}
+	if req.ResponseSchema != nil {
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = geminiSchema(req.ResponseSchema)
+	}
+
+	session := model.StartChat()
+	for _, m := range req.Messages[:len(req.Messages)-1] {
+		session.History = append(session.History, geminiContent(m))
+	}
+	return session, geminiContent(req.Messages[len(req.Messages)-1]).Parts, nil
+}
+
+func (g *Gemini) Generate(ctx context.Context, req *Request) (*Response, error) {
+	client, err := g.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	session, parts, err := g.session(client, req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := session.SendMessage(ctx, parts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	resp := &Response{Message: Message{Role: RoleModel}}
+	appendGemini(resp, res, nil)
+	return resp, nil
+}
+
+func (g *Gemini) Stream(ctx context.Context, req *Request, onText func(string) error) (*Response, error) {
+	client, err := g.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	session, parts, err := g.session(client, req)
+	if err != nil {
+		return nil, err
+	}
+	iter := session.SendMessageStream(ctx, parts...)
+	resp := &Response{Message: Message{Role: RoleModel}}
+	for {
+		res, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return resp, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to send message: %w", err)
+		}
+		if err := appendGemini(resp, res, onText); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// appendGemini adds the text, tool calls and usage of res to resp.
+func appendGemini(resp *Response, res *genai.GenerateContentResponse, onText func(string) error) error {
+	if res.UsageMetadata != nil {
+		resp.Usage.InputTokens = int(res.UsageMetadata.PromptTokenCount)
+		resp.Usage.OutputTokens = int(res.UsageMetadata.CandidatesTokenCount)
+	}
+	if len(res.Candidates) == 0 || res.Candidates[0].Content == nil {
+		return nil
+	}
+	for _, part := range res.Candidates[0].Content.Parts {
+		switch p := part.(type) {
+		case genai.Text:
+			resp.Message.Text += string(p)
+			if onText != nil && p != "" {
+				if err := onText(string(p)); err != nil {
+					return err
+				}
+			}
+		case genai.FunctionCall:
+			resp.Message.ToolCalls = append(resp.Message.ToolCalls, ToolCall{Name: p.Name, Args: p.Args})
+		}
+	}
+	return nil
+}
+
+func geminiContent(m Message) *genai.Content {
+	c := &genai.Content{Role: "user"}
+	if m.Role == RoleModel {
+		c.Role = "model"
+	}
+	if m.Text != "" {
+		c.Parts = append(c.Parts, genai.Text(m.Text))
+	}
+	for _, tc := range m.ToolCalls {
+		c.Parts = append(c.Parts, genai.FunctionCall{Name: tc.Name, Args: tc.Args})
+	}
+	for _, tr := range m.ToolResults {
+		c.Parts = append(c.Parts, genai.FunctionResponse{Name: tr.Name, Response: tr.Response})
+	}
+	return c
+}
+
+func geminiSchema(s *Schema) *genai.Schema {
+	if s == nil {
+		return nil
+	}
+	gs := &genai.Schema{
+		Description: s.Description,
+		Enum:        s.Enum,
+		Required:    s.Required,
+		Items:       geminiSchema(s.Items),
+	}
+	switch s.Type {
+	case "object":
+		gs.Type = genai.TypeObject
+	case "array":
+		gs.Type = genai.TypeArray
+	case "string":
+		gs.Type = genai.TypeString
+	case "integer":
+		gs.Type = genai.TypeInteger
+	case "number":
+		gs.Type = genai.TypeNumber
+	case "boolean":
+		gs.Type = genai.TypeBoolean
+	}
+	if len(s.Properties) > 0 {
+		gs.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, p := range s.Properties {
+			gs.Properties[name] = geminiSchema(p)
+		}
+	}
+	return gs
+}
@@ -0,0 +1,153 @@
+// Package llm is the language model interface used by the coach. A Provider
+// turns a conversation, optionally with tools or a JSON response schema,
+// into the model's next message. Gemini, any OpenAI-compatible server
+// (llama.cpp, Ollama, vLLM, ...) and a scripted fake for tests implement it.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Role is the author of a message.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleModel Role = "model"
+	// RoleTool messages carry the results of the model's tool calls.
+	RoleTool Role = "tool"
+)
+
+// Message is one turn of a conversation.
+type Message struct {
+	Role        Role         `json:"role"`
+	Text        string       `json:"text,omitempty"`
+	ToolCalls   []ToolCall   `json:"toolCalls,omitempty"`
+	ToolResults []ToolResult `json:"toolResults,omitempty"`
+}
+
+// ToolCall is a request by the model to run a tool. Numbers in Args are
+// float64, as decoded from JSON.
+type ToolCall struct {
+	// ID links the call to its result. Providers that do not use IDs leave
+	// it empty.
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// ToolResult is the output of a tool call. Response must be a plain JSON
+// object.
+type ToolResult struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// Schema is the subset of JSON Schema that every provider understands.
+type Schema struct {
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+}
+
+// SchemaFromJSON converts a JSON schema, e.g. one reflected from a Go type,
+// to a Schema. Keywords outside the subset are dropped; a keyword of the
+// subset in another form, such as a list of types, is an error.
+func SchemaFromJSON(v interface{}) (*Schema, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("llm: schema: %w", err)
+	}
+	var s Schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("llm: schema: %w", err)
+	}
+	return &s, nil
+}
+
+// Tool is a function the model may call.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  *Schema
+}
+
+// Request is one call to the model.
+type Request struct {
+	// Model names the model; each provider has its own names.
+	Model    string
+	System   string
+	Messages []Message
+	Tools    []Tool
+	// ResponseSchema, when set, makes the model answer with JSON matching
+	// the schema instead of calling tools.
+	ResponseSchema *Schema
+}
+
+// Usage is the token count of a call, when the provider reports it.
+type Usage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+}
+
+// Response is the model's reply.
+type Response struct {
+	Message Message
+	Usage   Usage
+}
+
+// Provider is a language model backend.
+type Provider interface {
+	Generate(ctx context.Context, req *Request) (*Response, error)
+	// Stream is Generate that also hands the reply's text to onText as it
+	// arrives. Tool calls are only reported in the final response.
+	Stream(ctx context.Context, req *Request, onText func(string) error) (*Response, error)
+}
+
+// Config selects and configures a provider.
+type Config struct {
+	// Provider is "gemini" (the default), "openai" or "fake".
+	Provider string
+	// BaseURL is the OpenAI-compatible endpoint, e.g.
+	// http://localhost:11434/v1 for Ollama.
+	BaseURL string
+	// APIKey is sent as a bearer token to OpenAI-compatible servers, and is
+	// the Gemini API key in the "api-key" auth mode.
+	APIKey string
+	// Auth is whose credentials Gemini is called with: "user" (the default)
+	// for the signed-in user's OAuth token, "api-key" for APIKey, or
+	// "service-account" for Credentials.
+	Auth string
+	// Credentials is the service account JSON file for the
+	// "service-account" auth mode. When empty, Application Default
+	// Credentials are used.
+	Credentials string
+	// Script is the JSON file of replies for the fake provider.
+	Script string
+}
+
+// New returns the provider described by cfg.
+func New(cfg Config) (Provider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "", "gemini":
+		return newGemini(cfg)
+	case "openai":
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("llm: the openai provider needs a base URL")
+		}
+		return &OpenAI{BaseURL: cfg.BaseURL, APIKey: cfg.APIKey}, nil
+	case "fake":
+		if cfg.Script == "" {
+			return &Scripted{}, nil
+		}
+		return LoadScript(cfg.Script)
+	}
+	return nil, fmt.Errorf("llm: unknown provider %q", cfg.Provider)
+}
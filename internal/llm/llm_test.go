@@ -0,0 +1,256 @@
+package llm
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+var testRequest = &Request{
+	Model:  "test-model",
+	System: "You are a coach.",
+	Messages: []Message{
+		{Role: RoleUser, Text: "Review this game."},
+		{Role: RoleModel, ToolCalls: []ToolCall{{ID: "c1", Name: "readSgf", Args: map[string]interface{}{"sgfContent": "(;)"}}}},
+		{Role: RoleTool, ToolResults: []ToolResult{{ID: "c1", Name: "readSgf", Response: map[string]interface{}{"movesCount": 0.0}}}},
+	},
+	Tools: []Tool{{
+		Name:       "readSgf",
+		Parameters: &Schema{Type: "object", Properties: map[string]*Schema{"sgfContent": {Type: "string"}}, Required: []string{"sgfContent"}},
+	}},
+}
+
+func TestOpenAIGenerate(t *testing.T) {
+	var got openAIRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"c2","type":"function","function":{"name":"generateBoardImage","arguments":"{\"moveNumber\":3}"}}]}}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`)
+	}))
+	defer ts.Close()
+
+	p := &OpenAI{BaseURL: ts.URL + "/v1/", APIKey: "key"}
+	resp, err := p.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Model != "test-model" || len(got.Messages) != 4 {
+		t.Fatalf("request = %+v", got)
+	}
+	roles := []string{got.Messages[0].Role, got.Messages[1].Role, got.Messages[2].Role, got.Messages[3].Role}
+	if !reflect.DeepEqual(roles, []string{"system", "user", "assistant", "tool"}) {
+		t.Errorf("roles = %v", roles)
+	}
+	if call := got.Messages[2].ToolCalls[0]; call.ID != "c1" || call.Function.Arguments != `{"sgfContent":"(;)"}` {
+		t.Errorf("tool call = %+v", call)
+	}
+	if tr := got.Messages[3]; tr.ToolCallID != "c1" || tr.Content != `{"movesCount":0}` {
+		t.Errorf("tool result = %+v", tr)
+	}
+	if len(got.Tools) != 1 || got.Tools[0].Function.Parameters.Properties["sgfContent"].Type != "string" {
+		t.Errorf("tools = %+v", got.Tools)
+	}
+
+	want := []ToolCall{{ID: "c2", Name: "generateBoardImage", Args: map[string]interface{}{"moveNumber": 3.0}}}
+	if !reflect.DeepEqual(resp.Message.ToolCalls, want) {
+		t.Errorf("tool calls = %+v, want %+v", resp.Message.ToolCalls, want)
+	}
+	if resp.Usage != (Usage{InputTokens: 10, OutputTokens: 5}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"delta":{"content":"Black "}}]}`,
+		`{"choices":[{"delta":{"content":"wins."}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"c1","function":{"name":"readSgf","arguments":"{\"sgf"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"Content\":\"(;)\"}"}}]}}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":4}}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			http.Error(w, "not streaming", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	var streamed []string
+	resp, err := (&OpenAI{BaseURL: ts.URL}).Stream(context.Background(), testRequest, func(s string) error {
+		streamed = append(streamed, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(streamed, []string{"Black ", "wins."}) || resp.Message.Text != "Black wins." {
+		t.Errorf("streamed %q, text %q", streamed, resp.Message.Text)
+	}
+	want := []ToolCall{{ID: "c1", Name: "readSgf", Args: map[string]interface{}{"sgfContent": "(;)"}}}
+	if !reflect.DeepEqual(resp.Message.ToolCalls, want) {
+		t.Errorf("tool calls = %+v", resp.Message.ToolCalls)
+	}
+	if resp.Usage.OutputTokens != 4 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestOpenAIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not found", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	_, err := (&OpenAI{BaseURL: ts.URL}).Generate(context.Background(), testRequest)
+	if err == nil || !strings.Contains(err.Error(), "model not found") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestScripted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "script.json")
+	os.WriteFile(path, []byte(`[{"toolCalls":[{"name":"readSgf","args":{"sgfContent":"(;)"}}]},{"text":"Good game."}]`), 0o644)
+
+	p, err := New(Config{Provider: "fake", Script: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := p.Generate(context.Background(), testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message.Role != RoleModel || resp.Message.ToolCalls[0].Name != "readSgf" {
+		t.Errorf("first reply = %+v", resp.Message)
+	}
+
+	var streamed []string
+	resp, err = p.Stream(context.Background(), testRequest, func(s string) error {
+		streamed = append(streamed, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(streamed, []string{"Good ", "game."}) {
+		t.Errorf("streamed %q", streamed)
+	}
+
+	if _, err := p.Generate(context.Background(), testRequest); !errors.Is(err, ErrScriptExhausted) {
+		t.Errorf("err = %v, want ErrScriptExhausted", err)
+	}
+	if n := len(p.(*Scripted).Requests()); n != 3 {
+		t.Errorf("recorded %d requests, want 3", n)
+	}
+}
+
+func TestSchemaFromJSON(t *testing.T) {
+	s, err := SchemaFromJSON(map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"moves": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+		"required":             []string{"moves"},
+		"additionalProperties": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != "object" || s.Properties["moves"].Items.Type != "string" || s.Required[0] != "moves" {
+		t.Errorf("schema = %+v", s)
+	}
+	if _, err := SchemaFromJSON(map[string]interface{}{"type": []string{"string", "null"}}); err == nil {
+		t.Error("accepted a list of types")
+	}
+}
+
+func TestGeminiConversion(t *testing.T) {
+	s := geminiSchema(testRequest.Tools[0].Parameters)
+	if s.Type != genai.TypeObject || s.Properties["sgfContent"].Type != genai.TypeString || s.Required[0] != "sgfContent" {
+		t.Errorf("schema = %+v", s)
+	}
+
+	c := geminiContent(testRequest.Messages[1])
+	if c.Role != "model" || len(c.Parts) != 1 {
+		t.Fatalf("content = %+v", c)
+	}
+	if fc, ok := c.Parts[0].(genai.FunctionCall); !ok || fc.Name != "readSgf" {
+		t.Errorf("part = %#v", c.Parts[0])
+	}
+
+	if _, err := (&Gemini{}).Generate(context.Background(), testRequest); err == nil || err.Error() != "missing auth token" {
+		t.Errorf("err = %v, want missing auth token", err)
+	}
+}
+
+func TestGeminiAuth(t *testing.T) {
+	gemini := func(cfg Config) *Gemini {
+		t.Helper()
+		cfg.Provider = "gemini"
+		p, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.(*Gemini)
+	}
+	if !gemini(Config{}).NeedsAccessToken() {
+		t.Error("user mode does not need the user's token")
+	}
+	if gemini(Config{Auth: "api-key", APIKey: "k"}).NeedsAccessToken() {
+		t.Error("api-key mode needs the user's token")
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	sa, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sai@example.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    "https://oauth2.googleapis.com/token",
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, sa, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if gemini(Config{Auth: "service-account", Credentials: path}).NeedsAccessToken() {
+		t.Error("service-account mode needs the user's token")
+	}
+
+	for _, cfg := range []Config{
+		{Auth: "api-key"},
+		{Auth: "service-account", Credentials: filepath.Join(t.TempDir(), "missing.json")},
+		{Auth: "oauth"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
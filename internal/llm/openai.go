@@ -0,0 +1,263 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAI calls a server speaking the OpenAI chat completions API, such as
+// llama.cpp's llama-server, Ollama or vLLM.
+type OpenAI struct {
+	// BaseURL is the API root, without /chat/completions.
+	BaseURL string
+	// APIKey is optional; local servers usually ignore it.
+	APIKey string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description,omitempty"`
+		Parameters  *Schema `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIRequest struct {
+	Model          string          `json:"model"`
+	Messages       []openAIMessage `json:"messages"`
+	Tools          []openAITool    `json:"tools,omitempty"`
+	ResponseFormat interface{}     `json:"response_format,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  interface{}     `json:"stream_options,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+func (o *OpenAI) body(req *Request, stream bool) ([]byte, error) {
+	body := openAIRequest{Model: req.Model, Stream: stream}
+	if stream {
+		body.StreamOptions = map[string]bool{"include_usage": true}
+	}
+	if req.System != "" {
+		body.Messages = append(body.Messages, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleModel:
+			msg := openAIMessage{Role: "assistant", Content: m.Text}
+			for i, tc := range m.ToolCalls {
+				call := openAIToolCall{Index: i, ID: tc.ID, Type: "function"}
+				call.Function.Name = tc.Name
+				args, err := json.Marshal(tc.Args)
+				if err != nil {
+					return nil, err
+				}
+				call.Function.Arguments = string(args)
+				msg.ToolCalls = append(msg.ToolCalls, call)
+			}
+			body.Messages = append(body.Messages, msg)
+		case RoleTool:
+			for _, tr := range m.ToolResults {
+				content, err := json.Marshal(tr.Response)
+				if err != nil {
+					return nil, err
+				}
+				body.Messages = append(body.Messages, openAIMessage{Role: "tool", Content: string(content), ToolCallID: tr.ID})
+			}
+		default:
+			body.Messages = append(body.Messages, openAIMessage{Role: "user", Content: m.Text})
+		}
+	}
+	for _, t := range req.Tools {
+		tool := openAITool{Type: "function"}
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		tool.Function.Parameters = t.Parameters
+		body.Tools = append(body.Tools, tool)
+	}
+	if req.ResponseSchema != nil {
+		body.ResponseFormat = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": req.ResponseSchema,
+			},
+		}
+	}
+	return json.Marshal(body)
+}
+
+func (o *OpenAI) post(ctx context.Context, body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(o.BaseURL, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("llm: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (o *OpenAI) Generate(ctx context.Context, req *Request) (*Response, error) {
+	body, err := o.body(req, false)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := o.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var res openAIResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("llm: failed to decode response: %w", err)
+	}
+	resp := &Response{Message: Message{Role: RoleModel}}
+	if res.Usage != nil {
+		resp.Usage = Usage{InputTokens: res.Usage.PromptTokens, OutputTokens: res.Usage.CompletionTokens}
+	}
+	if len(res.Choices) == 0 {
+		return resp, nil
+	}
+	msg := res.Choices[0].Message
+	resp.Message.Text = msg.Content
+	calls, err := toolCalls(msg.ToolCalls)
+	if err != nil {
+		return nil, err
+	}
+	resp.Message.ToolCalls = calls
+	return resp, nil
+}
+
+func (o *OpenAI) Stream(ctx context.Context, req *Request, onText func(string) error) (*Response, error) {
+	body, err := o.body(req, true)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := o.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	resp := &Response{Message: Message{Role: RoleModel}}
+	// Tool calls arrive in fragments keyed by index.
+	var calls []openAIToolCall
+	sc := bufio.NewScanner(httpResp.Body)
+	sc.Buffer(make([]byte, 64*1024), 8*1024*1024)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("llm: failed to decode stream: %w", err)
+		}
+		if chunk.Usage != nil {
+			resp.Usage = Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			resp.Message.Text += delta.Content
+			if err := onText(delta.Content); err != nil {
+				return nil, err
+			}
+		}
+		for _, tc := range delta.ToolCalls {
+			for len(calls) <= tc.Index {
+				calls = append(calls, openAIToolCall{Index: len(calls)})
+			}
+			c := &calls[tc.Index]
+			if tc.ID != "" {
+				c.ID = tc.ID
+			}
+			c.Function.Name += tc.Function.Name
+			c.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	resp.Message.ToolCalls, err = toolCalls(calls)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func toolCalls(calls []openAIToolCall) ([]ToolCall, error) {
+	var out []ToolCall
+	for i, c := range calls {
+		call := ToolCall{ID: c.ID, Name: c.Function.Name}
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d", i)
+		}
+		if strings.TrimSpace(c.Function.Arguments) != "" {
+			if err := json.Unmarshal([]byte(c.Function.Arguments), &call.Args); err != nil {
+				return nil, fmt.Errorf("llm: bad arguments for %s: %w", call.Name, err)
+			}
+		}
+		out = append(out, call)
+	}
+	return out, nil
+}
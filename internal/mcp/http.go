@@ -0,0 +1,69 @@
+package mcp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// ServeHTTP implements the streamable HTTP transport for clients that post
+// JSON-RPC messages. Every request is answered with a plain JSON body; the
+// server never opens an SSE stream, which the transport allows. Requests
+// from browser origins other than loopback and AllowedOrigins are refused.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.allowedOrigin(r.Header.Get("Origin")) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet, http.MethodDelete:
+		// No server-initiated stream and no session state to delete.
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 32*1024*1024))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	responses, batch := s.HandleMessage(r.Context(), body)
+	if len(responses) == 0 {
+		// Only notifications or responses were posted.
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+	json.NewEncoder(w).Encode(responses[0])
+}
+
+// allowedOrigin checks the Origin header, as the transport requires against
+// DNS rebinding: a page whose host name was pointed at a local server still
+// sends its own origin. Clients that are not browsers send none.
+func (s *Server) allowedOrigin(origin string) bool {
+	if origin == "" || slices.Contains(s.AllowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
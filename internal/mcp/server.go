@@ -0,0 +1,303 @@
+// Package mcp is a small Model Context Protocol server. It supports the
+// parts of the protocol Sai needs: tools, resources and ping, over stdio
+// (newline-delimited JSON-RPC) or streamable HTTP.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// protocolVersions are the MCP revisions this server understands, newest
+// first.
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// Content is one item of a tool result.
+type Content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// TextContent returns a text content item.
+func TextContent(text string) Content {
+	return Content{Type: "text", Text: text}
+}
+
+// ImageContent returns a base64 image content item.
+func ImageContent(data, mimeType string) Content {
+	return Content{Type: "image", Data: data, MimeType: mimeType}
+}
+
+// ToolResult is the result of tools/call.
+type ToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Tool is a tool exposed to clients. InputSchema is a JSON Schema object.
+type Tool struct {
+	Name        string                                                              `json:"name"`
+	Description string                                                              `json:"description"`
+	InputSchema map[string]interface{}                                              `json:"inputSchema"`
+	Handler     func(ctx context.Context, args json.RawMessage) (ToolResult, error) `json:"-"`
+}
+
+// Resource describes a readable resource.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents is the body of a resource.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text"`
+}
+
+// Resources provides the resources a server exposes.
+type Resources interface {
+	List(ctx context.Context) ([]Resource, error)
+	Read(ctx context.Context, uri string) (ResourceContents, error)
+}
+
+// Server dispatches MCP requests to tools and resources.
+type Server struct {
+	Name      string
+	Version   string
+	Resources Resources
+	// AllowedOrigins are the browser origins, such as
+	// "https://example.com", that may use the HTTP transport besides
+	// loopback ones.
+	AllowedOrigins []string
+
+	tools []Tool
+}
+
+func NewServer(name, version string) *Server {
+	return &Server{Name: name, Version: version}
+}
+
+// AddTool registers a tool. Tools are listed in registration order.
+func (s *Server) AddTool(t Tool) {
+	s.tools = append(s.tools, t)
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// Result and Error are set on responses from the client.
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: msg}}
+}
+
+// ServeStdio reads newline-delimited JSON-RPC messages from r and writes
+// responses to w until r is closed or ctx is cancelled.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	sc := bufio.NewScanner(r)
+	// Tool arguments carry whole SGF files.
+	sc.Buffer(make([]byte, 64*1024), 32*1024*1024)
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	for sc.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		responses, _ := s.HandleMessage(ctx, line)
+		for _, resp := range responses {
+			mu.Lock()
+			err := enc.Encode(resp)
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// HandleMessage handles one JSON-RPC message or batch and returns the
+// responses to send, and whether they are sent as an array. Notifications
+// and responses from the client produce none.
+func (s *Server) HandleMessage(ctx context.Context, msg []byte) (responses []*response, batch bool) {
+	var msgs []json.RawMessage
+	if len(msg) > 0 && msg[0] == '[' {
+		if err := json.Unmarshal(msg, &msgs); err != nil {
+			return []*response{errorResponse(nil, codeParseError, err.Error())}, false
+		}
+		// An empty batch gets a single error, not an empty array.
+		if len(msgs) == 0 {
+			return []*response{errorResponse(nil, codeInvalidRequest, "empty batch")}, false
+		}
+		batch = true
+	} else {
+		msgs = []json.RawMessage{msg}
+	}
+
+	for _, raw := range msgs {
+		var req request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			responses = append(responses, errorResponse(nil, codeParseError, err.Error()))
+			continue
+		}
+		// The server sends no requests, so there is nothing to match a
+		// response to.
+		if req.Method == "" && (req.Result != nil || req.Error != nil) {
+			continue
+		}
+		if resp := s.handle(ctx, req); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	return responses, batch
+}
+
+func (s *Server) handle(ctx context.Context, req request) *response {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, codeInvalidRequest, "invalid request")
+	}
+	// Notifications (no id) get no response.
+	if req.ID == nil {
+		return nil
+	}
+
+	result, rerr := s.dispatch(ctx, req)
+	if rerr != nil {
+		return errorResponse(req.ID, rerr.Code, rerr.Message)
+	}
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := protocolVersions[0]
+		for _, v := range protocolVersions {
+			if v == params.ProtocolVersion {
+				version = v
+			}
+		}
+		capabilities := map[string]interface{}{"tools": map[string]interface{}{}}
+		if s.Resources != nil {
+			capabilities["resources"] = map[string]interface{}{}
+		}
+		return map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities":    capabilities,
+			"serverInfo":      map[string]string{"name": s.Name, "version": s.Version},
+		}, nil
+
+	case "ping":
+		return map[string]interface{}{}, nil
+
+	case "tools/list":
+		tools := s.tools
+		if tools == nil {
+			tools = []Tool{}
+		}
+		return map[string]interface{}{"tools": tools}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		for _, t := range s.tools {
+			if t.Name != params.Name {
+				continue
+			}
+			if params.Arguments == nil {
+				params.Arguments = json.RawMessage("{}")
+			}
+			res, err := t.Handler(ctx, params.Arguments)
+			if err != nil {
+				// Tool failures are reported to the model, not as protocol
+				// errors.
+				return ToolResult{Content: []Content{TextContent(err.Error())}, IsError: true}, nil
+			}
+			return res, nil
+		}
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+
+	case "resources/list":
+		if s.Resources == nil {
+			return map[string]interface{}{"resources": []Resource{}}, nil
+		}
+		resources, err := s.Resources.List(ctx)
+		if err != nil {
+			return nil, &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		if resources == nil {
+			resources = []Resource{}
+		}
+		return map[string]interface{}{"resources": resources}, nil
+
+	case "resources/read":
+		var params struct {
+			URI string `json:"uri"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil || s.Resources == nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "invalid resource"}
+		}
+		contents, err := s.Resources.Read(ctx, params.URI)
+		if err != nil {
+			log.Printf("mcp: failed to read %s: %v", params.URI, err)
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return map[string]interface{}{"contents": []ResourceContents{contents}}, nil
+	}
+
+	return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
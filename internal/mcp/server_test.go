@@ -0,0 +1,189 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testServer() *Server {
+	s := NewServer("test", "1.0")
+	s.AddTool(Tool{
+		Name:        "echo",
+		Description: "Echo the text back.",
+		InputSchema: map[string]interface{}{"type": "object"},
+		Handler: func(ctx context.Context, args json.RawMessage) (ToolResult, error) {
+			var in struct{ Text string }
+			if err := json.Unmarshal(args, &in); err != nil {
+				return ToolResult{}, err
+			}
+			if in.Text == "" {
+				return ToolResult{}, errors.New("empty text")
+			}
+			return ToolResult{Content: []Content{TextContent(in.Text)}}, nil
+		},
+	})
+	return s
+}
+
+func TestServeStdio(t *testing.T) {
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"t","version":"0"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"echo","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"nope"}`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := testServer().ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d responses, want 5:\n%s", len(lines), out.String())
+	}
+
+	type rpcResponse struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	var resps []rpcResponse
+	for _, l := range lines {
+		var r rpcResponse
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, r)
+	}
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(resps[0].Result, &init)
+	if init.ProtocolVersion != "2024-11-05" {
+		t.Errorf("negotiated %q, want 2024-11-05", init.ProtocolVersion)
+	}
+
+	var list struct{ Tools []Tool }
+	json.Unmarshal(resps[1].Result, &list)
+	if len(list.Tools) != 1 || list.Tools[0].Name != "echo" {
+		t.Errorf("tools/list = %+v", list.Tools)
+	}
+
+	var call ToolResult
+	json.Unmarshal(resps[2].Result, &call)
+	if call.IsError || len(call.Content) != 1 || call.Content[0].Text != "hi" {
+		t.Errorf("tools/call = %+v", call)
+	}
+
+	var failed ToolResult
+	json.Unmarshal(resps[3].Result, &failed)
+	if !failed.IsError || failed.Content[0].Text != "empty text" {
+		t.Errorf("failing tools/call = %+v", failed)
+	}
+
+	if resps[4].Error == nil || resps[4].Error.Code != codeMethodNotFound {
+		t.Errorf("unknown method = %+v", resps[4])
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	s := testServer()
+	ctx := context.Background()
+
+	resps, batch := s.HandleMessage(ctx, []byte(`[]`))
+	if batch || len(resps) != 1 || resps[0].Error == nil || resps[0].Error.Code != codeInvalidRequest {
+		t.Errorf("empty batch: %+v, batch %v", resps, batch)
+	}
+
+	// Responses from the client are not answered.
+	if resps, _ := s.HandleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":7,"result":{}}`)); len(resps) != 0 {
+		t.Errorf("response: %+v", resps)
+	}
+	resps, batch = s.HandleMessage(ctx, []byte(`[{"jsonrpc":"2.0","id":7,"error":{"code":-1,"message":"no"}},{"jsonrpc":"2.0","id":8,"method":"ping"}]`))
+	if !batch || len(resps) != 1 || string(resps[0].ID) != "8" {
+		t.Errorf("batch with a response: %+v, batch %v", resps, batch)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	ts := httptest.NewServer(testServer())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":"a","method":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r struct {
+		ID     string          `json:"id"`
+		Result json.RawMessage `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&r)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || r.ID != "a" || string(r.Result) != "{}" {
+		t.Errorf("ping: status %d, %+v", resp.StatusCode, r)
+	}
+
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("notification: status %d, want 202", resp.StatusCode)
+	}
+
+	// An empty batch is answered with one error object.
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e struct {
+		Error *rpcError `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&e)
+	resp.Body.Close()
+	if err != nil || e.Error == nil || e.Error.Code != codeInvalidRequest {
+		t.Errorf("empty batch: %v, %+v", err, e)
+	}
+
+	resp, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("response: status %d, want 202", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", resp.StatusCode)
+	}
+
+	for origin, want := range map[string]int{"http://localhost:5173": http.StatusOK, "http://evil.example": http.StatusForbidden} {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("origin %s: status %d, want %d", origin, resp.StatusCode, want)
+		}
+	}
+}
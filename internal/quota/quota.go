@@ -0,0 +1,221 @@
+// Package quota limits how fast and how much each user may analyze.
+//
+// Rates are token buckets per user and across all users; daily quotas
+// count analyses and generated board images per user and UTC day. Counts
+// are kept in memory and start over when the server restarts.
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits configures a Limiter. A zero rate or quota means no limit.
+type Limits struct {
+	// PerUser is how fast one user may start analyses, PerUserBurst how
+	// many at once.
+	PerUser      rate.Limit `json:"perUser"`
+	PerUserBurst int        `json:"perUserBurst"`
+	// Global is how fast all users together may start analyses.
+	Global      rate.Limit `json:"global"`
+	GlobalBurst int        `json:"globalBurst"`
+	// DailyAnalyses and DailyImages are the per-user quotas for a UTC day.
+	DailyAnalyses int `json:"dailyAnalyses"`
+	DailyImages   int `json:"dailyImages"`
+}
+
+// Error reports a refused request and when it is worth trying again.
+type Error struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("quota: %s, retry after %s", e.Reason, e.RetryAfter.Round(time.Second))
+}
+
+// Usage is one user's consumption today.
+type Usage struct {
+	UserID   string `json:"userId"`
+	Analyses int    `json:"analyses"`
+	Images   int    `json:"images"`
+}
+
+type user struct {
+	limiter  *rate.Limiter
+	day      string
+	analyses int
+	images   int
+}
+
+// Limiter enforces Limits. It is safe for concurrent use.
+type Limiter struct {
+	limits Limits
+	global *rate.Limiter
+	// Now is the clock, replaceable in tests.
+	Now func() time.Time
+
+	mu    sync.Mutex
+	day   string
+	users map[string]*user
+}
+
+// New returns a Limiter enforcing limits.
+func New(limits Limits) *Limiter {
+	l := &Limiter{
+		limits: limits,
+		Now:    time.Now,
+		users:  make(map[string]*user),
+	}
+	if limits.Global > 0 {
+		l.global = rate.NewLimiter(limits.Global, max(limits.GlobalBurst, 1))
+	}
+	return l
+}
+
+// Limits returns the configured limits.
+func (l *Limiter) Limits() Limits {
+	return l.limits
+}
+
+// user returns the state of id for today, starting a new day's counts when
+// the date has changed. l.mu must be held.
+func (l *Limiter) user(id string, now time.Time) *user {
+	day := now.UTC().Format(time.DateOnly)
+	if day != l.day {
+		// Yesterday's counts are worthless; drop users with nothing to
+		// remember so the map does not grow forever.
+		for uid, u := range l.users {
+			if u.limiter == nil || u.limiter.TokensAt(now) >= float64(u.limiter.Burst()) {
+				delete(l.users, uid)
+			}
+		}
+		l.day = day
+	}
+	u, ok := l.users[id]
+	if !ok {
+		u = &user{}
+		if l.limits.PerUser > 0 {
+			u.limiter = rate.NewLimiter(l.limits.PerUser, max(l.limits.PerUserBurst, 1))
+		}
+		l.users[id] = u
+	}
+	if u.day != day {
+		u.day, u.analyses, u.images = day, 0, 0
+	}
+	return u
+}
+
+// untilTomorrow is the time left in the UTC day.
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return tomorrow.Sub(now)
+}
+
+// StartAnalysis counts an analysis for a user, or returns an *Error if the
+// user's daily quota, the user's rate or the global rate does not allow
+// one now.
+func (l *Limiter) StartAnalysis(userID string) error {
+	_, err := l.ReserveAnalysis(userID)
+	return err
+}
+
+// Reservation is an analysis counted by ReserveAnalysis.
+type Reservation struct {
+	l         *Limiter
+	userID    string
+	at        time.Time
+	mine, all *rate.Reservation
+}
+
+// ReserveAnalysis is StartAnalysis, returning the analysis so that it can
+// be given back if the request turns out to be invalid.
+func (l *Limiter) ReserveAnalysis(userID string) (*Reservation, error) {
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.user(userID, now)
+	if l.limits.DailyAnalyses > 0 && u.analyses >= l.limits.DailyAnalyses {
+		return nil, &Error{Reason: "daily analysis quota reached", RetryAfter: untilTomorrow(now)}
+	}
+
+	r := &Reservation{l: l, userID: userID, at: now}
+	if u.limiter != nil {
+		r.mine = u.limiter.ReserveN(now, 1)
+		if d := r.mine.DelayFrom(now); d > 0 {
+			r.mine.CancelAt(now)
+			return nil, &Error{Reason: "too many analyses", RetryAfter: d}
+		}
+	}
+	if l.global != nil {
+		r.all = l.global.ReserveN(now, 1)
+		if d := r.all.DelayFrom(now); d > 0 {
+			r.all.CancelAt(now)
+			if r.mine != nil {
+				r.mine.CancelAt(now)
+			}
+			return nil, &Error{Reason: "server is busy", RetryAfter: d}
+		}
+	}
+	u.analyses++
+	return r, nil
+}
+
+// Refund gives a reserved analysis back: it no longer counts against the
+// daily quota, and the rate tokens it took are returned unless later
+// analyses have used them since.
+func (r *Reservation) Refund() {
+	l := r.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Cancelling as of the reservation makes the tokens count as never
+	// taken, however long the request took to be rejected.
+	if r.mine != nil {
+		r.mine.CancelAt(r.at)
+	}
+	if r.all != nil {
+		r.all.CancelAt(r.at)
+	}
+	if u, ok := l.users[r.userID]; ok && u.day == r.at.UTC().Format(time.DateOnly) && u.analyses > 0 {
+		u.analyses--
+	}
+}
+
+// UseImage counts a generated board image for a user, or returns an *Error
+// if the user's daily image quota is used up.
+func (l *Limiter) UseImage(userID string) error {
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.user(userID, now)
+	if l.limits.DailyImages > 0 && u.images >= l.limits.DailyImages {
+		return &Error{Reason: "daily image quota reached", RetryAfter: untilTomorrow(now)}
+	}
+	u.images++
+	return nil
+}
+
+// Usage returns today's consumption of every user who has any, by user ID.
+func (l *Limiter) Usage() []Usage {
+	now := l.Now()
+	day := now.UTC().Format(time.DateOnly)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	usage := []Usage{}
+	for id, u := range l.users {
+		if u.day == day && (u.analyses > 0 || u.images > 0) {
+			usage = append(usage, Usage{UserID: id, Analyses: u.analyses, Images: u.images})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].UserID < usage[j].UserID })
+	return usage
+}
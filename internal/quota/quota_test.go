@@ -0,0 +1,130 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func testLimiter(limits Limits) (*Limiter, *time.Time) {
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	l := New(limits)
+	l.Now = func() time.Time { return now }
+	return l, &now
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	var qe *Error
+	if !errors.As(err, &qe) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	return qe.RetryAfter
+}
+
+func TestPerUserRate(t *testing.T) {
+	l, now := testLimiter(Limits{PerUser: rate.Every(time.Minute), PerUserBurst: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := l.StartAnalysis("alice"); err != nil {
+			t.Fatalf("analysis %d: %v", i, err)
+		}
+	}
+	if d := retryAfter(t, l.StartAnalysis("alice")); d != time.Minute {
+		t.Errorf("retry after %s, want 1m", d)
+	}
+	if err := l.StartAnalysis("bob"); err != nil {
+		t.Errorf("bob limited by alice: %v", err)
+	}
+
+	*now = now.Add(time.Minute)
+	if err := l.StartAnalysis("alice"); err != nil {
+		t.Errorf("after a minute: %v", err)
+	}
+}
+
+func TestGlobalRate(t *testing.T) {
+	l, now := testLimiter(Limits{
+		PerUser: rate.Every(time.Minute), PerUserBurst: 1,
+		Global: rate.Every(10 * time.Second), GlobalBurst: 1,
+	})
+
+	if err := l.StartAnalysis("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if d := retryAfter(t, l.StartAnalysis("bob")); d != 10*time.Second {
+		t.Errorf("retry after %s, want 10s", d)
+	}
+	// The refused request must not have used bob's own allowance.
+	*now = now.Add(10 * time.Second)
+	if err := l.StartAnalysis("bob"); err != nil {
+		t.Errorf("bob after the global wait: %v", err)
+	}
+}
+
+func TestDailyQuotas(t *testing.T) {
+	l, now := testLimiter(Limits{DailyAnalyses: 2, DailyImages: 1})
+
+	l.StartAnalysis("alice")
+	l.StartAnalysis("alice")
+	if d := retryAfter(t, l.StartAnalysis("alice")); d != time.Hour {
+		t.Errorf("retry after %s, want the hour left in the day", d)
+	}
+	if err := l.UseImage("alice"); err != nil {
+		t.Fatal(err)
+	}
+	retryAfter(t, l.UseImage("alice"))
+
+	usage := l.Usage()
+	if len(usage) != 1 || usage[0] != (Usage{UserID: "alice", Analyses: 2, Images: 1}) {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	*now = now.Add(time.Hour)
+	if err := l.StartAnalysis("alice"); err != nil {
+		t.Errorf("next day: %v", err)
+	}
+	if err := l.UseImage("alice"); err != nil {
+		t.Errorf("next day image: %v", err)
+	}
+	if usage := l.Usage(); len(usage) != 1 || usage[0].Analyses != 1 {
+		t.Errorf("usage on the next day = %+v", usage)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	l, _ := testLimiter(Limits{})
+	for i := 0; i < 100; i++ {
+		if err := l.StartAnalysis("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.UseImage("alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRefund(t *testing.T) {
+	l, now := testLimiter(Limits{PerUser: rate.Every(time.Minute), PerUserBurst: 1, DailyAnalyses: 1})
+
+	r, err := l.ReserveAnalysis("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Second)
+	r.Refund()
+	if u := l.Usage(); len(u) != 0 {
+		t.Errorf("usage after refund = %+v", u)
+	}
+	// Neither the rate nor the quota holds the refunded analysis against
+	// alice.
+	if err := l.StartAnalysis("alice"); err != nil {
+		t.Errorf("after refund: %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if d := retryAfter(t, l.StartAnalysis("alice")); d <= time.Minute {
+		t.Errorf("retry after %s, want the daily quota", d)
+	}
+}
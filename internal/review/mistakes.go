@@ -0,0 +1,191 @@
+// Package review rates the moves of a game from engine evaluations and
+// picks out the moves that decided it.
+package review
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/game"
+	"github.com/sweetfish329/sai/internal/katrain"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+type Severity string
+
+const (
+	Good       Severity = "good"
+	Inaccuracy Severity = "inaccuracy"
+	Mistake    Severity = "mistake"
+	Blunder    Severity = "blunder"
+)
+
+// Thresholds are the minimum points lost for each severity.
+type Thresholds struct {
+	Inaccuracy float64 `json:"inaccuracy"`
+	Mistake    float64 `json:"mistake"`
+	Blunder    float64 `json:"blunder"`
+}
+
+var DefaultThresholds = Thresholds{Inaccuracy: 1, Mistake: 3, Blunder: 6}
+
+// ParseThresholds reads thresholds written as "inaccuracy,mistake,blunder",
+// e.g. "1,3,6". They must be positive and increasing.
+func ParseThresholds(s string) (Thresholds, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return Thresholds{}, fmt.Errorf("thresholds %q: want inaccuracy,mistake,blunder", s)
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return Thresholds{}, fmt.Errorf("thresholds %q: %w", s, err)
+		}
+		v[i] = f
+	}
+	if !(0 < v[0] && v[0] < v[1] && v[1] < v[2]) {
+		return Thresholds{}, fmt.Errorf("thresholds %q must be positive and increasing", s)
+	}
+	return Thresholds{Inaccuracy: v[0], Mistake: v[1], Blunder: v[2]}, nil
+}
+
+// Severity classifies a move by the points it lost.
+func (t Thresholds) Severity(pointsLost float64) Severity {
+	switch {
+	case pointsLost >= t.Blunder:
+		return Blunder
+	case pointsLost >= t.Mistake:
+		return Mistake
+	case pointsLost >= t.Inaccuracy:
+		return Inaccuracy
+	}
+	return Good
+}
+
+// MoveEval is the evaluation of one played move. Coordinates are SGF
+// coordinates ("" for pass); losses are from the mover's point of view.
+type MoveEval struct {
+	MoveNumber  int      `json:"moveNumber"`
+	Color       string   `json:"color"`
+	Move        string   `json:"move"`
+	PointsLost  float64  `json:"pointsLost"`
+	WinrateLost float64  `json:"winrateLost"`
+	BestMove    string   `json:"bestMove"`
+	Severity    Severity `json:"severity"`
+}
+
+// Classify sets the severity of every move.
+func Classify(moves []MoveEval, t Thresholds) []MoveEval {
+	out := make([]MoveEval, len(moves))
+	for i, m := range moves {
+		m.Severity = t.Severity(m.PointsLost)
+		out[i] = m
+	}
+	return out
+}
+
+// TurningPoints returns the n moves that lost the most points, worst first.
+// Moves rated good are never included.
+func TurningPoints(moves []MoveEval, n int) []MoveEval {
+	var bad []MoveEval
+	for _, m := range moves {
+		if m.Severity != Good && m.Severity != "" {
+			bad = append(bad, m)
+		}
+	}
+	sort.SliceStable(bad, func(i, j int) bool {
+		if bad[i].PointsLost != bad[j].PointsLost {
+			return bad[i].PointsLost > bad[j].PointsLost
+		}
+		return bad[i].WinrateLost > bad[j].WinrateLost
+	})
+	if len(bad) > n {
+		bad = bad[:n]
+	}
+	return bad
+}
+
+// Counts tallies severities per colour.
+func Counts(moves []MoveEval) map[string]map[Severity]int {
+	counts := map[string]map[Severity]int{"B": {}, "W": {}}
+	for _, m := range moves {
+		if c, ok := counts[m.Color]; ok {
+			c[m.Severity]++
+		}
+	}
+	return counts
+}
+
+// FromKaTrain builds move evaluations from KaTrain's KT analysis. Moves
+// without analysis before and after them are skipped.
+func FromKaTrain(moves []katrain.MoveAnalysis) []MoveEval {
+	var out []MoveEval
+	for _, m := range moves {
+		if m.Before == nil || m.After == nil {
+			continue
+		}
+		sign := mover(m.Color)
+		e := MoveEval{
+			MoveNumber:  m.MoveNumber,
+			Color:       m.Color,
+			Move:        m.Move,
+			PointsLost:  sign * (m.Before.Root.ScoreLead - m.After.Root.ScoreLead),
+			WinrateLost: sign * (m.Before.Root.Winrate - m.After.Root.Winrate),
+		}
+		if len(m.Before.Moves) > 0 {
+			e.BestMove = katrain.GTPToSGF(m.Before.Moves[0].Move, m.Before.Size)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// FromEngine converts engine.EvaluateMoves output for a board of the given
+// size.
+func FromEngine(moves []engine.MoveEvaluation, size int) []MoveEval {
+	toSGF := func(v string) string {
+		x, y, pass, err := game.ParseGTP(v, size)
+		if pass || err != nil {
+			return ""
+		}
+		return game.PointString(x, y)
+	}
+
+	out := make([]MoveEval, 0, len(moves))
+	for _, m := range moves {
+		out = append(out, MoveEval{
+			MoveNumber:  m.MoveNumber,
+			Color:       m.Color,
+			Move:        toSGF(m.Move),
+			PointsLost:  m.PointsLost,
+			WinrateLost: m.WinrateLost,
+			BestMove:    toSGF(m.BestMove),
+		})
+	}
+	return out
+}
+
+// FromSGF reads evaluations from KaTrain data in the game record. It returns
+// an error if the record carries no analysis. Moves next to a node whose
+// analysis cannot be decoded are left out.
+func FromSGF(root *sgf.Node) ([]MoveEval, error) {
+	analysis, _ := katrain.MainLine(root)
+	moves := FromKaTrain(analysis)
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("game record has no engine analysis")
+	}
+	return moves, nil
+}
+
+// mover returns the sign that turns a Black-perspective difference into one
+// for the player who moved.
+func mover(color string) float64 {
+	if color == "W" {
+		return -1
+	}
+	return 1
+}
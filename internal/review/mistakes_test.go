@@ -0,0 +1,78 @@
+package review
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+func TestClassifyAndTurningPoints(t *testing.T) {
+	moves := Classify([]MoveEval{
+		{MoveNumber: 1, Color: "B", PointsLost: 0.2},
+		{MoveNumber: 2, Color: "W", PointsLost: 7},
+		{MoveNumber: 3, Color: "B", PointsLost: 1.5},
+		{MoveNumber: 4, Color: "W", PointsLost: 3, WinrateLost: 0.2},
+		{MoveNumber: 5, Color: "B", PointsLost: 3, WinrateLost: 0.1},
+	}, DefaultThresholds)
+
+	expected := []Severity{Good, Blunder, Inaccuracy, Mistake, Mistake}
+	for i, m := range moves {
+		if m.Severity != expected[i] {
+			t.Errorf("move %d: expected %s, got %s", m.MoveNumber, expected[i], m.Severity)
+		}
+	}
+
+	top := TurningPoints(moves, 3)
+	if len(top) != 3 || top[0].MoveNumber != 2 || top[1].MoveNumber != 4 || top[2].MoveNumber != 5 {
+		t.Errorf("unexpected turning points: %+v", top)
+	}
+
+	counts := Counts(moves)
+	if counts["B"][Mistake] != 1 || counts["W"][Blunder] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	th, err := ParseThresholds(" 0.5, 2,5 ")
+	if err != nil || th != (Thresholds{Inaccuracy: 0.5, Mistake: 2, Blunder: 5}) {
+		t.Errorf("ParseThresholds = %+v, %v", th, err)
+	}
+	for _, bad := range []string{"", "1,3", "1,x,6", "3,1,6", "0,3,6"} {
+		if _, err := ParseThresholds(bad); err == nil {
+			t.Errorf("ParseThresholds(%q) accepted", bad)
+		}
+	}
+}
+
+func TestFromSGFSample(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	roots, err := sgf.Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	moves, err := FromSGF(roots[0])
+	if err != nil {
+		t.Fatalf("FromSGF error: %v", err)
+	}
+	if moves[0].MoveNumber != 1 || moves[0].Color != "B" || len(moves[0].BestMove) != 2 {
+		t.Errorf("unexpected first move: %+v", moves[0])
+	}
+
+	if _, err := FromSGF(&sgf.Node{}); err == nil {
+		t.Error("expected an error for a record without analysis")
+	}
+}
+
+func TestFromEngine(t *testing.T) {
+	moves := FromEngine([]engine.MoveEvaluation{{MoveNumber: 1, Color: "B", Move: "E5", BestMove: "pass", PointsLost: 2}}, 9)
+	if moves[0].Move != "ee" || moves[0].BestMove != "" {
+		t.Errorf("unexpected conversion: %+v", moves[0])
+	}
+}
@@ -0,0 +1,176 @@
+package sgf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Move quality values understood by Annotate. They map to the SGF move
+// annotation properties TE, BM, DO and IT.
+const (
+	QualityGood        = "good"
+	QualityBad         = "bad"
+	QualityDoubtful    = "doubtful"
+	QualityInteresting = "interesting"
+)
+
+// Annotation is review feedback for one move of the main line.
+type Annotation struct {
+	// MoveNumber is 1-based; 0 annotates the root node (the whole game).
+	MoveNumber int    `json:"moveNumber"`
+	Comment    string `json:"comment,omitempty"`
+	Quality    string `json:"quality,omitempty"`
+	// Emphasis marks a very good (TE[2]) or very bad (BM[2]) move.
+	Emphasis bool `json:"emphasis,omitempty"`
+	// Variation is a sequence of moves in SGF coordinates suggested instead
+	// of this move. It starts with the colour of the annotated move.
+	Variation []string `json:"variation,omitempty"`
+	Marks     []Mark   `json:"marks,omitempty"`
+}
+
+// Mark is board markup shown at a move: TR, SQ, CR, MA or LB.
+type Mark struct {
+	Type  string `json:"type"`
+	Point string `json:"point"`
+	Label string `json:"label,omitempty"`
+}
+
+// MainLine returns the move nodes (nodes with a B or W property) of the main
+// line below root, so MainLine(root)[n-1] is move n. Passes count as moves.
+func MainLine(root *Node) []*Node {
+	var moves []*Node
+	for node := root; len(node.Children) > 0; {
+		node = node.Children[0]
+		if MoveColor(node) != "" {
+			moves = append(moves, node)
+		}
+	}
+	return moves
+}
+
+// MoveColor returns "B" or "W" for a move node and "" otherwise.
+func MoveColor(node *Node) string {
+	if _, ok := node.Properties["B"]; ok {
+		return "B"
+	}
+	if _, ok := node.Properties["W"]; ok {
+		return "W"
+	}
+	return ""
+}
+
+// Annotate writes annotations into the game rooted at root: comments go to
+// C[], quality to TE/BM/DO/IT, marks to TR/SQ/CR/MA/LB and suggested moves
+// become a new variation next to the annotated move. Invalid annotations
+// are skipped and reported together in the returned error.
+func Annotate(root *Node, annotations []Annotation) error {
+	moves := MainLine(root)
+
+	var errs []error
+	for _, a := range annotations {
+		if err := validateAnnotation(a, len(moves)); err != nil {
+			errs = append(errs, fmt.Errorf("move %d: %w", a.MoveNumber, err))
+			continue
+		}
+
+		node := root
+		if a.MoveNumber > 0 {
+			node = moves[a.MoveNumber-1]
+		}
+
+		if a.Comment != "" {
+			comment := a.Comment
+			if existing := node.Get("C"); existing != "" {
+				comment = existing + "\n\n" + comment
+			}
+			node.Set("C", comment)
+		}
+
+		if a.Quality != "" {
+			// A node may carry only one of the move annotation properties.
+			for _, key := range []string{"TE", "BM", "DO", "IT"} {
+				node.Delete(key)
+			}
+			emphasis := "1"
+			if a.Emphasis {
+				emphasis = "2"
+			}
+			switch a.Quality {
+			case QualityGood:
+				node.Set("TE", emphasis)
+			case QualityBad:
+				node.Set("BM", emphasis)
+			case QualityDoubtful:
+				node.Set("DO", "")
+			case QualityInteresting:
+				node.Set("IT", "")
+			}
+		}
+
+		for _, m := range a.Marks {
+			if m.Type == "LB" {
+				node.Add("LB", m.Point+":"+m.Label)
+			} else {
+				node.Add(m.Type, m.Point)
+			}
+		}
+
+		if len(a.Variation) > 0 {
+			color := MoveColor(node)
+			parent := node.Parent
+			for _, mv := range a.Variation {
+				child := &Node{}
+				child.Set(color, mv)
+				parent.AddChild(child)
+				parent = child
+				color = opponent(color)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateAnnotation(a Annotation, moveCount int) error {
+	if a.MoveNumber < 0 || a.MoveNumber > moveCount {
+		return fmt.Errorf("game has %d moves", moveCount)
+	}
+	switch a.Quality {
+	case "", QualityGood, QualityBad, QualityDoubtful, QualityInteresting:
+	default:
+		return fmt.Errorf("unknown quality %q", a.Quality)
+	}
+	for _, m := range a.Marks {
+		switch m.Type {
+		case "TR", "SQ", "CR", "MA", "LB":
+		default:
+			return fmt.Errorf("unknown mark type %q", m.Type)
+		}
+		if !isPoint(m.Point) {
+			return fmt.Errorf("invalid mark point %q", m.Point)
+		}
+	}
+	if len(a.Variation) > 0 {
+		if a.MoveNumber == 0 {
+			return fmt.Errorf("a variation needs a move to branch from")
+		}
+		for _, mv := range a.Variation {
+			// An empty value is a pass.
+			if mv != "" && !isPoint(mv) {
+				return fmt.Errorf("invalid variation move %q", mv)
+			}
+		}
+	}
+	return nil
+}
+
+func isPoint(p string) bool {
+	return len(p) == 2 && isLetter(p[0]) && isLetter(p[1])
+}
+
+func opponent(color string) string {
+	if color == "B" {
+		return "W"
+	}
+	return "B"
+}
@@ -0,0 +1,57 @@
+package sgf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	roots, err := Parse("(;SZ[9];B[ee]C[engine];W[ce];B[gc])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	root := roots[0]
+
+	err = Annotate(root, []Annotation{
+		{MoveNumber: 0, Comment: "summary"},
+		{MoveNumber: 1, Comment: "good start", Quality: QualityGood},
+		{
+			MoveNumber: 2,
+			Quality:    QualityBad,
+			Emphasis:   true,
+			Variation:  []string{"gc", "cg"},
+			Marks:      []Mark{{Type: "TR", Point: "gc"}, {Type: "LB", Point: "cg", Label: "A"}},
+		},
+		{MoveNumber: 9, Comment: "out of range"},
+		{MoveNumber: 3, Marks: []Mark{{Type: "XX", Point: "aa"}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "move 9") || !strings.Contains(err.Error(), "XX") {
+		t.Errorf("Expected errors for invalid annotations, got %v", err)
+	}
+
+	moves := MainLine(root)
+	if root.Get("C") != "summary" {
+		t.Errorf("Root comment not set: %q", root.Get("C"))
+	}
+	if moves[0].Get("C") != "engine\n\ngood start" || moves[0].Get("TE") != "1" {
+		t.Errorf("Move 1 not annotated: %v", moves[0].Properties)
+	}
+	if moves[1].Get("BM") != "2" || moves[1].Get("TR") != "gc" || moves[1].Get("LB") != "cg:A" {
+		t.Errorf("Move 2 not annotated: %v", moves[1].Properties)
+	}
+
+	// The suggestion branches from move 1, next to the actual move 2.
+	if len(moves[0].Children) != 2 {
+		t.Fatalf("Expected variation after move 1, got %d children", len(moves[0].Children))
+	}
+	v := moves[0].Children[1]
+	if v.Get("W") != "gc" || v.Children[0].Get("B") != "cg" {
+		t.Errorf("Variation has wrong moves or colours")
+	}
+
+	// The annotated tree is still valid SGF.
+	out, _ := Marshal(roots)
+	if _, err := Parse(string(out)); err != nil {
+		t.Errorf("Annotated SGF does not parse: %v", err)
+	}
+}
@@ -2,13 +2,21 @@ package sgf
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // Node represents a node in the SGF tree.
 type Node struct {
 	Properties map[string][]string
 	Children   []*Node
+	// Parent is nil for the root node of a game tree.
+	Parent *Node
+
+	// order remembers the sequence in which properties were added so the
+	// writer can reproduce it.
+	order []string
 }
 
 // Helper to get a single property value
@@ -19,128 +27,288 @@ func (n *Node) Get(key string) string {
 	return ""
 }
 
-// Parse parses a SGF string and returns the root nodes.
-// This is a simplified parser.
+// Set replaces the values of a property, keeping its original position if it
+// already exists.
+func (n *Node) Set(key string, values ...string) {
+	if n.Properties == nil {
+		n.Properties = make(map[string][]string)
+	}
+	if _, ok := n.Properties[key]; !ok {
+		n.order = append(n.order, key)
+	}
+	n.Properties[key] = values
+}
+
+// Add appends values to a property, creating it if needed.
+func (n *Node) Add(key string, values ...string) {
+	n.Set(key, append(n.Properties[key], values...)...)
+}
+
+// Delete removes a property from the node.
+func (n *Node) Delete(key string) {
+	delete(n.Properties, key)
+	for i, k := range n.order {
+		if k == key {
+			n.order = append(n.order[:i], n.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// AddChild appends child as the last variation of n.
+func (n *Node) AddChild(child *Node) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+// Keys returns the property identifiers in the order they were added.
+// Properties inserted directly into the map are returned last, sorted.
+func (n *Node) Keys() []string {
+	keys := make([]string, 0, len(n.Properties))
+	seen := make(map[string]bool, len(n.Properties))
+	for _, k := range n.order {
+		if _, ok := n.Properties[k]; ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	var rest []string
+	for k := range n.Properties {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// ParseError describes malformed SGF input. Line and Column are 1-based and
+// point at the offending character.
+type ParseError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("sgf: line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Parse parses an SGF (FF[4]) collection and returns the root node of every
+// game tree in it. Variations are kept as additional children in the order
+// they appear in the file, so Children[0] is always the main line.
 func Parse(content string) ([]*Node, error) {
-	content = strings.TrimSpace(content)
-	if content == "" {
+	content = strings.TrimPrefix(content, "\ufeff")
+	if strings.TrimSpace(content) == "" {
 		return nil, fmt.Errorf("empty sgf content")
 	}
 
+	p := &parser{src: content, line: 1, col: 1}
+
 	var roots []*Node
-	var stack []*Node
-	var current *Node
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		if p.peek() != '(' {
+			return nil, p.errorf("expected '(' at start of game tree, got %q", p.peek())
+		}
+		root, err := p.parseGameTree(nil)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+
+	return roots, nil
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	return p.src[p.pos]
+}
+
+// next consumes one byte and keeps line/column in sync. Columns count runes,
+// so multi-byte characters in comments do not skew error positions.
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+		p.col = 1
+	} else if utf8.RuneStart(c) {
+		p.col++
+	}
+	return c
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			p.next()
+		default:
+			return
+		}
+	}
+}
 
-	// Simple state machine
-	i := 0
-	length := len(content)
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &ParseError{Line: p.line, Column: p.col, Msg: fmt.Sprintf(format, args...)}
+}
 
-	for i < length {
-		char := content[i]
+// parseGameTree parses "(" Sequence GameTree* ")" and attaches the first node
+// of the sequence to parent. It returns that first node.
+func (p *parser) parseGameTree(parent *Node) (*Node, error) {
+	p.next() // '('
+	p.skipSpace()
+	if p.eof() || p.peek() != ';' {
+		if p.eof() {
+			return nil, p.errorf("unexpected end of input, expected ';'")
+		}
+		return nil, p.errorf("expected ';' at start of sequence, got %q", p.peek())
+	}
+
+	var first *Node
+	last := parent
+	for !p.eof() && p.peek() == ';' {
+		node, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			last.AddChild(node)
+		}
+		if first == nil {
+			first = node
+		}
+		last = node
+		p.skipSpace()
+	}
 
-		switch char {
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unexpected end of input, missing ')'")
+		}
+		switch p.peek() {
 		case '(':
-			// Start of a variation (or game)
-			// If we are currently in a node, push it to stack to attach children
-			if current != nil {
-				stack = append(stack, current)
+			if _, err := p.parseGameTree(last); err != nil {
+				return nil, err
 			}
-			i++
 		case ')':
-			// End of variation
-			if len(stack) > 0 {
-				// We traverse up specifically to the branching point.
-				// However, standard SGF structure is ( ; ... ; ... )
-				// The stack logic here depends on how we treat variations.
-				// Usually, '(' pushes context, ')' pops it.
-				// In this simplified parser, we might just handle the main line if we aren't careful.
-				// Let's refine:
-				// If stack has items, pop one to return to parent scope
-				current = stack[len(stack)-1]
-				stack = stack[:len(stack)-1]
-			} else {
-				// End of root variation
-				current = nil
-			}
-			i++
-		case ';':
-			// New Node
-			newNode := &Node{
-				Properties: make(map[string][]string),
-				Children:   make([]*Node, 0),
+			p.next()
+			return first, nil
+		default:
+			return nil, p.errorf("unexpected %q in game tree", p.peek())
+		}
+	}
+}
+
+// parseNode parses ";" Property*.
+func (p *parser) parseNode() (*Node, error) {
+	p.next() // ';'
+	node := &Node{
+		Properties: make(map[string][]string),
+		Children:   make([]*Node, 0),
+	}
+
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return node, nil
+		}
+		c := p.peek()
+		if c == ';' || c == '(' || c == ')' {
+			return node, nil
+		}
+		if !isLetter(c) {
+			return nil, p.errorf("unexpected %q in node, expected property identifier", c)
+		}
+
+		// FF[4] identifiers are upper case only. Older files use long names
+		// such as "AddBlack"; the lower case letters are ignored as the spec
+		// recommends.
+		line, col := p.line, p.col
+		var key strings.Builder
+		for !p.eof() && isLetter(p.peek()) {
+			if c := p.next(); c >= 'A' && c <= 'Z' {
+				key.WriteByte(c)
 			}
+		}
+		if key.Len() == 0 {
+			return nil, &ParseError{Line: line, Column: col, Msg: "property identifier has no upper case letters"}
+		}
 
-			if current != nil {
-				current.Children = append(current.Children, newNode)
-			} else {
-				// If stack is empty and current is nil, this is a root node
-				roots = append(roots, newNode)
+		p.skipSpace()
+		if p.eof() || p.peek() != '[' {
+			return nil, p.errorf("property %s has no value", key.String())
+		}
+
+		var values []string
+		for {
+			p.skipSpace()
+			if p.eof() || p.peek() != '[' {
+				break
 			}
-			current = newNode
-			i++
-
-			// Parse properties
-			for i < length {
-				// Skip whitespace
-				for i < length && (content[i] == ' ' || content[i] == '\n' || content[i] == '\r' || content[i] == '\t') {
-					i++
-				}
-				if i >= length {
-					break
-				}
+			val, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+		}
 
-				if content[i] == ';' || content[i] == '(' || content[i] == ')' {
-					break
-				}
+		node.Add(key.String(), values...)
+	}
+}
 
-				// Property Key
-				start := i
-				for i < length && content[i] >= 'A' && content[i] <= 'Z' {
-					i++
-				}
-				key := content[start:i]
-
-				// Property Values
-				var values []string
-				for i < length {
-					// Skip whitespace
-					for i < length && (content[i] == ' ' || content[i] == '\n' || content[i] == '\r' || content[i] == '\t') {
-						i++
-					}
-
-					if i < length && content[i] == '[' {
-						i++ // skip '['
-						valStart := i
-						// Find closing ']', handling escaped characters
-						for i < length {
-							if content[i] == ']' && (i == 0 || content[i-1] != '\\') {
-								break
-							}
-							i++
-						}
-						val := content[valStart:i]
-						// Unescape
-						val = strings.ReplaceAll(val, "\\]", "]")
-						val = strings.ReplaceAll(val, "\\\\", "\\")
-						values = append(values, val)
-						i++ // skip ']'
-					} else {
-						break
-					}
-				}
+// parseValue parses "[" CValueType "]" and applies the FF[4] escaping rules:
+// a backslash escapes the next character, and a backslash followed by a line
+// break is a soft line break that is removed entirely.
+func (p *parser) parseValue() (string, error) {
+	line, col := p.line, p.col
+	p.next() // '['
 
-				if key != "" {
-					newNode.Properties[key] = values
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.next()
+		switch c {
+		case ']':
+			return sb.String(), nil
+		case '\\':
+			if p.eof() {
+				break
+			}
+			e := p.next()
+			if e == '\n' || e == '\r' {
+				// Soft line break: swallow the pair "\r\n" or "\n\r" as one.
+				if !p.eof() && (p.peek() == '\n' || p.peek() == '\r') && p.peek() != e {
+					p.next()
 				}
+				continue
 			}
-
+			sb.WriteByte(e)
 		default:
-			// Ignore other chars (whitespace outside nodes)
-			i++
+			sb.WriteByte(c)
 		}
 	}
 
-	return roots, nil
+	return "", &ParseError{Line: line, Column: col, Msg: "unterminated property value"}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
 }
 
 type GameInfo struct {
@@ -148,6 +316,7 @@ type GameInfo struct {
 	WhitePlayer string `json:"whitePlayer"`
 	Result      string `json:"result"`
 	Komi        string `json:"komi"`
+	Date        string `json:"date"`
 	Size        string `json:"size"`
 	Handicap    string `json:"handicap"`
 	Comment     string `json:"comment"`
@@ -172,6 +341,7 @@ func ExtractGameData(rootNode *Node) GameData {
 		WhitePlayer: rootNode.Get("PW"),
 		Result:      rootNode.Get("RE"),
 		Komi:        rootNode.Get("KM"),
+		Date:        rootNode.Get("DT"),
 		Size:        rootNode.Get("SZ"),
 		Handicap:    rootNode.Get("HA"),
 		Comment:     rootNode.Get("C"),
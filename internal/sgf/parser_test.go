@@ -0,0 +1,98 @@
+package sgf
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestParseVariations(t *testing.T) {
+	content := "(;SZ[9];B[aa](;W[bb];B[cc](;W[dd])(;W[ee]))(;W[ff]))"
+
+	roots, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(roots) != 1 {
+		t.Fatalf("Expected 1 game tree, got %d", len(roots))
+	}
+
+	b1 := roots[0].Children[0]
+	if b1.Get("B") != "aa" || b1.Parent != roots[0] {
+		t.Fatalf("Unexpected first move node: %+v", b1.Properties)
+	}
+	if len(b1.Children) != 2 {
+		t.Fatalf("Expected 2 variations after B[aa], got %d", len(b1.Children))
+	}
+	if b1.Children[0].Get("W") != "bb" || b1.Children[1].Get("W") != "ff" {
+		t.Errorf("Variation order not kept: %s, %s", b1.Children[0].Get("W"), b1.Children[1].Get("W"))
+	}
+
+	b3 := b1.Children[0].Children[0]
+	if len(b3.Children) != 2 || b3.Children[1].Get("W") != "ee" {
+		t.Fatalf("Nested variation not attached to B[cc]")
+	}
+	if b3.Children[1].Parent != b3 {
+		t.Errorf("Nested variation has wrong parent")
+	}
+}
+
+func TestParseCollection(t *testing.T) {
+	roots, err := Parse("(;GN[one];B[aa])\n(;GN[two];W[bb])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(roots) != 2 {
+		t.Fatalf("Expected 2 game trees, got %d", len(roots))
+	}
+	if roots[1].Get("GN") != "two" || roots[1].Parent != nil {
+		t.Errorf("Second game tree parsed incorrectly")
+	}
+}
+
+func TestParseEscapes(t *testing.T) {
+	roots, err := Parse("(;C[a\\]b\\\\c\\:d soft\\\nbreak\nhard])")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	expected := "a]b\\c:d softbreak\nhard"
+	if got := roots[0].Get("C"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := Parse("(;GM[1]\n;B[aa]W)")
+
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Expected *ParseError, got %v", err)
+	}
+	if perr.Line != 2 || perr.Column != 8 {
+		t.Errorf("Expected error at 2:8, got %d:%d (%s)", perr.Line, perr.Column, perr.Msg)
+	}
+
+	if _, err := Parse("(;C[unterminated"); !errors.As(err, &perr) {
+		t.Errorf("Expected *ParseError for unterminated value, got %v", err)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+
+	roots, err := Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	gameData := ExtractGameData(roots[0])
+	if gameData.GameInfo.Result != "B+0.5" {
+		t.Errorf("Expected result B+0.5, got %s", gameData.GameInfo.Result)
+	}
+	if gameData.MovesCount == 0 {
+		t.Errorf("Expected moves in sample game")
+	}
+}
@@ -0,0 +1,111 @@
+package sgf
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Marshal returns the compact SGF text of a collection of game trees.
+func Marshal(roots []*Node) ([]byte, error) {
+	return MarshalIndent(roots, "")
+}
+
+// MarshalIndent is like Marshal but puts every node on its own line and
+// indents variations by indent per level. An empty indent gives the compact
+// layout.
+func MarshalIndent(roots []*Node, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, roots, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write serializes the game trees rooted at roots to w. Properties are
+// written in the order they were parsed or added, and values are escaped so
+// that Parse returns the same tree.
+func Write(w io.Writer, roots []*Node, indent string) error {
+	bw := bufio.NewWriter(w)
+	sw := &writer{w: bw, indent: indent}
+	for i, root := range roots {
+		if i > 0 && sw.pretty() {
+			bw.WriteByte('\n')
+		}
+		sw.writeGameTree(root, 0)
+	}
+	if sw.pretty() {
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+type writer struct {
+	w      *bufio.Writer
+	indent string
+}
+
+func (sw *writer) pretty() bool {
+	return sw.indent != ""
+}
+
+func (sw *writer) newline(depth int) {
+	if !sw.pretty() {
+		return
+	}
+	sw.w.WriteByte('\n')
+	sw.w.WriteString(strings.Repeat(sw.indent, depth))
+}
+
+// writeGameTree writes the sequence starting at node up to the next branch
+// point, followed by one parenthesized game tree per variation.
+func (sw *writer) writeGameTree(node *Node, depth int) {
+	sw.w.WriteByte('(')
+	for {
+		sw.writeNode(node)
+		if len(node.Children) != 1 {
+			break
+		}
+		node = node.Children[0]
+		sw.newline(depth)
+	}
+	for _, child := range node.Children {
+		sw.newline(depth + 1)
+		sw.writeGameTree(child, depth+1)
+	}
+	sw.w.WriteByte(')')
+}
+
+func (sw *writer) writeNode(node *Node) {
+	sw.w.WriteByte(';')
+	for _, key := range node.Keys() {
+		values := node.Properties[key]
+		// A property without values cannot be expressed in SGF.
+		if len(values) == 0 {
+			continue
+		}
+		sw.w.WriteString(key)
+		for _, v := range values {
+			sw.w.WriteByte('[')
+			sw.w.WriteString(EscapeValue(v))
+			sw.w.WriteByte(']')
+		}
+	}
+}
+
+// EscapeValue escapes the characters that are special inside an SGF
+// property value.
+func EscapeValue(v string) string {
+	if !strings.ContainsAny(v, `]\`) {
+		return v
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == ']' || v[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(v[i])
+	}
+	return sb.String()
+}
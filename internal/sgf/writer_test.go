@@ -0,0 +1,117 @@
+package sgf
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// equalTrees compares two trees by properties, property order and shape.
+func equalTrees(t *testing.T, a, b *Node, path string) {
+	t.Helper()
+	if !reflect.DeepEqual(a.Keys(), b.Keys()) {
+		t.Fatalf("%s: property order differs: %v vs %v", path, a.Keys(), b.Keys())
+	}
+	for _, k := range a.Keys() {
+		if !reflect.DeepEqual(a.Properties[k], b.Properties[k]) {
+			t.Fatalf("%s: property %s differs: %q vs %q", path, k, a.Properties[k], b.Properties[k])
+		}
+	}
+	if len(a.Children) != len(b.Children) {
+		t.Fatalf("%s: child count differs: %d vs %d", path, len(a.Children), len(b.Children))
+	}
+	for i := range a.Children {
+		if b.Children[i].Parent != b {
+			t.Fatalf("%s: child %d has wrong parent", path, i)
+		}
+		equalTrees(t, a.Children[i], b.Children[i], fmt.Sprintf("%s/%d", path, i))
+	}
+}
+
+func roundTrip(t *testing.T, roots []*Node, indent string) {
+	t.Helper()
+	out, err := MarshalIndent(roots, indent)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	again, err := Parse(string(out))
+	if err != nil {
+		t.Fatalf("Parse of written SGF failed: %v\n%s", err, out)
+	}
+	if len(again) != len(roots) {
+		t.Fatalf("Expected %d game trees, got %d", len(roots), len(again))
+	}
+	for i := range roots {
+		equalTrees(t, roots[i], again[i], "root")
+	}
+}
+
+func TestWriteRoundTripSample(t *testing.T) {
+	content, err := os.ReadFile("../../sample/sample.sgf")
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	roots, err := Parse(string(content))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	roundTrip(t, roots, "")
+	roundTrip(t, roots, "  ")
+}
+
+func TestWriteEscapes(t *testing.T) {
+	root := &Node{}
+	root.Set("C", `a]b\c`)
+	root.Set("GN", "x")
+
+	out, _ := Marshal([]*Node{root})
+	if expected := `(;C[a\]b\\c]GN[x])`; string(out) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+}
+
+// TestWriteRoundTripRandom checks Parse(Marshal(tree)) == tree for randomly
+// generated trees whose values are full of characters that need escaping.
+func TestWriteRoundTripRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []rune("ab]\\[();: \n\r\t碁")
+	keys := []string{"B", "W", "C", "LB", "AB", "GN", "KT"}
+
+	randValue := func() string {
+		r := make([]rune, rng.Intn(8))
+		for i := range r {
+			r[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return string(r)
+	}
+
+	var randNode func(depth int) *Node
+	randNode = func(depth int) *Node {
+		n := &Node{}
+		for _, i := range rng.Perm(len(keys))[:rng.Intn(4)] {
+			values := make([]string, 1+rng.Intn(3))
+			for j := range values {
+				values[j] = randValue()
+			}
+			n.Set(keys[i], values...)
+		}
+		if depth < 5 {
+			for i := rng.Intn(3); i > 0; i-- {
+				n.AddChild(randNode(depth + 1))
+			}
+		}
+		return n
+	}
+
+	for i := 0; i < 200; i++ {
+		roots := []*Node{randNode(0)}
+		if rng.Intn(3) == 0 {
+			roots = append(roots, randNode(0))
+		}
+		roundTrip(t, roots, "")
+		roundTrip(t, roots, "\t")
+	}
+}
@@ -0,0 +1,22 @@
+package tools
+
+import (
+	"encoding/json"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// DefineGenkit registers the tools with Genkit, so that Genkit flows and the
+// developer UI can use them.
+func (r *Registry) DefineGenkit(g *genkit.Genkit) {
+	for _, t := range r.Tools() {
+		genkit.DefineToolWithInputSchema(g, t.Name, t.Description, t.InputSchema, func(tc *ai.ToolContext, in any) (any, error) {
+			args, err := json.Marshal(in)
+			if err != nil {
+				return nil, err
+			}
+			return t.Call(tc, args)
+		})
+	}
+}
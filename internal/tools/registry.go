@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/invopop/jsonschema"
+)
+
+// ErrUnknownTool is returned by Registry.Call for names it does not hold.
+var ErrUnknownTool = errors.New("unknown tool")
+
+// Tool is a coaching tool: a name and description for the model, the JSON
+// schema of its input and a handler. Define tools with New; the schema is
+// generated from the input struct, whose fields describe themselves with
+// json and jsonschema_description tags. Fields without omitempty are
+// required.
+type Tool struct {
+	Name        string
+	Description string
+	// InputSchema is the JSON schema of the input object.
+	InputSchema map[string]interface{}
+	// Available, when set, reports whether the tool can be offered to a
+	// model now, e.g. only while an engine is configured.
+	Available func() bool
+
+	required []string
+	call     func(ctx context.Context, args json.RawMessage) (interface{}, error)
+}
+
+// New defines a tool whose input is decoded into In.
+func New[In, Out any](name, description string, fn func(ctx context.Context, in In) (Out, error)) *Tool {
+	r := &jsonschema.Reflector{DoNotReference: true, Anonymous: true}
+	s := r.ReflectFromType(reflect.TypeFor[In]())
+	s.Version = ""
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(fmt.Sprintf("tools: schema of %s: %v", name, err))
+	}
+	var schema map[string]interface{}
+	json.Unmarshal(b, &schema)
+
+	return &Tool{
+		Name:        name,
+		Description: description,
+		InputSchema: schema,
+		required:    s.Required,
+		call: func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+			var in In
+			if err := json.Unmarshal(args, &in); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			return fn(ctx, in)
+		},
+	}
+}
+
+// Call decodes args and runs the tool.
+func (t *Tool) Call(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(args, &present); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	for _, name := range t.required {
+		if _, ok := present[name]; !ok {
+			return nil, fmt.Errorf("invalid arguments: missing %s", name)
+		}
+	}
+	return t.call(ctx, args)
+}
+
+// Registry is a set of tools, kept in the order they were added.
+type Registry struct {
+	mu     sync.RWMutex
+	tools  []*Tool
+	byName map[string]*Tool
+}
+
+// NewRegistry returns a registry holding tools.
+func NewRegistry(tools ...*Tool) *Registry {
+	r := &Registry{byName: make(map[string]*Tool)}
+	for _, t := range tools {
+		r.Register(t)
+	}
+	return r
+}
+
+// Register adds a tool, replacing any tool of the same name.
+func (r *Registry) Register(t *Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.byName[t.Name]; ok {
+		for i, o := range r.tools {
+			if o == old {
+				r.tools[i] = t
+			}
+		}
+	} else {
+		r.tools = append(r.tools, t)
+	}
+	r.byName[t.Name] = t
+}
+
+// Tools returns the registered tools.
+func (r *Registry) Tools() []*Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Tool(nil), r.tools...)
+}
+
+// Available returns the registered tools that can be offered now.
+func (r *Registry) Available() []*Tool {
+	var out []*Tool
+	for _, t := range r.Tools() {
+		if t.Available == nil || t.Available() {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Lookup returns the tool called name.
+func (r *Registry) Lookup(name string) (*Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// Call runs the tool called name.
+func (r *Registry) Call(ctx context.Context, name string, args json.RawMessage) (interface{}, error) {
+	t, ok := r.Lookup(name)
+	if !ok {
+		return nil, ErrUnknownTool
+	}
+	return t.Call(ctx, args)
+}
@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/game"
+)
+
+type echoInput struct {
+	Text  string `json:"text" jsonschema_description:"What to echo"`
+	Times int    `json:"times,omitempty"`
+}
+
+func TestRegistry(t *testing.T) {
+	echo := New("echo", "Echo text.", func(ctx context.Context, in echoInput) (string, error) {
+		return strings.Repeat(in.Text, max(in.Times, 1)), nil
+	})
+	if got := echo.InputSchema["required"]; !reflect.DeepEqual(got, []interface{}{"text"}) {
+		t.Errorf("required = %v", got)
+	}
+	props := echo.InputSchema["properties"].(map[string]interface{})
+	if text := props["text"].(map[string]interface{}); text["type"] != "string" || text["description"] != "What to echo" {
+		t.Errorf("text schema = %v", text)
+	}
+	if times := props["times"].(map[string]interface{}); times["type"] != "integer" {
+		t.Errorf("times schema = %v", times)
+	}
+
+	hidden := New("hidden", "Not offered.", func(ctx context.Context, in struct{}) (int, error) { return 1, nil })
+	hidden.Available = func() bool { return false }
+
+	reg := NewRegistry(echo, hidden)
+	if n := len(reg.Tools()); n != 2 {
+		t.Errorf("%d tools, want 2", n)
+	}
+	if a := reg.Available(); len(a) != 1 || a[0] != echo {
+		t.Errorf("available = %v", a)
+	}
+
+	ctx := context.Background()
+	if out, err := reg.Call(ctx, "echo", json.RawMessage(`{"text":"ab","times":2}`)); err != nil || out != "abab" {
+		t.Errorf("Call = %v, %v", out, err)
+	}
+	if _, err := reg.Call(ctx, "echo", json.RawMessage(`{"times":2}`)); err == nil || !strings.Contains(err.Error(), "missing text") {
+		t.Errorf("missing argument: %v", err)
+	}
+	if _, err := reg.Call(ctx, "echo", json.RawMessage(`{"text":3}`)); err == nil {
+		t.Error("accepted a number for a string")
+	}
+	if _, err := reg.Call(ctx, "nope", nil); !errors.Is(err, ErrUnknownTool) {
+		t.Errorf("unknown tool: %v", err)
+	}
+}
+
+func TestReadSgfCount(t *testing.T) {
+	out, err := ReadSgf("(;SZ[9];B[ee];W[gc])")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, ok := out["finalCount"].(map[string]interface{}); !ok || count["komiAssumed"] != true {
+		t.Errorf("finalCount = %+v", out["finalCount"])
+	}
+
+	// A record that breaks the rules is not counted, and says where.
+	out, err = ReadSgf("(;SZ[9];B[ee];W[ee])")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := out["finalCountError"].(string); !strings.Contains(msg, "move 2") || out["finalCount"] != nil {
+		t.Errorf("illegal record: %+v", out)
+	}
+}
+
+func TestBoardImageTool(t *testing.T) {
+	args := json.RawMessage(`{"sgfContent":"(;SZ[9];B[ee];W[gc])","moveNumber":1}`)
+	out, err := GenerateBoardImageTool.Call(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img := out.(BoardImage).Image; !strings.HasPrefix(img, "data:image/png;base64,") {
+		t.Errorf("image = %.40q", img)
+	}
+
+	refused := errors.New("quota used up")
+	ctx := WithImageGuard(context.Background(), func() error { return refused })
+	if _, err := GenerateBoardImageTool.Call(ctx, args); !errors.Is(err, refused) {
+		t.Errorf("guarded call: %v", err)
+	}
+}
+
+func TestVariationTool(t *testing.T) {
+	// White's stone at bb has one liberty left, at ab.
+	const sgfContent = "(;SZ[9];B[ba];W[bb];B[cb];W[ee];B[bc])"
+	var evaluated engine.Position
+	tool := NewVariationTool(func(ctx context.Context, pos engine.Position) (*engine.Evaluation, error) {
+		evaluated = pos
+		return &engine.Evaluation{BestMove: "D4"}, nil
+	})
+	ctx := context.Background()
+
+	// White passes and Black captures at ab (SGF); White cannot answer at
+	// E5 (GTP), where its stone is.
+	out, err := tool.Call(ctx, json.RawMessage(`{"sgfContent":"`+sgfContent+`","moves":["pass","ab","E5"],"evaluate":true}`))
+	if err == nil || !strings.Contains(err.Error(), "move 3 of the variation") || !errors.Is(err, game.ErrOccupied) {
+		t.Fatalf("replaying on ee: %v, %v", out, err)
+	}
+	out, err = tool.Call(ctx, json.RawMessage(`{"sgfContent":"`+sgfContent+`","moves":["pass","ab","D4"],"evaluate":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := out.(*Variation)
+	want := []VariationMove{
+		{MoveNumber: 6, Color: "W", Point: "pass", Vertex: "pass"},
+		{MoveNumber: 7, Color: "B", Point: "ab", Vertex: "A8", Captured: 1},
+		{MoveNumber: 8, Color: "W", Point: "df", Vertex: "D4"},
+	}
+	if !reflect.DeepEqual(v.Moves, want) {
+		t.Errorf("moves = %+v", v.Moves)
+	}
+	if v.Captures["B"] != 1 || v.Captures["W"] != 0 || v.ToPlay != "B" || v.Board[1] != "X.X......" {
+		t.Errorf("variation = %+v", v)
+	}
+	if !strings.HasPrefix(v.Image, "data:image/png;base64,") || v.Evaluation.BestMove != "D4" {
+		t.Errorf("image %.30q, evaluation %+v", v.Image, v.Evaluation)
+	}
+	if n := len(evaluated.Moves); n != 8 || evaluated.Moves[7].Vertex != "D4" || evaluated.ToPlay != "B" {
+		t.Errorf("evaluated position = %+v", evaluated)
+	}
+
+	// From move 1, White is to play; without evaluate the engine is not asked.
+	evaluated = engine.Position{}
+	out, err = tool.Call(ctx, json.RawMessage(`{"sgfContent":"`+sgfContent+`","moveNumber":1,"moves":["cc"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := out.(*Variation); v.Moves[0].Color != "W" || v.Moves[0].MoveNumber != 2 || v.Evaluation != nil || evaluated.Size != 0 {
+		t.Errorf("variation = %+v", v)
+	}
+	if _, err := tool.Call(ctx, json.RawMessage(`{"sgfContent":"`+sgfContent+`","moves":["zz9"]}`)); err == nil {
+		t.Error("accepted an invalid point")
+	}
+
+	// Only variations that play out use up an image.
+	used := 0
+	guarded := WithImageGuard(ctx, func() error { used++; return nil })
+	tool.Call(guarded, json.RawMessage(`{"sgfContent":"`+sgfContent+`","moves":["ee"]}`))
+	if _, err := tool.Call(guarded, json.RawMessage(`{"sgfContent":"`+sgfContent+`","moves":["cc"]}`)); err != nil || used != 1 {
+		t.Errorf("guarded calls: %v, %d images used", err, used)
+	}
+
+	// Boards GTP cannot address are explored without vertices, and refused
+	// an evaluation.
+	const large = "(;SZ[26];B[za])"
+	out, err = tool.Call(ctx, json.RawMessage(`{"sgfContent":"`+large+`","moves":["zb"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := out.(*Variation); v.Moves[0].Color != "W" || v.Moves[0].Vertex != "" {
+		t.Errorf("variation = %+v", v)
+	}
+	if _, err := tool.Call(ctx, json.RawMessage(`{"sgfContent":"`+large+`","moves":["zb"],"evaluate":true}`)); err == nil {
+		t.Error("evaluated a 26x26 board")
+	}
+}
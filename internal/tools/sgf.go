@@ -0,0 +1,127 @@
+// Package tools holds the coaching tools shared by the AI agent and the MCP
+// server.
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/sweetfish329/sai/internal/game"
+	"github.com/sweetfish329/sai/internal/image"
+	"github.com/sweetfish329/sai/internal/katrain"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+const (
+	ReadSgfDescription            = "Read and parse an SGF file content to extract game information. If the file contains KaTrain analysis, engineAnalysis lists per-move winrate and score lead (Black's view), the prior of the played move and the engine's best moves; engineAnalysisSkipped lists the move numbers (0 for the start) whose analysis could not be read. finalCount is a count of the final position (only meaningful if the game was played out, not resigned); komiAssumed in it means the record gives no komi and the rule set's usual komi was counted. finalCountError tells why the position could not be counted, e.g. an illegal move."
+	GenerateBoardImageDescription = "Generate an image of the Go board at a specific move number from an SGF file."
+)
+
+// ReadSgf parses a game record and returns its summary: game info, the
+// first moves, KaTrain engine numbers if present and a count of the final
+// position.
+func ReadSgf(sgfContent string) (map[string]interface{}, error) {
+	rootNodes, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(rootNodes) == 0 {
+		return nil, fmt.Errorf("No game found")
+	}
+
+	// Our ExtractGameData already slices moves to 20.
+	data := sgf.ExtractGameData(rootNodes[0])
+	resMap := map[string]interface{}{
+		"gameInfo":   data.GameInfo,
+		"movesCount": data.MovesCount,
+		"moves":      data.Moves,
+	}
+	// KaTrain files carry real engine numbers; hand them over so the model
+	// does not have to guess.
+	analysis, skipped := katrain.MainLine(rootNodes[0])
+	if summaries := katrain.Summarize(analysis, 3, 6); len(summaries) > 0 {
+		resMap["engineAnalysis"] = summaries
+	}
+	// Damaged nodes only cost their own moves; say which ones.
+	if len(skipped) > 0 {
+		var moves []int
+		for _, e := range skipped {
+			log.Printf("Failed to decode KaTrain analysis: %v", e)
+			moves = append(moves, e.MoveNumber)
+		}
+		resMap["engineAnalysisSkipped"] = moves
+	}
+	// Counting the final position lets the model check RE and explain close
+	// results.
+	if score, err := game.ScoreSGF(rootNodes[0], nil); err != nil {
+		resMap["finalCountError"] = err.Error()
+	} else {
+		resMap["finalCount"] = map[string]interface{}{
+			"result":      score.Result(),
+			"scoring":     score.Scoring,
+			"black":       score.Black,
+			"white":       score.White,
+			"komiAssumed": score.KomiAssumed,
+		}
+	}
+	return resMap, nil
+}
+
+// SgfInput is the input of tools that take a game record.
+type SgfInput struct {
+	SgfContent string `json:"sgfContent" jsonschema_description:"The content of the SGF file"`
+}
+
+// BoardImageInput is the input of GenerateBoardImageTool.
+type BoardImageInput struct {
+	SgfContent string `json:"sgfContent" jsonschema_description:"The content of the SGF file"`
+	MoveNumber *int   `json:"moveNumber,omitempty" jsonschema_description:"The move number to generate the image for. If omitted, generates for the last move."`
+}
+
+// BoardImage is a rendered board.
+type BoardImage struct {
+	// Image is a PNG data URL.
+	Image string `json:"image"`
+}
+
+type imageGuardKey struct{}
+
+// WithImageGuard returns a context in which GenerateBoardImageTool asks
+// allow before drawing; an error from allow refuses the image, e.g. when
+// the user's quota is used up. A nil allow leaves ctx unchanged.
+func WithImageGuard(ctx context.Context, allow func() error) context.Context {
+	if allow == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, imageGuardKey{}, allow)
+}
+
+// ImageGuardFrom returns the image guard of ctx, or nil.
+func ImageGuardFrom(ctx context.Context) func() error {
+	allow, _ := ctx.Value(imageGuardKey{}).(func() error)
+	return allow
+}
+
+var (
+	ReadSgfTool = New("readSgf", ReadSgfDescription, func(ctx context.Context, in SgfInput) (map[string]interface{}, error) {
+		return ReadSgf(in.SgfContent)
+	})
+
+	GenerateBoardImageTool = New("generateBoardImage", GenerateBoardImageDescription, func(ctx context.Context, in BoardImageInput) (BoardImage, error) {
+		if allow := ImageGuardFrom(ctx); allow != nil {
+			if err := allow(); err != nil {
+				return BoardImage{}, err
+			}
+		}
+		moveNumber := -1
+		if in.MoveNumber != nil {
+			moveNumber = *in.MoveNumber
+		}
+		img, err := image.GenerateBoardImage(in.SgfContent, moveNumber)
+		if err != nil {
+			return BoardImage{}, err
+		}
+		return BoardImage{Image: img}, nil
+	})
+)
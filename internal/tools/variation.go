@@ -0,0 +1,192 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sweetfish329/sai/internal/engine"
+	"github.com/sweetfish329/sai/internal/game"
+	"github.com/sweetfish329/sai/internal/image"
+	"github.com/sweetfish329/sai/internal/sgf"
+)
+
+const ExploreVariationDescription = "Play a hypothetical sequence of moves from a position of the game and show the result: the board after the sequence as an image and as text, the stones each move captured and, if requested and an engine is configured, the engine's evaluation of the resulting position. Illegal moves are rejected with the reason. Use it to show what would have happened after a better move instead of only describing it."
+
+// ErrEvaluation marks errors of the engine, rather than of the variation.
+var ErrEvaluation = errors.New("evaluating the variation")
+
+// VariationInput is the input of the variation tool.
+type VariationInput struct {
+	SgfContent string   `json:"sgfContent" jsonschema_description:"The content of the SGF file"`
+	MoveNumber *int     `json:"moveNumber,omitempty" jsonschema_description:"The number of main-line moves played before the variation starts. If omitted, the variation starts from the final position."`
+	Moves      []string `json:"moves" jsonschema_description:"The hypothetical moves in order, alternating colours and starting with the player to move, in SGF (e.g. \"dd\") or GTP (e.g. \"D4\") coordinates, or \"pass\"."`
+	Evaluate   bool     `json:"evaluate,omitempty" jsonschema_description:"Also evaluate the resulting position with the engine."`
+}
+
+// VariationMove is a move of a variation.
+type VariationMove struct {
+	// MoveNumber counts from the start of the game.
+	MoveNumber int    `json:"moveNumber"`
+	Color      string `json:"color"`
+	// Point and Vertex are the move in SGF and GTP coordinates; both are
+	// "pass" for a pass. Vertex is empty on boards larger than GTP allows.
+	Point    string `json:"point"`
+	Vertex   string `json:"vertex,omitempty"`
+	Captured int    `json:"captured"`
+}
+
+// Variation is the position a variation leads to.
+type Variation struct {
+	Moves []VariationMove `json:"moves"`
+	// Captures are the stones each colour captured during the variation.
+	Captures map[string]int `json:"captures"`
+	// Board lists the rows from the top, X for Black, O for White and .
+	// for empty points.
+	Board  []string `json:"board"`
+	ToPlay string   `json:"toPlay"`
+	// Image is a PNG data URL of the board, with the variation's moves
+	// numbered from 1.
+	Image      string             `json:"image,omitempty"`
+	Evaluation *engine.Evaluation `json:"evaluation,omitempty"`
+}
+
+// PlayVariation plays moves from the position after moveNumber moves of
+// the main line (the final position if negative) under the rules of the
+// game. When evaluate is set it is given the resulting position. The
+// image guard of ctx, if any, is asked before the board is drawn.
+func PlayVariation(ctx context.Context, sgfContent string, moveNumber int, moves []string, evaluate func(context.Context, engine.Position) (*engine.Evaluation, error)) (*Variation, error) {
+	roots, err := sgf.Parse(sgfContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no game found")
+	}
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("no moves to play")
+	}
+	g, err := game.FromSGF(roots[0], moveNumber)
+	if err != nil {
+		return nil, err
+	}
+	size := g.Board.Size
+	// The engine position is only needed for an evaluation; boards GTP
+	// cannot address can still be explored without one.
+	var pos engine.Position
+	if evaluate != nil {
+		if pos, err = engine.PositionFromSGF(roots[0], moveNumber); err != nil {
+			return nil, err
+		}
+	}
+
+	start := len(g.Moves())
+	v := &Variation{Captures: map[string]int{"B": 0, "W": 0}, ToPlay: toPlay(roots[0], g)}
+	labels := map[[2]int]string{}
+	for i, mv := range moves {
+		color := v.ToPlay
+		c := game.Black
+		if color == "W" {
+			c = game.White
+		}
+		x, y, pass, err := parseMove(mv, size)
+		if err != nil {
+			return nil, fmt.Errorf("move %d of the variation: %w", i+1, err)
+		}
+		played := VariationMove{MoveNumber: start + i + 1, Color: color, Point: "pass", Vertex: "pass"}
+		if pass {
+			g.Pass(c)
+		} else {
+			before := g.Captures(c)
+			if err := g.Play(x, y, c); err != nil {
+				return nil, fmt.Errorf("move %d of the variation (%s %s): %w", i+1, color, mv, err)
+			}
+			played.Point = game.PointString(x, y)
+			played.Vertex, _ = game.GTPPoint(x, y, size)
+			played.Captured = g.Captures(c) - before
+			v.Captures[color] += played.Captured
+			labels[[2]int{x, y}] = fmt.Sprint(i + 1)
+		}
+		if evaluate != nil {
+			pos.Play(color, played.Vertex)
+		}
+		v.Moves = append(v.Moves, played)
+		v.ToPlay = c.Opponent().String()
+	}
+
+	for y := 0; y < size; y++ {
+		var row strings.Builder
+		for x := 0; x < size; x++ {
+			row.WriteByte(".XO"[g.Board.Get(x, y)])
+		}
+		v.Board = append(v.Board, row.String())
+	}
+	// Only label stones still on the board.
+	for p := range labels {
+		if g.Board.Get(p[0], p[1]) == game.Empty {
+			delete(labels, p)
+		}
+	}
+	// The image counts against the guard of ctx only once the moves are
+	// known to be legal.
+	if allow := ImageGuardFrom(ctx); allow != nil {
+		if err := allow(); err != nil {
+			return nil, err
+		}
+	}
+	if v.Image, err = image.RenderBoard(g.Board, labels); err != nil {
+		return nil, err
+	}
+	if evaluate != nil {
+		if v.Evaluation, err = evaluate(ctx, pos); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrEvaluation, err)
+		}
+	}
+	return v, nil
+}
+
+// toPlay returns whose turn it is in g, the main line of root replayed up
+// to some move: after a move the opponent's, at the start White in
+// handicap games, with PL on the root taking precedence.
+func toPlay(root *sgf.Node, g *game.Game) string {
+	if moves := g.Moves(); len(moves) > 0 {
+		return moves[len(moves)-1].Color.Opponent().String()
+	}
+	if pl := root.Get("PL"); pl == "B" || pl == "W" {
+		return pl
+	}
+	if len(root.Properties["AB"]) > 0 && len(root.Properties["AW"]) == 0 {
+		return "W"
+	}
+	return "B"
+}
+
+// parseMove reads a move in SGF or GTP coordinates, or "pass".
+func parseMove(s string, size int) (x, y int, pass bool, err error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "pass") {
+		return 0, 0, true, nil
+	}
+	if len(s) == 2 && s[0] >= 'a' && s[0] <= 'z' && s[1] >= 'a' && s[1] <= 'z' {
+		return game.ParsePoint(s, size)
+	}
+	return game.ParseGTP(s, size)
+}
+
+// NewVariationTool returns the exploreVariation tool. evaluate, when set,
+// evaluates the resulting position for requests that ask for it; without
+// it, or when it returns nil, results carry no evaluation.
+func NewVariationTool(evaluate func(context.Context, engine.Position) (*engine.Evaluation, error)) *Tool {
+	return New("exploreVariation", ExploreVariationDescription, func(ctx context.Context, in VariationInput) (*Variation, error) {
+		moveNumber := -1
+		if in.MoveNumber != nil {
+			moveNumber = *in.MoveNumber
+		}
+		var eval func(context.Context, engine.Position) (*engine.Evaluation, error)
+		if in.Evaluate {
+			eval = evaluate
+		}
+		return PlayVariation(ctx, in.SgfContent, moveNumber, in.Moves, eval)
+	})
+}
@@ -2,10 +2,10 @@ import { spawn } from 'child_process'
 import path from 'path'
 
 async function testMcpServer() {
-  const serverPath = path.join(process.cwd(), 'dist', 'mcp-server.js')
+  const serverPath = path.join(process.cwd(), 'cmd', 'mcp')
   console.log(`Starting MCP server from: ${serverPath}`)
 
-  const serverProcess = spawn('node', [serverPath], {
+  const serverProcess = spawn('go', ['run', serverPath], {
     stdio: ['pipe', 'pipe', 'inherit'],
   })
 